@@ -1,8 +1,11 @@
 package confort
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,30 +13,41 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/daichitakahashi/confort/internal/beacon"
+	"github.com/daichitakahashi/confort/internal/exclusion"
+	"github.com/daichitakahashi/confort/internal/logging"
 	"github.com/daichitakahashi/confort/wait"
 	"github.com/docker/cli/cli/command/image/build"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/lestrrat-go/backoff/v2"
+	"github.com/moby/buildkit/session"
 	"go.uber.org/multierr"
 )
 
 type (
 	Backend interface {
 		Namespace(ctx context.Context, namespace string) (Namespace, error)
-		BuildImage(ctx context.Context, buildContext io.Reader, buildOptions types.ImageBuildOptions, force bool, buildOut io.Writer) error
+		BuildImage(ctx context.Context, buildContext io.Reader, buildOptions types.ImageBuildOptions, attachables []session.Attachable,
+			force bool, hash string, buildOut io.Writer) error
 	}
 
 	Namespace interface {
@@ -41,9 +55,27 @@ type (
 		Network() *types.NetworkResource
 
 		CreateContainer(ctx context.Context, name string, container *container.Config, host *container.HostConfig,
-			network *network.NetworkingConfig, configConsistency bool,
-			wait *wait.Waiter, pullOptions *types.ImagePullOptions, pullOut io.Writer) (string, error)
+			network *network.NetworkingConfig, configConsistency bool, consistencyModes map[string]ConsistencyMode, stopped StoppedContainerPolicy,
+			wait *wait.Waiter, pullPolicy PullPolicy, pullOptions *types.ImagePullOptions, pullOut io.Writer) (string, error)
 		StartContainer(ctx context.Context, name string) (Ports, error)
+		RestartContainer(ctx context.Context, name string) (Ports, error)
+		SyncContainer(ctx context.Context, name string) error
+		ContainerID(name string) (string, error)
+		ContainerPorts(name string) (Ports, error)
+		ContainerIPAddress(name string) (string, error)
+		StopContainer(ctx context.Context, name string) error
+		KillContainer(ctx context.Context, name string) error
+		PauseContainer(ctx context.Context, name string) error
+		UnpauseContainer(ctx context.Context, name string) error
+		DisconnectContainer(ctx context.Context, name string) error
+		ConnectContainer(ctx context.Context, name string) error
+		CreateNetwork(ctx context.Context, name string) (*types.NetworkResource, error)
+		AttachNetwork(ctx context.Context, name, networkName string, aliases []string) error
+		CreateVolume(ctx context.Context, name string) (string, error)
+		NetemContainer(ctx context.Context, name, image string, args []string) error
+		KillProcess(ctx context.Context, image string, pid int, cmd []string, signal string) error
+		SnapshotContainer(ctx context.Context, name, snapshot string) error
+		RestoreContainer(ctx context.Context, name, snapshot string) (string, error)
 		Release(ctx context.Context) error
 	}
 )
@@ -91,10 +123,42 @@ const (
 	ResourcePolicyReuse    ResourcePolicy = beacon.ResourcePolicyReuse
 	ResourcePolicyReusable ResourcePolicy = beacon.ResourcePolicyReusable
 	ResourcePolicyTakeOver ResourcePolicy = beacon.ResourcePolicyTakeOver
+	// ResourcePolicyRecreate is similar to ResourcePolicyReuse, but the existing container
+	// is removed and created again if its configurations differ from the specified ones.
+	// The container being used by other tests or processes is not recreated, and
+	// the creation fails instead. InitFunc runs again on the recreated container.
+	ResourcePolicyRecreate ResourcePolicy = beacon.ResourcePolicyRecreate
+)
+
+// StoppedContainerPolicy specifies how to handle the existing container which is paused or exited.
+type StoppedContainerPolicy string
+
+const (
+	// StoppedContainerError makes Confort.Run fail. This is the default.
+	StoppedContainerError StoppedContainerPolicy = "error"
+	// StoppedContainerResume unpauses the paused container and starts the exited container.
+	// Waiter of the container is performed again.
+	StoppedContainerResume StoppedContainerPolicy = "resume"
+	// StoppedContainerRecreate removes the stopped container and creates a new one.
+	// The recreated container is removed at Release like a newly created one, according to ResourcePolicy.
+	StoppedContainerRecreate StoppedContainerPolicy = "recreate"
+)
+
+// PullPolicy specifies when to pull the image of the container.
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image whenever the container is created.
+	PullAlways PullPolicy = "always"
+	// PullIfMissing pulls the image only if it does not exist locally.
+	PullIfMissing PullPolicy = "if-missing"
+	// PullNever never pulls the image. If the image does not exist, creation of the container fails.
+	PullNever PullPolicy = "never"
 )
 
 type dockerBackend struct {
 	cli    *client.Client // inject
+	ex     exclusion.Control
 	policy ResourcePolicy
 	labels map[string]string
 }
@@ -103,41 +167,10 @@ func (d *dockerBackend) Namespace(ctx context.Context, namespace string) (Namesp
 	networkName := namespace
 	namespace += "-"
 
-	var nw *types.NetworkResource
-	// create network if not exists
-	list, err := d.cli.NetworkList(ctx, types.NetworkListOptions{})
+	nw, nwCreated, err := d.createNetwork(ctx, networkName)
 	if err != nil {
 		return nil, err
 	}
-	for _, n := range list {
-		if n.Name == networkName {
-			if d.policy == ResourcePolicyError {
-				return nil, fmt.Errorf("dockerBackend: network %q already exists", networkName)
-			}
-			nw = &n
-			break
-		}
-	}
-
-	var nwCreated bool
-	if nw == nil {
-		resp, err := d.cli.NetworkCreate(ctx, networkName, types.NetworkCreate{
-			Driver:         "bridge",
-			CheckDuplicate: true,
-			Labels:         d.labels,
-		})
-		if err != nil {
-			return nil, err
-		}
-		n, err := d.cli.NetworkInspect(ctx, resp.ID, types.NetworkInspectOptions{
-			Verbose: true,
-		})
-		if err != nil {
-			return nil, err
-		}
-		nwCreated = true
-		nw = &n
-	}
 
 	// resolve host ip
 	hostIP, err := resolveHostIP(d.cli.DaemonHost(), nw.IPAM)
@@ -160,9 +193,43 @@ func (d *dockerBackend) Namespace(ctx context.Context, namespace string) (Namesp
 		labels:        d.labels,
 		terminate:     term,
 		containers:    map[string]*containerInfo{},
+		networks:      map[string]*types.NetworkResource{},
+		volumes:       map[string]bool{},
 	}, nil
 }
 
+// createNetwork creates the network if not exists.
+func (d *dockerBackend) createNetwork(ctx context.Context, networkName string) (*types.NetworkResource, bool, error) {
+	list, err := d.cli.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	for _, n := range list {
+		if n.Name == networkName {
+			if d.policy == ResourcePolicyError {
+				return nil, false, fmt.Errorf("dockerBackend: network %q already exists", networkName)
+			}
+			return &n, false, nil
+		}
+	}
+
+	resp, err := d.cli.NetworkCreate(ctx, networkName, types.NetworkCreate{
+		Driver:         "bridge",
+		CheckDuplicate: true,
+		Labels:         d.labels,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := d.cli.NetworkInspect(ctx, resp.ID, types.NetworkInspectOptions{
+		Verbose: true,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &n, true, nil
+}
+
 // see: https://github.com/testcontainers/testcontainers-go/blob/34481cf9027b79aaad4f6aa2dbdb7091dd9c49fb/docker.go#L1245
 func resolveHostIP(daemonHost string, ipamConfig network.IPAM) (string, error) {
 	hostURL, err := url.Parse(daemonHost)
@@ -195,10 +262,27 @@ func resolveHostIP(daemonHost string, ipamConfig network.IPAM) (string, error) {
 	}
 }
 
-func (d *dockerBackend) BuildImage(ctx context.Context, buildContext io.Reader, buildOptions types.ImageBuildOptions, force bool, buildOut io.Writer) (err error) {
+// BuildImage builds the image. If force is false, it skips building when the image
+// with the same tag already exists. If hash is not empty, the existing image is
+// reused only if its label labelBuildHash equals to hash.
+// If BuildKit is specified as the builder version, the session with attachables is
+// started during the build.
+func (d *dockerBackend) BuildImage(ctx context.Context, buildContext io.Reader, buildOptions types.ImageBuildOptions, attachables []session.Attachable,
+	force bool, hash string, buildOut io.Writer) (err error) {
 	image := buildOptions.Tags[0]
 
-	if !force {
+	if !force && hash != "" {
+		// check if the image built from the same content already exists
+		info, _, err := d.cli.ImageInspectWithRaw(ctx, image)
+		if err == nil {
+			if info.Config != nil && info.Config.Labels[labelBuildHash] == hash {
+				return nil
+			}
+			logging.Debugf("build hash of image %q is changed", image)
+		} else if !errdefs.IsNotFound(err) {
+			return err
+		}
+	} else if !force {
 		// check if the same image already exists
 		summaries, err := d.cli.ImageList(ctx, types.ImageListOptions{
 			All: true,
@@ -215,6 +299,26 @@ func (d *dockerBackend) BuildImage(ctx context.Context, buildContext io.Reader,
 		}
 	}
 
+	if hash != "" {
+		labels := make(map[string]string, len(buildOptions.Labels)+1)
+		for k, v := range buildOptions.Labels {
+			labels[k] = v
+		}
+		labels[labelBuildHash] = hash
+		buildOptions.Labels = labels
+	}
+
+	if buildOptions.Version == types.BuilderBuildKit {
+		s, err := d.startBuildKitSession(ctx, buildOptions, attachables)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = multierr.Append(err, s.Close())
+		}()
+		buildOptions.SessionID = s.ID()
+	}
+
 	resp, err := d.cli.ImageBuild(ctx, buildContext, buildOptions)
 	if err != nil {
 		return err
@@ -239,7 +343,7 @@ func createArchive(ctxDir, dockerfilePath string) (io.ReadCloser, string, error)
 
 	// We have to include docker-ignored Dockerfile and .dockerignore for build.
 	// When `ADD` or `COPY` executes, daemon excludes these docker-ignored files.
-	excludes = build.TrimBuildFilesFromExcludes(excludes, relDockerfile, false)
+	excludes = build.TrimBuildFilesFromExcludes(excludes, relDockerfile, dockerfilePath == "-")
 
 	err = build.ValidateContextDirectory(absContextDir, excludes)
 	if err != nil {
@@ -257,6 +361,61 @@ func createArchive(ctxDir, dockerfilePath string) (io.ReadCloser, string, error)
 	return tarball, relDockerfile, nil
 }
 
+// labelBuildHash is attached to the image built with WithBuildHash, and holds
+// the hash of its build context and build options.
+const labelBuildHash = "daichitakahashi.confort.build.hash"
+
+// hashBuildContext calculates the hash of the build context tarball and the options
+// affecting the build result. The timestamps, owners and order of the files are
+// ignored, so that the hash only changes when the content of the build context changes.
+// Because the tarball is consumed, it returns the reader of the buffered tarball.
+func hashBuildContext(tarball io.Reader, buildOptions types.ImageBuildOptions) (io.Reader, string, error) {
+	buf := new(bytes.Buffer)
+	h := sha256.New()
+
+	tee := io.TeeReader(tarball, buf)
+	tr := tar.NewReader(tee)
+	var files []string
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, "", err
+		}
+		fh := sha256.New()
+		if _, err := io.Copy(fh, tr); err != nil {
+			return nil, "", err
+		}
+		files = append(files, fmt.Sprintf("file\x00%s\x00%c\x00%o\x00%s\x00%x\x00", hdr.Name, hdr.Typeflag, hdr.Mode, hdr.Linkname, fh.Sum(nil)))
+	}
+	// read the padding after the end of the archive
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return nil, "", err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		_, _ = io.WriteString(h, f)
+	}
+
+	_, _ = fmt.Fprintf(h, "dockerfile\x00%s\x00", buildOptions.Dockerfile)
+	keys := make([]string, 0, len(buildOptions.BuildArgs))
+	for k := range buildOptions.BuildArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v := buildOptions.BuildArgs[k]; v != nil {
+			_, _ = fmt.Fprintf(h, "arg\x00%s\x00=%s\x00", k, *v)
+		} else {
+			_, _ = fmt.Fprintf(h, "arg\x00%s\x00", k)
+		}
+	}
+	_, _ = fmt.Fprintf(h, "target\x00%s\x00platform\x00%s\x00", buildOptions.Target, buildOptions.Platform)
+
+	return buf, hex.EncodeToString(h.Sum(nil)), nil
+}
+
 var _ Backend = (*dockerBackend)(nil)
 
 type dockerNamespace struct {
@@ -269,6 +428,8 @@ type dockerNamespace struct {
 	m          sync.RWMutex
 	terminate  []func(ctx context.Context) error
 	containers map[string]*containerInfo
+	networks   map[string]*types.NetworkResource // additional networks
+	volumes    map[string]bool
 }
 
 type containerInfo struct {
@@ -277,8 +438,11 @@ type containerInfo struct {
 	host        *container.HostConfig
 	network     *network.NetworkingConfig
 	ports       Ports
+	ipAddress   string // in the namespace network
 	wait        *wait.Waiter
 	running     bool
+
+	removeOnRelease bool
 }
 
 func (d *dockerNamespace) Namespace() string {
@@ -291,34 +455,48 @@ func (d *dockerNamespace) Network() *types.NetworkResource {
 
 func (d *dockerNamespace) CreateContainer(
 	ctx context.Context, name string, container *container.Config,
-	host *container.HostConfig, networking *network.NetworkingConfig, configConsistency bool,
-	wait *wait.Waiter, pullOptions *types.ImagePullOptions, pullOut io.Writer,
+	host *container.HostConfig, networking *network.NetworkingConfig,
+	configConsistency bool, consistencyModes map[string]ConsistencyMode,
+	stopped StoppedContainerPolicy, wait *wait.Waiter,
+	pullPolicy PullPolicy, pullOptions *types.ImagePullOptions, pullOut io.Writer,
 ) (string, error) {
-	var err error
+	// fingerprint of the configurations is calculated before merging labels
+	fp, err := fingerprint(container, host, networking)
+	if err != nil {
+		return "", err
+	}
 
 	// merge labels
-	if container.Labels == nil {
-		container.Labels = d.labels
-	} else {
-		for k, v := range d.labels {
-			container.Labels[k] = v
-		}
+	labels := make(map[string]string, len(container.Labels)+len(d.labels)+1)
+	for k, v := range container.Labels {
+		labels[k] = v
+	}
+	for k, v := range d.labels {
+		labels[k] = v
 	}
+	labels[labelFingerprint] = fp
+	container.Labels = labels
 
 	d.m.Lock()
 	defer d.m.Unlock()
 
 	fullName := "/" + name
-	c, ok := d.containers[name]
+	cached, ok := d.containers[name]
 	if ok {
-		if configConsistency {
-			err = checkConfigConsistency(
-				container, c.container,
-				host, c.host,
-				networking.EndpointsConfig, c.network.EndpointsConfig,
-			)
+		if d.policy == ResourcePolicyRecreate && cached.container.Labels[labelFingerprint] != fp {
+			// recreate the container below, even if it is created in this process
+			logging.Debugf("configurations of container %q are changed", name)
+		} else {
+			if configConsistency {
+				err = checkConfigConsistency(
+					name, consistencyModes,
+					container, cached.container,
+					host, cached.host,
+					networking.EndpointsConfig, cached.network.EndpointsConfig,
+				)
+			}
+			return cached.containerID, err
 		}
-		return c.containerID, err
 	}
 
 	containers, err := d.cli.ContainerList(ctx, types.ContainerListOptions{
@@ -335,7 +513,9 @@ LOOP:
 	for _, c := range containers {
 		for _, n := range c.Names {
 			if fullName == n {
-				if c.Image != container.Image {
+				// with ResourcePolicyRecreate, the container of the other image is recreated
+				if c.Image != container.Image && c.Labels[labelSnapshotImage] != container.Image &&
+					d.policy != ResourcePolicyRecreate {
 					return "", errors.New(containerNameConflict(name, container.Image, c.Image))
 				}
 				existing = &c
@@ -344,9 +524,52 @@ LOOP:
 		}
 	}
 
+	if existing != nil && d.policy == ResourcePolicyError {
+		return "", fmt.Errorf("dockerNamespace: container %q(%s) already exists", name, container.Image)
+	}
+	// the one-shot container exited successfully is neither started nor recreated again
+	var completed bool
+	if existing != nil && existing.State == "exited" && existing.Labels[labelOneShot] != "" {
+		info, err := d.cli.ContainerInspect(ctx, existing.ID)
+		if err != nil {
+			return "", err
+		}
+		completed = info.State.ExitCode == 0
+	}
+	recreate := existing != nil && stopped == StoppedContainerRecreate && !completed &&
+		existing.State != "running" && existing.State != "created"
+	if existing != nil && d.policy == ResourcePolicyRecreate && existing.Labels[labelFingerprint] != fp {
+		logging.Debugf("configurations of container %q are changed", name)
+		recreate = true
+	}
+	if recreate {
+		// Refuse to remove the container while other tests or processes use it, and
+		// make InitFunc run again on the recreated container.
+		logging.Debugf("acquire LockForContainerRecreate: %s", name)
+		unlock, err := d.ex.LockForContainerRecreate(ctx, name)
+		if err != nil {
+			return "", fmt.Errorf("dockerNamespace: cannot recreate %q: %w", name, err)
+		}
+		defer func() {
+			logging.Debugf("release LockForContainerRecreate: %s", name)
+			unlock()
+		}()
+
+		// the recreated container is treated as a newly created one on Release
+		err = d.cli.ContainerRemove(ctx, existing.ID, types.ContainerRemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return "", err
+		}
+		existing = nil
+		completed = false
+	}
+
 	// try pull image when container not exists
-	if existing == nil && pullOptions != nil {
-		err := d.pull(ctx, container.Image, *pullOptions, pullOut)
+	if existing == nil {
+		err := d.pullImage(ctx, container.Image, pullPolicy, pullOptions, pullOut)
 		if err != nil {
 			return "", err
 		}
@@ -355,15 +578,17 @@ LOOP:
 	var containerID string
 	var connected bool
 	if existing != nil {
-		if d.policy == ResourcePolicyError {
-			return "", fmt.Errorf("dockerNamespace: container %q(%s) already exists", name, container.Image)
-		}
 		info, err := d.cli.ContainerInspect(ctx, existing.ID)
 		if err != nil {
 			return "", err
 		}
+		if image, ok := info.Config.Labels[labelSnapshotImage]; ok {
+			// restored from the snapshot
+			info.Config.Image = image
+		}
 		if configConsistency {
 			err = checkConfigConsistency(
+				name, consistencyModes,
 				container, info.Config,
 				host, info.HostConfig,
 				networking.EndpointsConfig, info.NetworkSettings.Networks,
@@ -375,35 +600,45 @@ LOOP:
 
 		switch existing.State {
 		case "running", "created":
-			containerID = existing.ID
-
-			var found bool
-			for _, setting := range existing.NetworkSettings.Networks {
-				if setting.NetworkID == d.network.ID {
-					found = true
-					break
-				}
+		case "paused", "exited":
+			if completed {
+				break
 			}
-			if !found {
-				err := d.cli.NetworkConnect(ctx, d.network.ID, containerID, &network.EndpointSettings{
-					NetworkID: d.network.ID,
-					Aliases: []string{
-						strings.TrimPrefix(name, d.namespace),
-					},
-				})
+			if stopped != StoppedContainerResume {
+				// MEMO: bound port is still existing while paused
+				return "", fmt.Errorf("dockerNamespace: cannot start %q, container is %s (resume or recreate it with WithStoppedContainerPolicy)", name, existing.State)
+			}
+			if existing.State == "paused" {
+				err := d.cli.ContainerUnpause(ctx, existing.ID)
 				if err != nil {
 					return "", err
 				}
-				connected = true
 			}
-
-		case "paused":
-			// MEMO: bound port is still existing
-			return "", fmt.Errorf("dockerNamespace: cannot start %q, unpause is not supported", name)
-
+			// exited container is started by StartContainer
 		default:
 			return "", fmt.Errorf("dockerNamespace: cannot start %q, unexpected container state %q", name, existing.State)
 		}
+		containerID = existing.ID
+
+		var found bool
+		for _, setting := range existing.NetworkSettings.Networks {
+			if setting.NetworkID == d.network.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			err := d.cli.NetworkConnect(ctx, d.network.ID, containerID, &network.EndpointSettings{
+				NetworkID: d.network.ID,
+				Aliases: []string{
+					strings.TrimPrefix(name, d.namespace),
+				},
+			})
+			if err != nil {
+				return "", err
+			}
+			connected = true
+		}
 	} else {
 		created, err := d.cli.ContainerCreate(ctx, container, host, networking, nil, name)
 		if err != nil {
@@ -412,29 +647,95 @@ LOOP:
 		containerID = created.ID
 	}
 
-	if (existing == nil && d.policy != ResourcePolicyReusable) || d.policy == ResourcePolicyTakeOver {
+	// container id can be changed by RestoreContainer, so refer the latest one
+	removeOnRelease := (existing == nil && d.policy != ResourcePolicyReusable) || d.policy == ResourcePolicyTakeOver
+	if removeOnRelease && (cached == nil || !cached.removeOnRelease) {
 		d.terminate = append(d.terminate, func(ctx context.Context) error {
-			return d.cli.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{
-				Force:         true,
-				RemoveVolumes: true,
-			})
+			return d.removeContainer(ctx, d.containers[name])
 		})
 	} else if connected {
 		d.terminate = append(d.terminate, func(ctx context.Context) error {
-			return d.cli.NetworkDisconnect(ctx, d.network.ID, containerID, true)
+			c := d.containers[name]
+			if c.removeOnRelease {
+				return nil // recreated after connection
+			}
+			return d.cli.NetworkDisconnect(ctx, d.network.ID, c.containerID, true)
 		})
 	}
 	d.containers[name] = &containerInfo{
-		containerID: containerID,
-		container:   container,
-		host:        host,
-		network:     networking,
-		wait:        wait,
-		running:     false,
+		containerID:     containerID,
+		container:       container,
+		host:            host,
+		network:         networking,
+		wait:            wait,
+		running:         completed, // not to start the completed container again
+		removeOnRelease: removeOnRelease || (cached != nil && cached.removeOnRelease),
 	}
 	return containerID, nil
 }
 
+// removeContainer removes the container at Release. The container collecting the
+// coverage data is stopped gracefully before removal, to make the process write it.
+func (d *dockerNamespace) removeContainer(ctx context.Context, c *containerInfo) error {
+	_, cover := c.container.Labels[beacon.LabelCoverage]
+	if cover {
+		err := d.cli.ContainerStop(ctx, c.containerID, container.StopOptions{})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+	}
+	err := d.cli.ContainerRemove(ctx, c.containerID, types.ContainerRemoveOptions{
+		Force:         true,
+		RemoveVolumes: true,
+	})
+	if cover && errdefs.IsNotFound(err) {
+		return nil // removed automatically after stop
+	}
+	return err
+}
+
+// pullImage pulls the image according to the policy. Only one process sharing the
+// exclusion control pulls the same image at a time, and others wait for it.
+func (d *dockerNamespace) pullImage(ctx context.Context, image string, policy PullPolicy, pullOptions *types.ImagePullOptions, out io.Writer) error {
+	if policy == PullNever {
+		return nil
+	}
+	exists := func() (bool, error) {
+		_, _, err := d.cli.ImageInspectWithRaw(ctx, image)
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if policy == PullIfMissing {
+		if ok, err := exists(); err != nil || ok {
+			return err
+		}
+	}
+
+	logging.Debugf("acquire LockForPull: %s", image)
+	unlock, err := d.ex.LockForPull(ctx, image)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		logging.Debugf("release LockForPull: %s", image)
+		unlock()
+	}()
+
+	if policy == PullIfMissing {
+		// pulled by other process while waiting
+		if ok, err := exists(); err != nil || ok {
+			return err
+		}
+	}
+	opts := types.ImagePullOptions{}
+	if pullOptions != nil {
+		opts = *pullOptions
+	}
+	return d.pull(ctx, image, opts, out)
+}
+
 func (d *dockerNamespace) pull(ctx context.Context, image string, pullOptions types.ImagePullOptions, out io.Writer) (err error) {
 	rc, err := d.cli.ImagePull(ctx, image, pullOptions)
 	if err != nil {
@@ -450,11 +751,9 @@ func containerNameConflict(name, wantImage, gotImage string) string {
 	return fmt.Sprintf("container name %q already exists but image is not %q(%q)", name, wantImage, gotImage)
 }
 
-func (d *dockerNamespace) containerPortMap(ctx context.Context, containerID string, requiredPorts nat.PortSet) (nat.PortMap, error) {
-	if len(requiredPorts) == 0 {
-		return nat.PortMap{}, nil
-	}
-
+// containerEndpoints returns the host port bindings of the required ports and the IP
+// address of the container in the namespace network.
+func (d *dockerNamespace) containerEndpoints(ctx context.Context, containerID string, requiredPorts nat.PortSet) (nat.PortMap, string, error) {
 	if _, ok := ctx.Deadline(); !ok {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
@@ -469,24 +768,50 @@ retry:
 	for backoff.Continue(b) {
 		i, err := d.cli.ContainerInspect(ctx, containerID)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		portMap, ipAddress, ok := d.inspectedEndpoints(i, requiredPorts)
+		if !ok {
+			continue retry
 		}
+		return portMap, ipAddress, nil
+	}
+	return nil, "", errors.New("cannot get endpoints")
+}
 
-		for p, bindings := range i.NetworkSettings.Ports {
-			if _, ok := requiredPorts[p]; !ok {
-				continue
-			} else if len(bindings) == 0 {
-				// endpoint not bound yet
-				continue retry
-			}
-			// Use this port. Replace host ip.
-			for i := range bindings {
-				bindings[i].HostIP = d.hostIP
-			}
+// inspectedEndpoints extracts the endpoints from the result of ContainerInspect.
+// It returns false if some of the required ports are not bound yet.
+func (d *dockerNamespace) inspectedEndpoints(i types.ContainerJSON, requiredPorts nat.PortSet) (nat.PortMap, string, bool) {
+	var ipAddress string
+	if n, ok := i.NetworkSettings.Networks[d.network.Name]; ok {
+		ipAddress = n.IPAddress
+	}
+	if len(requiredPorts) == 0 {
+		return nat.PortMap{}, ipAddress, true
+	}
+
+	for p, bindings := range i.NetworkSettings.Ports {
+		if _, ok := requiredPorts[p]; !ok {
+			continue
+		} else if len(bindings) == 0 {
+			// endpoint not bound yet
+			return nil, "", false
 		}
-		return i.NetworkSettings.Ports, nil
+		// Use this port. Replace host ip.
+		for i := range bindings {
+			bindings[i].HostIP = d.hostIP
+		}
+	}
+	return i.NetworkSettings.Ports, ipAddress, true
+}
+
+// requiredPorts returns the ports of the container published to the host.
+func requiredPorts(c *containerInfo) nat.PortSet {
+	if len(c.host.PortBindings) == 0 && !c.host.PublishAllPorts {
+		// ports are not published to the host
+		return nil
 	}
-	return nil, errors.New("cannot get endpoints")
+	return c.container.ExposedPorts
 }
 
 func (d *dockerNamespace) StartContainer(ctx context.Context, name string) (Ports, error) {
@@ -503,8 +828,30 @@ func (d *dockerNamespace) StartContainer(ctx context.Context, name string) (Port
 	if err != nil {
 		return nil, err
 	}
+	return d.started(ctx, c)
+}
+
+// RestartContainer restarts the container with ContainerRestart, which doesn't
+// remove the container even if AutoRemove is enabled.
+func (d *dockerNamespace) RestartContainer(ctx context.Context, name string) (Ports, error) {
+	d.m.RLock()
+	c, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
 
-	portMap, err := d.containerPortMap(ctx, c.containerID, c.container.ExposedPorts)
+	err := d.cli.ContainerRestart(ctx, c.containerID, container.StopOptions{})
+	if err != nil {
+		return nil, err
+	}
+	d.stopped(c)
+	return d.started(ctx, c)
+}
+
+// started waits for the started container to be ready, and updates its endpoints.
+func (d *dockerNamespace) started(ctx context.Context, c *containerInfo) (Ports, error) {
+	portMap, ipAddress, err := d.containerEndpoints(ctx, c.containerID, requiredPorts(c))
 	if err != nil {
 		return nil, err
 	}
@@ -520,79 +867,883 @@ func (d *dockerNamespace) StartContainer(ctx context.Context, name string) (Port
 		}
 	}
 
+	d.m.Lock()
 	c.running = true
 	c.ports = Ports(portMap)
+	c.ipAddress = ipAddress
+	d.m.Unlock()
 	return c.ports, nil
 }
 
-func (d *dockerNamespace) Release(ctx context.Context) error {
-	d.m.Lock()
-	defer d.m.Unlock()
-
-	var err error
-	last := len(d.terminate) - 1
-	for i := range d.terminate {
-		err = multierr.Append(err, d.terminate[last-i](ctx))
+// SyncContainer updates the endpoints of the container with the current state,
+// because the container may be restarted by other processes sharing the namespace,
+// and its host ports may be changed.
+func (d *dockerNamespace) SyncContainer(ctx context.Context, name string) error {
+	d.m.RLock()
+	c, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return fmt.Errorf("dockerNamespace: container %q not found", name)
 	}
-	return err
-}
-
-var _ Namespace = (*dockerNamespace)(nil)
-
-// write stream message line by line
-func handleJSONMessageStream(dst io.Writer, src io.Reader) error {
-	dec := json.NewDecoder(src)
-	buf := &bytes.Buffer{}
 
-	for {
-		var msg jsonmessage.JSONMessage
-		err := dec.Decode(&msg)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-		err = msg.Display(buf, false)
-		if err != nil {
-			return err
-		}
-		_, err = dst.Write(buf.Bytes())
-		if err != nil {
-			return err
-		}
-		buf.Reset()
+	i, err := d.cli.ContainerInspect(ctx, name)
+	if err != nil {
+		return err
+	} else if !i.State.Running {
+		// stopped or completed one-shot container
+		return nil
+	}
+	portMap, ipAddress, ok := d.inspectedEndpoints(i, requiredPorts(c))
+	if !ok {
+		return fmt.Errorf("dockerNamespace: endpoints of container %q are not bound", name)
 	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+	c.containerID = i.ID
+	c.running = true
+	c.ports = Ports(portMap)
+	c.ipAddress = ipAddress
 	return nil
 }
 
-// wait.Fetcher implementation
-type fetcher struct {
-	cli         *client.Client
-	containerID string
-	ports       nat.PortMap
+func (d *dockerNamespace) ContainerID(name string) (string, error) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	c, ok := d.containers[name]
+	if !ok {
+		return "", fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	return c.containerID, nil
 }
 
-func (f *fetcher) ContainerID() string {
-	return f.containerID
+func (d *dockerNamespace) ContainerPorts(name string) (Ports, error) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	c, ok := d.containers[name]
+	if !ok {
+		return nil, fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	return c.ports, nil
 }
 
-func (f *fetcher) Status(ctx context.Context) (*types.ContainerState, error) {
-	i, err := f.cli.ContainerInspect(ctx, f.containerID)
-	if err != nil {
-		return nil, err
+func (d *dockerNamespace) ContainerIPAddress(name string) (string, error) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	c, ok := d.containers[name]
+	if !ok {
+		return "", fmt.Errorf("dockerNamespace: container %q not found", name)
 	}
-	return i.State, nil
+	return c.ipAddress, nil
 }
 
-func (f *fetcher) Ports() nat.PortMap {
-	return f.ports
+func (d *dockerNamespace) StopContainer(ctx context.Context, name string) error {
+	c, err := d.stoppableContainer(name, "stop")
+	if err != nil {
+		return err
+	}
+	err = d.cli.ContainerStop(ctx, c.containerID, container.StopOptions{})
+	if err != nil {
+		return err
+	}
+	d.stopped(c)
+	return nil
+}
+
+func (d *dockerNamespace) KillContainer(ctx context.Context, name string) error {
+	c, err := d.stoppableContainer(name, "kill")
+	if err != nil {
+		return err
+	}
+	waitC, errC := d.cli.ContainerWait(ctx, c.containerID, container.WaitConditionNotRunning)
+	err = d.cli.ContainerKill(ctx, c.containerID, "SIGKILL")
+	if err != nil {
+		return err
+	}
+	select {
+	case <-waitC:
+	case err := <-errC:
+		return err
+	}
+	d.stopped(c)
+	return nil
+}
+
+// stoppableContainer returns the container which can be stopped without removal.
+func (d *dockerNamespace) stoppableContainer(name, op string) (*containerInfo, error) {
+	d.m.RLock()
+	c, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("dockerNamespace: container %q not found", name)
+	} else if c.host.AutoRemove {
+		// MEMO: AutoRemove removes the container on exit
+		return nil, fmt.Errorf("dockerNamespace: cannot %s %q, AutoRemove is enabled (create it with WithoutAutoRemove)", op, name)
+	}
+	return c, nil
+}
+
+func (d *dockerNamespace) stopped(c *containerInfo) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	c.running = false
+	c.ports = nil
+	c.ipAddress = ""
+}
+
+func (d *dockerNamespace) PauseContainer(ctx context.Context, name string) error {
+	d.m.RLock()
+	c, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	return d.cli.ContainerPause(ctx, c.containerID)
+}
+
+func (d *dockerNamespace) UnpauseContainer(ctx context.Context, name string) error {
+	d.m.RLock()
+	c, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	return d.cli.ContainerUnpause(ctx, c.containerID)
+}
+
+// labelSnapshotImage is attached to the container restored from the snapshot,
+// and holds the image of the original container.
+const labelSnapshotImage = "daichitakahashi.confort.snapshot.image"
+
+// labelSnapshotVolumes is attached to the snapshot image, and holds the archives
+// of the volumes in JSON.
+const labelSnapshotVolumes = "daichitakahashi.confort.snapshot.volumes"
+
+// snapshotInfo is stored as the labels of the committed image, so that the
+// snapshot taken by a process, e.g. in InitFunc, is available from other processes
+// sharing the namespace.
+type snapshotInfo struct {
+	image   string // id of the committed image
+	dir     string // directory that holds the archives of the volumes
+	volumes []volumeArchive
+}
+
+type volumeArchive struct {
+	Destination string `json:"destination"`
+	File        string `json:"file"`
+}
+
+func snapshotKey(name, snapshot string) string {
+	return name + "@" + snapshot
+}
+
+// lookupSnapshot finds the snapshot image by its labels. It returns nil if not found.
+func (d *dockerNamespace) lookupSnapshot(ctx context.Context, name, snapshot string) (*snapshotInfo, error) {
+	images, err := d.cli.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", beacon.LabelSnapshot+"="+snapshotKey(name, snapshot)),
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, nil
+	}
+	image := images[0]
+	s := &snapshotInfo{
+		image: image.ID,
+		dir:   image.Labels[beacon.LabelSnapshotDir],
+	}
+	if v := image.Labels[labelSnapshotVolumes]; v != "" {
+		err = json.Unmarshal([]byte(v), &s.volumes)
+		if err != nil {
+			return nil, fmt.Errorf("dockerNamespace: invalid label of snapshot %q of container %q: %w", snapshot, name, err)
+		}
+	}
+	return s, nil
+}
+
+func (d *dockerNamespace) SnapshotContainer(ctx context.Context, name, snapshot string) (err error) {
+	key := snapshotKey(name, snapshot)
+	d.m.RLock()
+	_, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	existing, err := d.lookupSnapshot(ctx, name, snapshot)
+	if err != nil {
+		return err
+	} else if existing != nil {
+		return fmt.Errorf("dockerNamespace: snapshot %q of container %q already exists", snapshot, name)
+	}
+
+	// the container can be restored by other process, so refer it by name
+	info, err := d.cli.ContainerInspect(ctx, name)
+	if err != nil {
+		return err
+	}
+	containerID := info.ID
+	// pause the container to take consistent snapshot of the filesystem and volumes
+	if info.State.Running && !info.State.Paused {
+		err = d.cli.ContainerPause(ctx, containerID)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = multierr.Append(err, d.cli.ContainerUnpause(ctx, containerID))
+		}()
+	}
+
+	s := &snapshotInfo{}
+	defer func() {
+		if err != nil {
+			err = multierr.Append(err, d.removeSnapshot(context.Background(), s))
+		}
+	}()
+
+	// the contents of the volumes are not committed
+	for _, m := range info.Mounts {
+		if m.Type != mount.TypeVolume {
+			continue
+		}
+		if s.dir == "" {
+			s.dir, err = os.MkdirTemp("", "confort-snapshot-")
+			if err != nil {
+				return err
+			}
+		}
+		file := filepath.Join(s.dir, fmt.Sprintf("volume-%d.tar", len(s.volumes)))
+		err = d.archiveVolume(ctx, containerID, m.Destination, file)
+		if err != nil {
+			return err
+		}
+		s.volumes = append(s.volumes, volumeArchive{
+			Destination: m.Destination,
+			File:        file,
+		})
+	}
+	volumes, err := json.Marshal(s.volumes)
+	if err != nil {
+		return err
+	}
+
+	// The labels are merged with the ones of the container by the daemon.
+	// They overwrite the labels inherited from the image of the restored container.
+	labels := map[string]string{
+		beacon.LabelSnapshot:    key,
+		beacon.LabelSnapshotDir: s.dir,
+		labelSnapshotVolumes:    string(volumes),
+	}
+	for k, v := range d.labels {
+		labels[k] = v
+	}
+	committed, err := d.cli.ContainerCommit(ctx, containerID, types.ContainerCommitOptions{
+		Comment: fmt.Sprintf("snapshot %q of %s", snapshot, name),
+		Pause:   false,
+		Config: &container.Config{
+			Labels: labels,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	s.image = committed.ID
+
+	d.m.Lock()
+	defer d.m.Unlock()
+	// remove snapshot after all containers are removed
+	d.terminate = append([]func(context.Context) error{
+		func(ctx context.Context) error {
+			return d.removeSnapshot(ctx, s)
+		},
+	}, d.terminate...)
+	return nil
+}
+
+func (d *dockerNamespace) archiveVolume(ctx context.Context, containerID, src, dst string) (err error) {
+	rc, _, err := d.cli.CopyFromContainer(ctx, containerID, src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = multierr.Append(err, rc.Close())
+	}()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = multierr.Append(err, f.Close())
+	}()
+	_, err = io.Copy(f, rc)
+	return err
+}
+
+func (d *dockerNamespace) removeSnapshot(ctx context.Context, s *snapshotInfo) error {
+	var err error
+	if s.dir != "" {
+		err = os.RemoveAll(s.dir)
+	}
+	if s.image == "" {
+		return err // failed to commit
+	}
+	_, e := d.cli.ImageRemove(ctx, s.image, types.ImageRemoveOptions{
+		PruneChildren: true,
+	})
+	if e != nil && !errdefs.IsConflict(e) { // still used by the reused container
+		err = multierr.Append(err, e)
+	}
+	return err
+}
+
+func (d *dockerNamespace) RestoreContainer(ctx context.Context, name, snapshot string) (string, error) {
+	d.m.RLock()
+	c, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	s, err := d.lookupSnapshot(ctx, name, snapshot)
+	if err != nil {
+		return "", err
+	} else if s == nil {
+		return "", fmt.Errorf("dockerNamespace: snapshot %q of container %q not found", snapshot, name)
+	}
+
+	// the container can be restored by other process, so refer it by name
+	info, err := d.cli.ContainerInspect(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if info.State.Running && info.State.Paused {
+		return "", fmt.Errorf("dockerNamespace: cannot restore paused container %q", name)
+	}
+	config, host, endpoints := restoredContainerConfig(info, s.image)
+
+	// recreate the container and volumes
+	err = d.cli.ContainerRemove(ctx, info.ID, types.ContainerRemoveOptions{
+		Force:         true,
+		RemoveVolumes: true, // anonymous volumes only
+	})
+	if err != nil && !errdefs.IsNotFound(err) {
+		return "", err
+	}
+	for _, m := range host.Mounts {
+		if m.Type != mount.TypeVolume || m.Source == "" {
+			continue
+		}
+		err = d.recreateVolume(ctx, m.Source)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var networking *network.NetworkingConfig
+	if es, ok := endpoints[d.network.Name]; ok {
+		networking = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				d.network.Name: es,
+			},
+		}
+		delete(endpoints, d.network.Name)
+	}
+	created, err := d.cli.ContainerCreate(ctx, config, host, networking, nil, name)
+	if err != nil {
+		return "", err
+	}
+	containerID := created.ID
+	for networkName, es := range endpoints {
+		err = d.cli.NetworkConnect(ctx, networkName, containerID, es)
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, v := range s.volumes {
+		err = d.restoreVolume(ctx, containerID, v)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	d.m.Lock()
+	c.containerID = containerID
+	running := c.running
+	c.running = false
+	d.m.Unlock()
+	if running {
+		_, err = d.StartContainer(ctx, name)
+		if err != nil {
+			return "", err
+		}
+	}
+	return containerID, nil
+}
+
+func (d *dockerNamespace) recreateVolume(ctx context.Context, name string) error {
+	v, err := d.cli.VolumeInspect(ctx, name)
+	if err != nil {
+		return err
+	}
+	err = d.cli.VolumeRemove(ctx, name, false)
+	if err != nil {
+		return err
+	}
+	_, err = d.cli.VolumeCreate(ctx, volume.CreateOptions{
+		Name:       v.Name,
+		Driver:     v.Driver,
+		DriverOpts: v.Options,
+		Labels:     v.Labels,
+	})
+	return err
+}
+
+func (d *dockerNamespace) restoreVolume(ctx context.Context, containerID string, v volumeArchive) (err error) {
+	f, err := os.Open(v.File)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = multierr.Append(err, f.Close())
+	}()
+	// the archive contains the directory named after the base name of the destination
+	return d.cli.CopyToContainer(ctx, containerID, path.Dir(v.Destination), f, types.CopyToContainerOptions{
+		CopyUIDGID: true,
+	})
+}
+
+// restoredContainerConfig creates the configurations to recreate the container from
+// the snapshot image. The host ports are pinned to keep the endpoints, and the
+// volumes are mounted without copying the contents of the image.
+func restoredContainerConfig(info types.ContainerJSON, image string) (*container.Config, *container.HostConfig, map[string]*network.EndpointSettings) {
+	config := *info.Config
+	labels := make(map[string]string, len(config.Labels)+1)
+	for k, v := range config.Labels {
+		labels[k] = v
+	}
+	if _, ok := labels[labelSnapshotImage]; !ok {
+		labels[labelSnapshotImage] = config.Image
+	}
+	config.Labels = labels
+	config.Image = image
+	if strings.HasPrefix(info.ID, config.Hostname) {
+		config.Hostname = "" // generated from the container id
+	}
+
+	host := *info.HostConfig
+	host.PortBindings = make(nat.PortMap, len(info.HostConfig.PortBindings))
+	for port, bindings := range info.HostConfig.PortBindings {
+		actual := info.NetworkSettings.Ports[port]
+		pinned := make([]nat.PortBinding, 0, len(bindings))
+		for _, b := range bindings {
+			if (b.HostPort == "" || strings.Contains(b.HostPort, "-")) && len(actual) > 0 {
+				b.HostPort = actual[0].HostPort
+			}
+			pinned = append(pinned, b)
+		}
+		host.PortBindings[port] = pinned
+	}
+
+	// volumes are specified by mounts
+	named := map[string]bool{}
+	host.Binds = nil
+	for _, b := range info.HostConfig.Binds {
+		src, _, _ := strings.Cut(b, ":")
+		if strings.HasPrefix(src, "/") {
+			host.Binds = append(host.Binds, b)
+		} else {
+			named[src] = true
+		}
+	}
+	host.Mounts = nil
+	for _, m := range info.HostConfig.Mounts {
+		if m.Type == mount.TypeVolume {
+			if m.Source != "" {
+				named[m.Source] = true
+			}
+			continue
+		}
+		host.Mounts = append(host.Mounts, m)
+	}
+	for _, m := range info.Mounts {
+		if m.Type != mount.TypeVolume {
+			continue
+		}
+		v := mount.Mount{
+			Type:     mount.TypeVolume,
+			Target:   m.Destination,
+			ReadOnly: !m.RW,
+			VolumeOptions: &mount.VolumeOptions{
+				NoCopy: true,
+			},
+		}
+		if named[m.Name] {
+			v.Source = m.Name
+		}
+		host.Mounts = append(host.Mounts, v)
+	}
+
+	endpoints := make(map[string]*network.EndpointSettings, len(info.NetworkSettings.Networks))
+	for networkName, es := range info.NetworkSettings.Networks {
+		var aliases []string
+		for _, alias := range es.Aliases {
+			if !strings.HasPrefix(info.ID, alias) {
+				aliases = append(aliases, alias)
+			}
+		}
+		endpoints[networkName] = &network.EndpointSettings{
+			NetworkID:  es.NetworkID,
+			IPAMConfig: es.IPAMConfig,
+			Links:      es.Links,
+			Aliases:    aliases,
+		}
+	}
+	return &config, &host, endpoints
+}
+
+func (d *dockerNamespace) CreateNetwork(ctx context.Context, name string) (*types.NetworkResource, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if nw, ok := d.networks[name]; ok {
+		return nw, nil
+	}
+	nw, created, err := d.createNetwork(ctx, d.namespace+name)
+	if err != nil {
+		return nil, err
+	}
+	if (created && d.policy != ResourcePolicyReusable) || d.policy == ResourcePolicyTakeOver {
+		// remove after all containers are removed
+		d.terminate = append([]func(context.Context) error{
+			func(ctx context.Context) error {
+				return d.cli.NetworkRemove(ctx, nw.ID)
+			},
+		}, d.terminate...)
+	}
+	d.networks[name] = nw
+	return nw, nil
+}
+
+func (d *dockerNamespace) CreateVolume(ctx context.Context, name string) (string, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	volumeName := d.namespace + name
+	if d.volumes[volumeName] {
+		return volumeName, nil
+	}
+
+	var created bool
+	_, err := d.cli.VolumeInspect(ctx, volumeName)
+	if errdefs.IsNotFound(err) {
+		_, err = d.cli.VolumeCreate(ctx, volume.CreateOptions{
+			Name:   volumeName,
+			Labels: d.labels,
+		})
+		if err != nil {
+			return "", err
+		}
+		created = true
+	} else if err != nil {
+		return "", err
+	} else if d.policy == ResourcePolicyError {
+		return "", fmt.Errorf("dockerNamespace: volume %q already exists", volumeName)
+	}
+
+	if (created && d.policy != ResourcePolicyReusable) || d.policy == ResourcePolicyTakeOver {
+		// remove after all containers are removed
+		d.terminate = append([]func(context.Context) error{
+			func(ctx context.Context) error {
+				return d.cli.VolumeRemove(ctx, volumeName, true)
+			},
+		}, d.terminate...)
+	}
+	d.volumes[volumeName] = true
+	return volumeName, nil
+}
+
+func (d *dockerNamespace) AttachNetwork(ctx context.Context, name, networkName string, aliases []string) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	c, ok := d.containers[name]
+	if !ok {
+		return fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	nw, ok := d.networks[networkName]
+	if !ok {
+		return fmt.Errorf("dockerNamespace: network %q not found", networkName)
+	}
+	info, err := d.cli.ContainerInspect(ctx, c.containerID)
+	if err != nil {
+		return err
+	}
+	for _, setting := range info.NetworkSettings.Networks {
+		if setting.NetworkID == nw.ID {
+			return nil // already connected
+		}
+	}
+	err = d.cli.NetworkConnect(ctx, nw.ID, c.containerID, &network.EndpointSettings{
+		NetworkID: nw.ID,
+		Aliases:   aliases,
+	})
+	if err != nil {
+		return err
+	}
+	d.terminate = append(d.terminate, func(ctx context.Context) error {
+		err := d.cli.NetworkDisconnect(ctx, nw.ID, d.containers[name].containerID, true)
+		if errdefs.IsNotFound(err) {
+			return nil // container has been removed
+		}
+		return err
+	})
+	return nil
+}
+
+func (d *dockerNamespace) DisconnectContainer(ctx context.Context, name string) error {
+	d.m.RLock()
+	c, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	connected, err := d.connected(ctx, c.containerID)
+	if err != nil || !connected {
+		return err
+	}
+	return d.cli.NetworkDisconnect(ctx, d.network.ID, c.containerID, true)
+}
+
+func (d *dockerNamespace) ConnectContainer(ctx context.Context, name string) error {
+	d.m.RLock()
+	c, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	connected, err := d.connected(ctx, c.containerID)
+	if err != nil || connected {
+		return err
+	}
+	aliases := []string{
+		strings.TrimPrefix(name, d.namespace),
+	}
+	if e, ok := c.network.EndpointsConfig[d.network.Name]; ok && len(e.Aliases) > 0 {
+		aliases = e.Aliases
+	}
+	return d.cli.NetworkConnect(ctx, d.network.ID, c.containerID, &network.EndpointSettings{
+		NetworkID: d.network.ID,
+		Aliases:   aliases,
+	})
+}
+
+// connected reports whether the container is connected to the namespace network.
+func (d *dockerNamespace) connected(ctx context.Context, containerID string) (bool, error) {
+	info, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, err
+	}
+	for _, setting := range info.NetworkSettings.Networks {
+		if setting.NetworkID == d.network.ID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// NetemContainer executes "tc qdisc" for the interface of the container connected to the
+// namespace network, in the helper container sharing the network stack of the container.
+// If args is empty, the qdisc is deleted.
+func (d *dockerNamespace) NetemContainer(ctx context.Context, name, image string, args []string) error {
+	d.m.RLock()
+	c, ok := d.containers[name]
+	d.m.RUnlock()
+	if !ok {
+		return fmt.Errorf("dockerNamespace: container %q not found", name)
+	}
+	info, err := d.cli.ContainerInspect(ctx, c.containerID)
+	if err != nil {
+		return err
+	}
+	var ip string
+	for _, setting := range info.NetworkSettings.Networks {
+		if setting.NetworkID == d.network.ID {
+			ip = setting.IPAddress
+		}
+	}
+	if ip == "" {
+		return fmt.Errorf("dockerNamespace: container %q is not connected to network %q", name, d.network.Name)
+	}
+
+	script := `iface=$(ip -o -4 addr show | awk -v ip="$1" 'index($4, ip "/") == 1 { print $2 }')
+if [ -z "$iface" ]; then echo "interface not found: $1" >&2; exit 1; fi
+shift
+if [ $# -eq 0 ]; then tc qdisc del dev "$iface" root 2>/dev/null || true; exit 0; fi
+tc qdisc replace dev "$iface" root netem "$@"`
+
+	return d.runHelperContainer(ctx, "tc", &container.Config{
+		Image:      image,
+		Entrypoint: append([]string{"sh", "-c", script, "netem", ip}, args...),
+	}, &container.HostConfig{
+		NetworkMode: container.NetworkMode("container:" + c.containerID),
+		CapAdd:      []string{"NET_ADMIN"},
+	})
+}
+
+// killScript sends the signal to the process only if its command line matches the
+// expected one, because the PID may be reused after the process exits.
+const killScript = `pid=$1 sig=$2
+shift 2
+if [ "$(tr '\0' '\n' < /proc/"$pid"/cmdline)" != "$(printf '%s\n' "$@")" ]; then
+  echo "process $pid is not the expected command" >&2
+  exit 1
+fi
+exec kill -s "$sig" "$pid"`
+
+// KillProcess sends the signal to the process of the pid in the PID namespace of the
+// Docker daemon, such as the process of exec.
+func (d *dockerNamespace) KillProcess(ctx context.Context, image string, pid int, cmd []string, signal string) error {
+	return d.runHelperContainer(ctx, "kill", &container.Config{
+		Image:      image,
+		Entrypoint: append([]string{"sh", "-c", killScript, "kill", strconv.Itoa(pid), signal}, cmd...),
+	}, &container.HostConfig{
+		PidMode: "host",
+	})
+}
+
+// runHelperContainer runs the container and waits for it to exit. If the container
+// exits with non-zero status, its logs are returned as the error.
+func (d *dockerNamespace) runHelperContainer(ctx context.Context, cmd string, config *container.Config, host *container.HostConfig) (err error) {
+	err = d.pullImage(ctx, config.Image, PullIfMissing, nil, io.Discard)
+	if err != nil {
+		return err
+	}
+	config.Labels = d.labels
+	created, err := d.cli.ContainerCreate(ctx, config, host, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = multierr.Append(err, d.cli.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{
+			Force: true,
+		}))
+	}()
+
+	waitC, errC := d.cli.ContainerWait(ctx, created.ID, container.WaitConditionNextExit)
+	err = d.cli.ContainerStart(ctx, created.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return err
+	}
+	select {
+	case result := <-waitC:
+		if result.StatusCode == 0 {
+			return nil
+		}
+		f := &fetcher{
+			cli:         d.cli,
+			containerID: created.ID,
+		}
+		rc, err := f.Log(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+		buf := bytes.NewBuffer(nil)
+		_, _ = stdcopy.StdCopy(buf, buf, rc)
+		return fmt.Errorf("dockerNamespace: %s exited with %d: %s", cmd, result.StatusCode, strings.TrimSpace(buf.String()))
+	case err := <-errC:
+		return err
+	}
+}
+
+func (d *dockerNamespace) Release(ctx context.Context) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	var err error
+	last := len(d.terminate) - 1
+	for i := range d.terminate {
+		err = multierr.Append(err, d.terminate[last-i](ctx))
+	}
+	return err
+}
+
+var _ Namespace = (*dockerNamespace)(nil)
+
+// write stream message line by line
+func handleJSONMessageStream(dst io.Writer, src io.Reader) error {
+	dec := json.NewDecoder(src)
+	buf := &bytes.Buffer{}
+	var printer *buildKitPrinter
+
+	for {
+		var msg jsonmessage.JSONMessage
+		err := dec.Decode(&msg)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if msg.ID == buildKitTraceID && msg.Aux != nil {
+			if printer == nil {
+				printer = newBuildKitPrinter()
+			}
+			err = printer.print(dst, *msg.Aux)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = msg.Display(buf, false)
+		if err != nil {
+			return err
+		}
+		_, err = dst.Write(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		buf.Reset()
+	}
+	return nil
+}
+
+// wait.Fetcher implementation
+type fetcher struct {
+	cli         *client.Client
+	containerID string
+	ports       nat.PortMap
+	since       time.Time // if not zero, Log returns logs since this time
+}
+
+func (f *fetcher) ContainerID() string {
+	return f.containerID
+}
+
+func (f *fetcher) Status(ctx context.Context) (*types.ContainerState, error) {
+	i, err := f.cli.ContainerInspect(ctx, f.containerID)
+	if err != nil {
+		return nil, err
+	}
+	return i.State, nil
+}
+
+func (f *fetcher) Ports() nat.PortMap {
+	return f.ports
 }
 
 func (f *fetcher) Log(ctx context.Context) (io.ReadCloser, error) {
+	var since string
+	if !f.since.IsZero() {
+		since = f.since.Format(time.RFC3339Nano)
+	}
 	return f.cli.ContainerLogs(ctx, f.containerID, types.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
+		Since:      since,
 	})
 }
 
@@ -1,8 +1,13 @@
 package confort
 
 import (
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/go-connections/nat"
 )
 
@@ -79,3 +84,96 @@ func TestPorts_URL(t *testing.T) {
 		}
 	})
 }
+
+func TestHashBuildContext(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		t.Helper()
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("Dockerfile", "FROM scratch\nCOPY file /\n")
+	writeFile("file", "content")
+
+	hash := func(buildOptions types.ImageBuildOptions) string {
+		t.Helper()
+		tarball, relDockerfile, err := createArchive(dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = tarball.Close()
+		}()
+		buildOptions.Dockerfile = relDockerfile
+		r, h, err := hashBuildContext(tarball, buildOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the returned reader must provide the whole tarball
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	value := "value"
+	base := hash(types.ImageBuildOptions{})
+
+	t.Run("same content", func(t *testing.T) {
+		if h := hash(types.ImageBuildOptions{}); h != base {
+			t.Fatalf("hash changed: %s -> %s", base, h)
+		}
+	})
+
+	t.Run("options", func(t *testing.T) {
+		testCases := map[string]types.ImageBuildOptions{
+			"args": {
+				BuildArgs: map[string]*string{"KEY": &value},
+			},
+			"nil arg": {
+				BuildArgs: map[string]*string{"KEY": nil},
+			},
+			"target": {
+				Target: "target",
+			},
+			"platform": {
+				Platform: "linux/arm64",
+			},
+		}
+		hashes := map[string]string{}
+		for name, opts := range testCases {
+			h := hash(opts)
+			if h == base {
+				t.Errorf("%s: hash not changed", name)
+			}
+			for n, other := range hashes {
+				if h == other {
+					t.Errorf("%s: same hash as %s", name, n)
+				}
+			}
+			hashes[name] = h
+		}
+	})
+
+	// run sequentially because they modify the files
+	t.Run("modification time", func(t *testing.T) {
+		future := time.Now().Add(time.Hour)
+		err := os.Chtimes(filepath.Join(dir, "file"), future, future)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h := hash(types.ImageBuildOptions{}); h != base {
+			t.Fatalf("hash changed by modification time: %s -> %s", base, h)
+		}
+	})
+
+	t.Run("content", func(t *testing.T) {
+		writeFile("file", "modified")
+		if h := hash(types.ImageBuildOptions{}); h == base {
+			t.Fatal("hash not changed")
+		}
+	})
+}
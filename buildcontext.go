@@ -0,0 +1,199 @@
+package confort
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/docker/cli/cli/command/image/build"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/moby/buildkit/frontend/dockerfile/dockerignore"
+	"github.com/moby/patternmatcher"
+)
+
+// inlineDockerfile is the name of the Dockerfile specified by BuildParams.DockerfileContent
+// in the build context. The fixed name keeps the hash of WithBuildHash stable.
+const inlineDockerfile = ".confort.Dockerfile"
+
+// createBuildContext creates the tarball of the build context specified by b, and
+// returns it with the path of Dockerfile in the context.
+func createBuildContext(b *BuildParams) (io.ReadCloser, string, error) {
+	if b.ContextDir != "" && b.ContextFS != nil {
+		return nil, "", errors.New("both ContextDir and ContextFS are specified")
+	}
+	inline := b.DockerfileContent != ""
+	if inline && b.Dockerfile != "" {
+		return nil, "", errors.New("both Dockerfile and DockerfileContent are specified")
+	}
+
+	var (
+		tarball       io.ReadCloser
+		relDockerfile string
+		err           error
+	)
+	switch {
+	case b.ContextFS != nil:
+		tarball, relDockerfile, err = createArchiveFromFS(b.ContextFS, b.Dockerfile, inline)
+	case inline && b.ContextDir == "":
+		tarball, relDockerfile, err = createArchiveFromFS(nil, "", true)
+	case inline:
+		// "-" skips the check of Dockerfile in the context directory
+		tarball, relDockerfile, err = createArchive(b.ContextDir, "-")
+	default:
+		tarball, relDockerfile, err = createArchive(b.ContextDir, b.Dockerfile)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if inline {
+		return addInlineDockerfile(tarball, b.DockerfileContent), inlineDockerfile, nil
+	}
+	return tarball, relDockerfile, nil
+}
+
+// createArchiveFromFS creates the tarball of the build context from fsys, in the
+// same manner as createArchive. If fsys is nil, it creates the empty build context.
+func createArchiveFromFS(fsys fs.FS, dockerfilePath string, inline bool) (io.ReadCloser, string, error) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	if fsys == nil {
+		if err := tw.Close(); err != nil {
+			return nil, "", err
+		}
+		return io.NopCloser(buf), "", nil
+	}
+
+	relDockerfile := ""
+	if !inline {
+		relDockerfile = path.Clean(dockerfilePath)
+		if dockerfilePath == "" {
+			relDockerfile = build.DefaultDockerfileName
+		}
+		if _, err := fs.Stat(fsys, relDockerfile); err != nil {
+			return nil, "", fmt.Errorf("cannot locate specified Dockerfile: %w", err)
+		}
+	}
+
+	var excludes []string
+	data, err := fs.ReadFile(fsys, ".dockerignore")
+	if err == nil {
+		excludes, err = dockerignore.ReadAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, "", err
+	}
+	// We have to include docker-ignored Dockerfile and .dockerignore for build.
+	excludes = build.TrimBuildFilesFromExcludes(excludes, relDockerfile, inline)
+	pm, err := patternmatcher.New(excludes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		excluded, err := pm.MatchesOrParentMatches(name)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			// the excluded directory may contain the files re-included by "!" patterns
+			if d.IsDir() && !pm.Exclusions() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		switch {
+		case info.IsDir():
+			hdr.Name += "/"
+			if info.Mode().Perm() == 0 {
+				hdr.Mode |= 0755
+			}
+		case info.Mode().IsRegular():
+			if info.Mode().Perm() == 0 {
+				hdr.Mode |= 0644
+			}
+		default:
+			return fmt.Errorf("unsupported file type: %s", name)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	return io.NopCloser(buf), relDockerfile, nil
+}
+
+// addInlineDockerfile adds the Dockerfile to the build context, and excludes it from
+// the files used by ADD and COPY instructions.
+func addInlineDockerfile(tarball io.ReadCloser, content string) io.ReadCloser {
+	now := time.Now()
+	return archive.ReplaceFileTarWrapper(tarball, map[string]archive.TarModifierFunc{
+		inlineDockerfile: func(_ string, _ *tar.Header, _ io.Reader) (*tar.Header, []byte, error) {
+			return &tar.Header{
+				Name:     inlineDockerfile,
+				Mode:     0600,
+				ModTime:  now,
+				Typeflag: tar.TypeReg,
+			}, []byte(content), nil
+		},
+		".dockerignore": func(_ string, h *tar.Header, content io.Reader) (*tar.Header, []byte, error) {
+			if h == nil {
+				h = &tar.Header{
+					Name:     ".dockerignore",
+					Mode:     0600,
+					ModTime:  now,
+					Typeflag: tar.TypeReg,
+				}
+			}
+			b := &bytes.Buffer{}
+			if content != nil {
+				if _, err := b.ReadFrom(content); err != nil {
+					return nil, nil, err
+				}
+			} else {
+				b.WriteString(".dockerignore")
+			}
+			b.WriteString("\n" + inlineDockerfile + "\n")
+			return h, b.Bytes(), nil
+		},
+	})
+}
@@ -0,0 +1,206 @@
+package confort
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	"github.com/docker/docker/api/types"
+)
+
+// readBuildContext returns the names and contents of the files in the tarball.
+func readBuildContext(t *testing.T, tarball io.Reader) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	tr := tar.NewReader(tarball)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+	return files
+}
+
+func fileNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCreateBuildContext(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"Dockerfile":       {Data: []byte("FROM scratch\n")},
+		".dockerignore":    {Data: []byte("*.log\n!keep.log\nignored\nDockerfile\n")},
+		"file":             {Data: []byte("content")},
+		"dir/file":         {Data: []byte("content in dir")},
+		"debug.log":        {Data: []byte("debug")},
+		"keep.log":         {Data: []byte("keep")},
+		"ignored/file":     {Data: []byte("ignored")},
+		"ignored/sub/file": {Data: []byte("ignored")},
+	}
+
+	dir := t.TempDir()
+	for name, f := range fsys {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, f.Data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("ContextFS", func(t *testing.T) {
+		t.Parallel()
+
+		tarball, relDockerfile, err := createBuildContext(&BuildParams{
+			ContextFS: fsys,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if relDockerfile != "Dockerfile" {
+			t.Fatalf("unexpected Dockerfile: %q", relDockerfile)
+		}
+		files := readBuildContext(t, tarball)
+		expected := []string{".dockerignore", "Dockerfile", "dir/", "dir/file", "file", "keep.log"}
+		if names := fileNames(files); !reflect.DeepEqual(expected, names) {
+			t.Fatalf("unexpected files: %v", names)
+		}
+		if files["dir/file"] != "content in dir" {
+			t.Fatalf("unexpected content: %q", files["dir/file"])
+		}
+	})
+
+	t.Run("DockerfileContent with ContextFS", func(t *testing.T) {
+		t.Parallel()
+
+		tarball, relDockerfile, err := createBuildContext(&BuildParams{
+			DockerfileContent: "FROM alpine\n",
+			ContextFS:         fsys,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if relDockerfile != inlineDockerfile {
+			t.Fatalf("unexpected Dockerfile: %q", relDockerfile)
+		}
+		files := readBuildContext(t, tarball)
+		expected := []string{".confort.Dockerfile", ".dockerignore", "dir/", "dir/file", "file", "keep.log"}
+		if names := fileNames(files); !reflect.DeepEqual(expected, names) {
+			t.Fatalf("unexpected files: %v", names)
+		}
+		if files[inlineDockerfile] != "FROM alpine\n" {
+			t.Fatalf("unexpected Dockerfile content: %q", files[inlineDockerfile])
+		}
+		if files[".dockerignore"] != "*.log\n!keep.log\nignored\nDockerfile\n\n.confort.Dockerfile\n" {
+			t.Fatalf("unexpected .dockerignore: %q", files[".dockerignore"])
+		}
+	})
+
+	t.Run("DockerfileContent with ContextDir", func(t *testing.T) {
+		t.Parallel()
+
+		tarball, relDockerfile, err := createBuildContext(&BuildParams{
+			DockerfileContent: "FROM alpine\n",
+			ContextDir:        dir,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if relDockerfile != inlineDockerfile {
+			t.Fatalf("unexpected Dockerfile: %q", relDockerfile)
+		}
+		files := readBuildContext(t, tarball)
+		for _, name := range []string{inlineDockerfile, "file", "dir/file", "keep.log"} {
+			if _, ok := files[name]; !ok {
+				t.Errorf("%q not found in build context", name)
+			}
+		}
+		for _, name := range []string{"debug.log", "ignored/file", "Dockerfile"} {
+			if _, ok := files[name]; ok {
+				t.Errorf("%q must be excluded from build context", name)
+			}
+		}
+	})
+
+	t.Run("DockerfileContent only", func(t *testing.T) {
+		t.Parallel()
+
+		b := &BuildParams{
+			DockerfileContent: "FROM alpine\n",
+		}
+		tarball, _, err := createBuildContext(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := readBuildContext(t, tarball)
+		expected := []string{".confort.Dockerfile", ".dockerignore"}
+		if names := fileNames(files); !reflect.DeepEqual(expected, names) {
+			t.Fatalf("unexpected files: %v", names)
+		}
+
+		// the hash must be stable
+		hash := func() string {
+			tarball, relDockerfile, err := createBuildContext(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, h, err := hashBuildContext(tarball, types.ImageBuildOptions{
+				Dockerfile: relDockerfile,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return h
+		}
+		if h1, h2 := hash(), hash(); h1 != h2 {
+			t.Fatalf("hash changed: %s -> %s", h1, h2)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+
+		testCases := map[string]*BuildParams{
+			"both ContextDir and ContextFS": {
+				ContextDir: dir,
+				ContextFS:  fsys,
+			},
+			"both Dockerfile and DockerfileContent": {
+				Dockerfile:        "Dockerfile",
+				DockerfileContent: "FROM alpine\n",
+				ContextFS:         fsys,
+			},
+			"Dockerfile not found in ContextFS": {
+				Dockerfile: "not_found/Dockerfile",
+				ContextFS:  fsys,
+			},
+		}
+		for name, b := range testCases {
+			if _, _, err := createBuildContext(b); err == nil {
+				t.Errorf("%s: error expected", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package confort
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing/fstest"
+
+	"github.com/daichitakahashi/confort/internal/gocommand"
+	"github.com/daichitakahashi/confort/internal/logging"
+	"github.com/daichitakahashi/gocmd"
+	"github.com/lestrrat-go/option"
+)
+
+type (
+	BuildGoOption interface {
+		option.Interface
+		buildGo() BuildGoOption
+	}
+	identOptionGoImage        struct{}
+	identOptionGoBaseImage    struct{}
+	identOptionGoVersion      struct{}
+	identOptionGoBuildFlags   struct{}
+	identOptionGoBuildOptions struct{}
+	buildGoOption             struct{ option.Interface }
+)
+
+func (o buildGoOption) buildGo() BuildGoOption { return o }
+
+// WithGoImage sets the name of the image built by BuildGo.
+// By default, the lower-cased import path of the package with the tag "confort" is used.
+func WithGoImage(image string) BuildGoOption {
+	return buildGoOption{
+		Interface: option.New(identOptionGoImage{}, image),
+	}.buildGo()
+}
+
+// WithGoBaseImage sets the base image of the image built by BuildGo.
+// By default, "scratch" is used.
+func WithGoBaseImage(image string) BuildGoOption {
+	return buildGoOption{
+		Interface: option.New(identOptionGoBaseImage{}, image),
+	}.buildGo()
+}
+
+// WithGoVersion specifies the version of go command used by BuildGo, like "-go" flag
+// of "confort test". If version is empty, "go" command is used. By default, the go
+// command of the version written in go.mod is used. If no command of the version is
+// found, "go" command is used.
+func WithGoVersion(version string) BuildGoOption {
+	return buildGoOption{
+		Interface: option.New(identOptionGoVersion{}, version),
+	}.buildGo()
+}
+
+// WithGoBuildFlags adds flags to "go build", such as "-tags" and "-ldflags".
+func WithGoBuildFlags(flags ...string) BuildGoOption {
+	return buildGoOption{
+		Interface: option.New(identOptionGoBuildFlags{}, flags),
+	}.buildGo()
+}
+
+// WithGoBuildOptions passes the BuildOption to Build called in BuildGo.
+func WithGoBuildOptions(opts ...BuildOption) BuildGoOption {
+	return buildGoOption{
+		Interface: option.New(identOptionGoBuildOptions{}, opts),
+	}.buildGo()
+}
+
+// BuildGo builds the main package pkg with go command, and creates the image which
+// has the binary as its entrypoint "/app". It returns the name of the image.
+//
+// The binary is cross-compiled for the platform of the Docker daemon with CGO_ENABLED=0.
+// Because the image is built with WithBuildHash, it is rebuilt only if the binary,
+// the base image name or the other build options are changed.
+func (cft *Confort) BuildGo(ctx context.Context, pkg string, opts ...BuildGoOption) (string, error) {
+	var (
+		image      string
+		baseImage  = "scratch"
+		goVersion  = "mod"
+		buildFlags []string
+		buildOpts  []BuildOption
+	)
+	for _, opt := range opts {
+		switch opt.Ident() {
+		case identOptionGoImage{}:
+			image = opt.Value().(string)
+		case identOptionGoBaseImage{}:
+			baseImage = opt.Value().(string)
+		case identOptionGoVersion{}:
+			goVersion = opt.Value().(string)
+		case identOptionGoBuildFlags{}:
+			buildFlags = append(buildFlags, opt.Value().([]string)...)
+		case identOptionGoBuildOptions{}:
+			buildOpts = append(buildOpts, opt.Value().([]BuildOption)...)
+		case identOptionGoCoverage{}:
+			if opt.Value().(bool) {
+				buildFlags = append(buildFlags, "-cover")
+			}
+		}
+	}
+
+	goCmd, ver, err := gocommand.Determine(goVersion, gocmd.ModeFallback)
+	if err != nil {
+		return "", fmt.Errorf("confort: %w", err)
+	}
+	logging.Debugf("use go version: %s", ver)
+
+	importPath, err := goList(ctx, goCmd, pkg)
+	if err != nil {
+		return "", fmt.Errorf("confort: %w", err)
+	}
+	if image == "" {
+		image = goImageName(importPath)
+	}
+
+	v, err := cft.cli.ServerVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("confort: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "confort-buildgo-")
+	if err != nil {
+		return "", fmt.Errorf("confort: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	bin := filepath.Join(dir, "app")
+
+	logging.Debugf("build go package %q for %s/%s", pkg, v.Os, v.Arch)
+	args := append([]string{"build", "-trimpath", "-o", bin}, buildFlags...)
+	cmd := exec.CommandContext(ctx, goCmd, append(args, pkg)...)
+	cmd.Env = append(os.Environ(), "GOOS="+v.Os, "GOARCH="+v.Arch, "CGO_ENABLED=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("confort: go build: %w: %s", err, out)
+	}
+	data, err := os.ReadFile(bin)
+	if err != nil {
+		return "", fmt.Errorf("confort: %w", err)
+	}
+
+	err = cft.Build(ctx, &BuildParams{
+		Image:             image,
+		DockerfileContent: fmt.Sprintf("FROM %s\nCOPY app /app\nENTRYPOINT [\"/app\"]\n", baseImage),
+		ContextFS: fstest.MapFS{
+			"app": {Data: data, Mode: 0755},
+		},
+	}, append([]BuildOption{WithBuildHash()}, buildOpts...)...)
+	if err != nil {
+		return "", err
+	}
+	return image, nil
+}
+
+// goList returns the import path of the main package.
+func goList(ctx context.Context, goCmd, pkg string) (string, error) {
+	out, err := exec.CommandContext(ctx, goCmd, "list", "-f", "{{.Name}} {{.ImportPath}}", pkg).Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return "", fmt.Errorf("go list: %w: %s", err, ee.Stderr)
+		}
+		return "", fmt.Errorf("go list: %w", err)
+	}
+	name, importPath, _ := strings.Cut(string(bytes.TrimSpace(out)), " ")
+	if name != "main" {
+		return "", fmt.Errorf("package %q is not a main package", pkg)
+	}
+	return importPath, nil
+}
+
+// goImageName converts the import path to the image name.
+func goImageName(importPath string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '.', r == '_', r == '-', r == '/':
+			return r
+		case 'A' <= r && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, importPath)
+	return name + ":confort"
+}
@@ -0,0 +1,34 @@
+package confort
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGoList(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	importPath, err := goList(ctx, "go", "./testdata/gomain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "github.com/daichitakahashi/confort/testdata/gomain", importPath)
+
+	_, err = goList(ctx, "go", ".")
+	if err == nil {
+		t.Fatal("error expected for non-main package")
+	}
+}
+
+func TestGoImageName(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"github.com/daichitakahashi/confort/testdata/gomain": "github.com/daichitakahashi/confort/testdata/gomain:confort",
+		"example.com/Foo/cmd/bar~v2":                         "example.com/foo/cmd/bar-v2:confort",
+	}
+	for importPath, expected := range testCases {
+		assertEqual(t, expected, goImageName(importPath))
+	}
+}
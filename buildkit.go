@@ -0,0 +1,162 @@
+package confort
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/docker/cli/cli/config/configfile"
+	clitypes "github.com/docker/cli/cli/config/types"
+	"github.com/docker/docker/api/types"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth/authprovider"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
+	"github.com/opencontainers/go-digest"
+)
+
+// buildKitAttachables returns the session attachables providing the secrets and
+// the SSH agents to the build.
+func buildKitAttachables(secrets map[string]string, ssh map[string][]string) ([]session.Attachable, error) {
+	var attachables []session.Attachable
+	if len(secrets) > 0 {
+		sources := make([]secretsprovider.Source, 0, len(secrets))
+		for id, path := range secrets {
+			sources = append(sources, secretsprovider.Source{
+				ID:       id,
+				FilePath: path,
+			})
+		}
+		store, err := secretsprovider.NewStore(sources)
+		if err != nil {
+			return nil, err
+		}
+		attachables = append(attachables, secretsprovider.NewSecretProvider(store))
+	}
+	if len(ssh) > 0 {
+		configs := make([]sshprovider.AgentConfig, 0, len(ssh))
+		for id, paths := range ssh {
+			configs = append(configs, sshprovider.AgentConfig{
+				ID:    id,
+				Paths: paths,
+			})
+		}
+		provider, err := sshprovider.NewSSHAgentProvider(configs)
+		if err != nil {
+			return nil, err
+		}
+		attachables = append(attachables, provider)
+	}
+	return attachables, nil
+}
+
+// startBuildKitSession starts the session to communicate with BuildKit in the daemon.
+// The registry authentication configured in buildOptions is provided through the session.
+func (d *dockerBackend) startBuildKitSession(ctx context.Context, buildOptions types.ImageBuildOptions, attachables []session.Attachable) (*session.Session, error) {
+	s, err := session.NewSession(ctx, "confort", buildOptions.Tags[0])
+	if err != nil {
+		return nil, err
+	}
+
+	authConfigs := make(map[string]clitypes.AuthConfig, len(buildOptions.AuthConfigs))
+	for host, c := range buildOptions.AuthConfigs {
+		authConfigs[host] = clitypes.AuthConfig{
+			Username:      c.Username,
+			Password:      c.Password,
+			Auth:          c.Auth,
+			Email:         c.Email,
+			ServerAddress: c.ServerAddress,
+			IdentityToken: c.IdentityToken,
+			RegistryToken: c.RegistryToken,
+		}
+	}
+	cfg := configfile.New("")
+	cfg.AuthConfigs = authConfigs
+	s.Allow(authprovider.NewDockerAuthProvider(cfg))
+	for _, a := range attachables {
+		s.Allow(a)
+	}
+
+	go func() {
+		_ = s.Run(ctx, func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+			return d.cli.DialHijack(ctx, "/session", proto, meta)
+		})
+	}()
+	return s, nil
+}
+
+const buildKitTraceID = "moby.buildkit.trace"
+
+// buildKitPrinter writes the progress of BuildKit like "docker build --progress=plain".
+type buildKitPrinter struct {
+	index map[digest.Digest]int
+	state map[digest.Digest]*controlapi.Vertex
+}
+
+func newBuildKitPrinter() *buildKitPrinter {
+	return &buildKitPrinter{
+		index: map[digest.Digest]int{},
+		state: map[digest.Digest]*controlapi.Vertex{},
+	}
+}
+
+// print decodes the auxiliary message of the build output and writes it to dst.
+func (p *buildKitPrinter) print(dst io.Writer, aux json.RawMessage) error {
+	var data []byte
+	err := json.Unmarshal(aux, &data)
+	if err != nil {
+		return err
+	}
+	var resp controlapi.StatusResponse
+	err = resp.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	for _, v := range resp.Vertexes {
+		prev, ok := p.state[v.Digest]
+		if !ok {
+			prev = &controlapi.Vertex{}
+			p.state[v.Digest] = prev
+		}
+		if v.Started != nil && prev.Started == nil {
+			_, _ = fmt.Fprintf(buf, "#%d %s\n", p.indexOf(v.Digest), v.Name)
+		}
+		if v.Cached && !prev.Cached {
+			_, _ = fmt.Fprintf(buf, "#%d CACHED\n", p.indexOf(v.Digest))
+		}
+		if v.Completed != nil && prev.Completed == nil {
+			if v.Error != "" {
+				_, _ = fmt.Fprintf(buf, "#%d ERROR: %s\n", p.indexOf(v.Digest), v.Error)
+			} else if v.Started != nil && !v.Cached {
+				_, _ = fmt.Fprintf(buf, "#%d DONE %.1fs\n", p.indexOf(v.Digest), v.Completed.Sub(*v.Started).Seconds())
+			}
+		}
+		*prev = *v
+	}
+	for _, l := range resp.Logs {
+		for _, line := range bytes.Split(bytes.TrimSuffix(l.Msg, []byte("\n")), []byte("\n")) {
+			_, _ = fmt.Fprintf(buf, "#%d %s\n", p.indexOf(l.Vertex), line)
+		}
+	}
+	for _, w := range resp.Warnings {
+		_, _ = fmt.Fprintf(buf, "#%d WARN: %s\n", p.indexOf(w.Vertex), w.Short)
+	}
+
+	_, err = dst.Write(buf.Bytes())
+	return err
+}
+
+func (p *buildKitPrinter) indexOf(d digest.Digest) int {
+	i, ok := p.index[d]
+	if !ok {
+		i = len(p.index) + 1
+		p.index[d] = i
+	}
+	return i
+}
@@ -0,0 +1,79 @@
+package confort
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestHandleJSONMessageStream_BuildKit(t *testing.T) {
+	t.Parallel()
+
+	var (
+		started   = time.Unix(0, 0)
+		completed = started.Add(1500 * time.Millisecond)
+		v1        = digest.FromString("v1")
+		v2        = digest.FromString("v2")
+	)
+	responses := []*controlapi.StatusResponse{
+		{
+			Vertexes: []*controlapi.Vertex{
+				{Digest: v1, Name: "[1/2] FROM alpine", Started: &started},
+			},
+		}, {
+			Vertexes: []*controlapi.Vertex{
+				{Digest: v1, Name: "[1/2] FROM alpine", Started: &started, Completed: &completed},
+				{Digest: v2, Name: "[2/2] RUN echo hello", Started: &started},
+			},
+			Logs: []*controlapi.VertexLog{
+				{Vertex: v2, Msg: []byte("hello\nworld\n")},
+			},
+		}, {
+			Vertexes: []*controlapi.Vertex{
+				{Digest: v2, Name: "[2/2] RUN echo hello", Started: &started, Completed: &completed, Error: "failed"},
+			},
+		},
+	}
+
+	src := &bytes.Buffer{}
+	enc := json.NewEncoder(src)
+	for _, resp := range responses {
+		data, err := resp.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		aux, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := json.RawMessage(aux)
+		err = enc.Encode(jsonmessage.JSONMessage{
+			ID:  buildKitTraceID,
+			Aux: &msg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := &bytes.Buffer{}
+	err := handleJSONMessageStream(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `#1 [1/2] FROM alpine
+#1 DONE 1.5s
+#2 [2/2] RUN echo hello
+#2 hello
+#2 world
+#2 ERROR: failed
+`
+	if dst.String() != expected {
+		t.Fatalf("unexpected output:\n%s", dst.String())
+	}
+}
@@ -0,0 +1,325 @@
+package confort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/daichitakahashi/confort/internal/compose"
+	"github.com/daichitakahashi/confort/wait"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// LoadCompose reads the compose file and converts its services into Stack.
+// Each service is run with its service name as the name of the container, so
+// that the service name is available as the host name in the namespace network.
+// The result can be started by Confort.RunStack.
+//
+// Following keys of the service are supported:
+//
+//	image, command, entrypoint, working_dir, user, hostname, environment, labels,
+//	ports, expose, healthcheck, volumes, tmpfs, depends_on, stop_grace_period,
+//	stop_signal, privileged, cap_add, cap_drop, extra_hosts, networks
+//
+// Because all services join the network of the namespace, the file can declare
+// only one network in top-level networks, such as the one written by ExportCompose.
+// The network is replaced with the network of the namespace, and the aliases of the
+// services in it are applied.
+//
+// The named volumes are created in the namespace by RunStack like Confort.Volume,
+// so that they are not shared between namespaces. Only the volumes declared with
+// "external: true" are used with their names as is.
+//
+// If the service has healthcheck, its Waiter becomes wait.Healthy.
+// Relative paths of bind mounts are resolved from the directory of the compose file.
+// Variables in the values are interpolated with the current environment variables.
+// Options of each StackEntry contain WithContainerConfig, WithHostConfig and
+// WithNetworkingConfig to apply the settings of the service. Append other ones to
+// customize further, which are applied after them.
+//
+// If the file contains unsupported keys, LoadCompose fails with the error that
+// lists all of them.
+func LoadCompose(path string) (Stack, error) {
+	p, err := compose.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("confort: failed to load %s: %w", path, err)
+	}
+	baseDir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("confort: %w", err)
+	}
+
+	names := make([]string, 0, len(p.Services))
+	for name := range p.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// all services join the network of the namespace
+	var namespaceNetwork string
+	if len(p.Networks) > 1 {
+		return nil, fmt.Errorf("confort: failed to load %s: %w", path, &compose.UnsupportedKeysError{Keys: []string{"networks"}})
+	}
+	for name, n := range p.Networks {
+		if n != nil && n.External {
+			return nil, fmt.Errorf("confort: failed to load %s: %w", path, &compose.UnsupportedKeysError{Keys: []string{"networks." + name + ".external"}})
+		}
+		namespaceNetwork = name
+	}
+	var keys []string
+	for _, name := range names {
+		for n := range p.Services[name].Networks {
+			if n != namespaceNetwork {
+				keys = append(keys, "services."+name+".networks."+n)
+			}
+		}
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		return nil, fmt.Errorf("confort: failed to load %s: %w", path, &compose.UnsupportedKeysError{Keys: keys})
+	}
+
+	s := make(Stack, 0, len(names))
+	for _, name := range names {
+		e, err := composeServiceEntry(name, p.Services[name], p.Volumes, namespaceNetwork, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("confort: failed to load %s: service %q: %w", path, name, err)
+		}
+		s = append(s, e)
+	}
+	return s, nil
+}
+
+func composeServiceEntry(name string, svc *compose.Service, volumes map[string]*compose.Volume, networkName, baseDir string) (*StackEntry, error) {
+	if svc.Image == "" {
+		return nil, errors.New("image not specified")
+	}
+
+	e := &StackEntry{
+		ContainerParams: ContainerParams{
+			Name:       name,
+			Image:      svc.Image,
+			Entrypoint: svc.Entrypoint,
+			Cmd:        svc.Command,
+			WorkingDir: svc.WorkingDir,
+		},
+	}
+
+	if len(svc.Environment) > 0 {
+		e.Env = make(map[string]string, len(svc.Environment))
+		for k, v := range svc.Environment {
+			if v != nil {
+				e.Env[k] = *v
+			} else if v, ok := os.LookupEnv(k); ok {
+				e.Env[k] = v
+			}
+		}
+	}
+	for _, p := range svc.Ports {
+		e.ExposedPorts = append(e.ExposedPorts, p.Spec())
+	}
+	if svc.StopGracePeriod != nil {
+		timeout := int(time.Duration(*svc.StopGracePeriod) / time.Second)
+		e.StopTimeout = &timeout
+	}
+
+	for _, v := range svc.Volumes {
+		m := mount.Mount{
+			Type:     mount.Type(v.Type),
+			Source:   v.Source,
+			Target:   v.Target,
+			ReadOnly: v.ReadOnly,
+		}
+		switch v.Type {
+		case compose.MountTypeBind:
+			src := v.Source
+			if strings.HasPrefix(src, "~") {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return nil, err
+				}
+				src = home + src[1:]
+			}
+			if !filepath.IsAbs(src) {
+				src = filepath.Join(baseDir, src)
+			}
+			m.Source = src
+			if v.Bind != nil {
+				m.BindOptions = &mount.BindOptions{
+					Propagation: mount.Propagation(v.Bind.Propagation),
+				}
+			}
+		case compose.MountTypeVolume:
+			if m.Source == "" {
+				break // anonymous volume
+			}
+			vol, ok := volumes[m.Source]
+			if !ok {
+				return nil, fmt.Errorf("volume %q is not declared in top-level volumes", m.Source)
+			}
+			if vol != nil && vol.Name != "" {
+				m.Source = vol.Name
+			}
+			if vol == nil || !vol.External {
+				// created in the namespace by RunStack
+				e.Volumes = append(e.Volumes, m.Source)
+			}
+			if v.Volume != nil {
+				m.VolumeOptions = &mount.VolumeOptions{
+					NoCopy: v.Volume.NoCopy,
+				}
+			}
+		case compose.MountTypeTmpfs:
+			if v.Tmpfs != nil {
+				m.TmpfsOptions = &mount.TmpfsOptions{
+					SizeBytes: v.Tmpfs.Size,
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported volume type %q", v.Type)
+		}
+		e.Mounts = append(e.Mounts, m)
+	}
+
+	var healthcheck *container.HealthConfig
+	if hc := svc.Healthcheck; hc != nil {
+		healthcheck = &container.HealthConfig{
+			Test: hc.TestCommand(),
+		}
+		if hc.Interval != nil {
+			healthcheck.Interval = time.Duration(*hc.Interval)
+		}
+		if hc.Timeout != nil {
+			healthcheck.Timeout = time.Duration(*hc.Timeout)
+		}
+		if hc.StartPeriod != nil {
+			healthcheck.StartPeriod = time.Duration(*hc.StartPeriod)
+		}
+		if hc.Retries != nil {
+			healthcheck.Retries = *hc.Retries
+		}
+		if !hc.Disable {
+			e.Waiter = wait.Healthy()
+		}
+	}
+
+	exposed := make(nat.PortSet, len(svc.Expose))
+	for _, p := range svc.Expose {
+		proto, port := nat.SplitProtoPort(p)
+		np, err := nat.NewPort(proto, port)
+		if err != nil {
+			return nil, err
+		}
+		exposed[np] = struct{}{}
+	}
+
+	e.Options = append(e.Options,
+		WithContainerConfig(func(config *container.Config) {
+			if healthcheck != nil {
+				config.Healthcheck = healthcheck
+			}
+			if len(exposed) > 0 {
+				if config.ExposedPorts == nil {
+					config.ExposedPorts = nat.PortSet{}
+				}
+				for p := range exposed {
+					config.ExposedPorts[p] = struct{}{}
+				}
+			}
+			if len(svc.Labels) > 0 {
+				if config.Labels == nil {
+					config.Labels = map[string]string{}
+				}
+				for k, v := range svc.Labels {
+					config.Labels[k] = v
+				}
+			}
+			if svc.User != "" {
+				config.User = svc.User
+			}
+			if svc.Hostname != "" {
+				config.Hostname = svc.Hostname
+			}
+			if svc.StopSignal != "" {
+				config.StopSignal = svc.StopSignal
+			}
+		}),
+		WithHostConfig(func(config *container.HostConfig) {
+			config.Privileged = svc.Privileged
+			config.CapAdd = append(config.CapAdd, svc.CapAdd...)
+			config.CapDrop = append(config.CapDrop, svc.CapDrop...)
+			config.ExtraHosts = append(config.ExtraHosts, svc.ExtraHosts...)
+			if len(svc.Tmpfs) > 0 {
+				if config.Tmpfs == nil {
+					config.Tmpfs = map[string]string{}
+				}
+				for _, t := range svc.Tmpfs {
+					path, opts, _ := strings.Cut(t, ":")
+					config.Tmpfs[path] = opts
+				}
+			}
+		}),
+	)
+	if sn := svc.Networks[networkName]; sn != nil && len(sn.Aliases) > 0 {
+		e.Options = append(e.Options, WithNetworkingConfig(func(config *network.NetworkingConfig) {
+			// the network of the namespace
+			for _, es := range config.EndpointsConfig {
+				es.Aliases = append(es.Aliases, sn.Aliases...)
+			}
+		}))
+	}
+
+	depNames := make([]string, 0, len(svc.DependsOn))
+	for dep := range svc.DependsOn {
+		depNames = append(depNames, dep)
+	}
+	sort.Strings(depNames)
+	for _, dep := range depNames {
+		var cond DependencyCondition
+		switch c := svc.DependsOn[dep].Condition; c {
+		case compose.ConditionServiceStarted:
+			cond = DependencyStarted
+		case compose.ConditionServiceHealthy:
+			cond = DependencyHealthy
+		case compose.ConditionServiceCompletedSuccessfully:
+			cond = DependencyCompleted
+		default:
+			return nil, fmt.Errorf("unsupported condition of depends_on: %s", c)
+		}
+		e.DependsOn = append(e.DependsOn, Dependency{
+			Name:      dep,
+			Condition: cond,
+		})
+	}
+	return e, nil
+}
+
+// ExportCompose inspects the network and all containers in the namespace, and
+// writes an equivalent compose file to w. It is useful to reproduce the containers
+// of the failed test in another environment with "docker compose".
+// The containers which are not created by this Confort but exist in the namespace
+// are also exported.
+//
+// See also "confort export" command, which exports the namespace from outside the test.
+func (cft *Confort) ExportCompose(ctx context.Context, w io.Writer) error {
+	ctx, cancel := applyTimeout(ctx, cft.defaultTimeout)
+	defer cancel()
+
+	p, err := compose.Export(ctx, cft.cli, cft.namespace.Network().Name, "", "")
+	if err != nil {
+		return fmt.Errorf("confort: %w", err)
+	}
+	if err := p.Write(w); err != nil {
+		return fmt.Errorf("confort: %w", err)
+	}
+	return nil
+}
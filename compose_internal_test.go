@@ -0,0 +1,86 @@
+package confort
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daichitakahashi/confort/internal/compose"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestLoadCompose_exported(t *testing.T) {
+	t.Parallel()
+
+	// the project in the form written by ExportCompose
+	p := &compose.Project{
+		Name: "ns",
+		Services: map[string]*compose.Service{
+			"app": {
+				Image: "app:latest",
+				Networks: compose.Networks{
+					"ns": {Aliases: []string{"api"}},
+				},
+			},
+			"db": {
+				Image: "db:latest",
+				Networks: compose.Networks{
+					"ns": nil,
+				},
+			},
+		},
+		Networks: map[string]*compose.Network{
+			"ns": {Name: "ns"},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "compose.yaml")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Write(f)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadCompose(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliases := map[string]string{}
+	for _, e := range s {
+		nc := &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				"namespace": {Aliases: []string{e.Name}},
+			},
+		}
+		for _, opt := range e.Options {
+			if opt.Ident() == (identOptionNetworkingConfig{}) {
+				opt.Value().(func(*network.NetworkingConfig))(nc)
+			}
+		}
+		aliases[e.Name] = strings.Join(nc.EndpointsConfig["namespace"].Aliases, ",")
+	}
+	assertEqual(t, "app,api", aliases["app"])
+	assertEqual(t, "db", aliases["db"])
+}
+
+func TestLoadCompose_volumes(t *testing.T) {
+	t.Setenv("CFT_TEST_IMAGE_COMMUNICATOR", "communicator")
+	t.Setenv("CFT_TEST_EXTERNAL_VOLUME", "external")
+
+	s, err := LoadCompose("testdata/compose/volumes.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := s[0]
+	// the named volume is created in the namespace, and the external one is used as is
+	assertEqual(t, "data", strings.Join(e.Volumes, ","))
+	sources := make([]string, 0, len(e.Mounts))
+	for _, m := range e.Mounts {
+		sources = append(sources, m.Source)
+	}
+	assertEqual(t, "data,external", strings.Join(sources, ","))
+}
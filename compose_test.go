@@ -0,0 +1,258 @@
+package confort_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daichitakahashi/confort"
+	"github.com/daichitakahashi/confort/internal/beacon"
+	"github.com/daichitakahashi/confort/internal/compose"
+	"github.com/daichitakahashi/confort/wait"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+func TestLoadCompose(t *testing.T) {
+	t.Setenv("CFT_TEST_IMAGE_COMMUNICATOR", imageCommunicator)
+	ctx := context.Background()
+
+	s, err := confort.LoadCompose("testdata/compose/compose.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	containers, err := cft.RunStack(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one, two := containers["one"], containers["two"]
+	if one == nil || two == nil {
+		t.Fatalf("unexpected containers: %v", containers)
+	}
+	if one.Alias() != "one" || two.Alias() != "two" {
+		t.Fatalf("unexpected aliases: %s, %s", one.Alias(), two.Alias())
+	}
+
+	ports, release, err := confort.Acquire().
+		UseExclusive(one).
+		UseExclusive(two).
+		Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(release)
+	hostOne := ports[one].HostPort("80/tcp")
+	hostTwo := ports[two].HostPort("80/tcp")
+
+	// communicate using the service names as host names
+	communicate(t, hostOne, "set", "one")
+	communicate(t, hostTwo, "set", "two")
+	communicate(t, hostTwo, "exchange", "")
+	if s := communicate(t, hostTwo, "get", ""); s != "one" {
+		t.Fatalf("two: expected status is %q, but actual %q", "one", s)
+	}
+}
+
+func TestLoadCompose_Volumes(t *testing.T) {
+	t.Setenv("CFT_TEST_IMAGE_COMMUNICATOR", imageCommunicator)
+	ctx := context.Background()
+	namespace := uniqueName.Must(t)
+
+	run := func(t *testing.T, namespace string) types.ContainerJSON {
+		t.Helper()
+		s, err := confort.LoadCompose("testdata/compose/volumes.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cft, err := confort.New(ctx,
+			confort.WithNamespace(namespace, true),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = cft.Close()
+		})
+		containers, err := cft.RunStack(ctx, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := cft.APIClient().ContainerInspect(ctx, containers["app"].ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+	volumes := func(info types.ContainerJSON) map[string]string {
+		m := map[string]string{}
+		for _, p := range info.Mounts {
+			m[p.Destination] = p.Name
+		}
+		return m
+	}
+
+	// the external volume is shared
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	external := namespace + "-external"
+	_, err = cli.VolumeCreate(ctx, volume.CreateOptions{Name: external})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cli.VolumeRemove(ctx, external, true)
+	})
+	t.Setenv("CFT_TEST_EXTERNAL_VOLUME", external)
+
+	one := volumes(run(t, namespace+"-one"))
+	two := volumes(run(t, namespace+"-two"))
+	if one["/data"] == two["/data"] {
+		t.Fatalf("volume is shared between namespaces: %s", one["/data"])
+	}
+	for _, v := range []string{one["/data"], two["/data"]} {
+		if !strings.HasPrefix(v, namespace) {
+			t.Errorf("volume is not created in the namespace: %s", v)
+		}
+	}
+	if one["/shared"] != external || two["/shared"] != external {
+		t.Fatalf("unexpected external volumes: %s, %s", one["/shared"], two["/shared"])
+	}
+}
+
+func TestLoadCompose_Unsupported(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string][]string{
+		"testdata/compose/unsupported.yaml": {
+			"services.app.build",
+		},
+		// only the network replaced with the namespace network is allowed
+		"testdata/compose/networks.yaml": {
+			"services.app.networks.back",
+		},
+	}
+	for path, keys := range testCases {
+		_, err := confort.LoadCompose(path)
+		if err == nil {
+			t.Fatalf("%s: unexpected success", path)
+		}
+		for _, key := range keys {
+			if !strings.Contains(err.Error(), key) {
+				t.Errorf("%s: unsupported key %q is not reported: %s", path, key, err)
+			}
+		}
+	}
+}
+
+func TestConfort_ExportCompose(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	namespace := uniqueName.Must(t)
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(namespace, true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	_, err = cft.Run(ctx, &confort.ContainerParams{
+		Name:  "one",
+		Image: imageCommunicator,
+		Env: map[string]string{
+			"CM_TARGET": "two",
+		},
+		ExposedPorts: []string{"80/tcp"},
+		Mounts: []mount.Mount{
+			{Type: mount.TypeTmpfs, Target: "/tmp/data"},
+		},
+		Waiter: wait.LogContains("communicator is ready", 1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = cft.ExportCompose(ctx, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := compose.Parse(buf.Bytes(), func(string) (string, bool) { return "", false })
+	if err != nil {
+		t.Fatalf("%s\n%s", err, buf.String())
+	}
+
+	if p.Networks[namespace] == nil {
+		t.Fatalf("network %q is not exported:\n%s", namespace, buf.String())
+	}
+	svc, ok := p.Services["one"]
+	if !ok {
+		t.Fatalf("service %q is not exported:\n%s", "one", buf.String())
+	}
+	if svc.Image != imageCommunicator {
+		t.Errorf("unexpected image: %s", svc.Image)
+	}
+	if v := svc.Environment["CM_TARGET"]; v == nil || *v != "two" {
+		t.Errorf("unexpected environment: %v", svc.Environment)
+	}
+	if len(svc.Ports) != 1 || svc.Ports[0].Target != "80" {
+		t.Errorf("unexpected ports: %v", svc.Ports)
+	}
+	if len(svc.Volumes) != 1 || svc.Volumes[0].Target != "/tmp/data" {
+		t.Errorf("unexpected volumes: %v", svc.Volumes)
+	}
+	if _, ok := svc.Labels[beacon.LabelIdentifier]; ok {
+		t.Errorf("label of confort must be excluded: %v", svc.Labels)
+	}
+	if _, ok := svc.Networks[namespace]; !ok {
+		t.Errorf("service doesn't join the network: %v", svc.Networks)
+	}
+
+	// the exported file can be loaded and run in another namespace
+	path := filepath.Join(t.TempDir(), "compose.yaml")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := confort.LoadCompose(path)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, buf.String())
+	}
+	cft2, err := confort.New(ctx,
+		confort.WithNamespace(namespace+"-loaded", true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft2.Close()
+	})
+	containers, err := cft2.RunStack(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containers["one"] == nil {
+		t.Fatalf("unexpected containers: %v", containers)
+	}
+}
@@ -2,217 +2,443 @@ package confort
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
-	"github.com/goccy/go-reflect"
-	"github.com/google/go-cmp/cmp"
-	"go.uber.org/multierr"
+	"github.com/docker/go-connections/nat"
 )
 
+// ConsistencyMode specifies how strictly the field of the configurations is checked
+// by WithConfigConsistency.
+type ConsistencyMode string
+
+const (
+	// ConsistencyIgnore skips the check of the field.
+	ConsistencyIgnore ConsistencyMode = "ignore"
+	// ConsistencySubset requires that the specified value is contained in the value of
+	// the existing container. Empty value is always consistent.
+	ConsistencySubset ConsistencyMode = "subset"
+	// ConsistencyEqual requires that the specified value equals to the value of the
+	// existing container.
+	ConsistencyEqual ConsistencyMode = "equal"
+)
+
+// ConfigMismatch describes the field inconsistent with the existing container.
+type ConfigMismatch struct {
+	// Path is the path of the field, such as "Config.Env" and "HostConfig.PortBindings".
+	Path string
+	// Network is the name of the network, if the field belongs to "EndpointSettings".
+	Network string
+	// Expected is the specified value.
+	Expected any
+	// Actual is the value of the existing container.
+	Actual any
+}
+
+func (m ConfigMismatch) String() string {
+	path := m.Path
+	if m.Network != "" {
+		path = fmt.Sprintf("%s(network %q)", path, m.Network)
+	}
+	return fmt.Sprintf("%s: expected %+v, actual %+v", path, m.Expected, m.Actual)
+}
+
+// ConfigMismatchError is returned by Confort.Run with WithConfigConsistency, when the
+// configurations of the existing container are inconsistent with the specified ones.
+type ConfigMismatchError struct {
+	Container  string
+	Mismatches []ConfigMismatch
+}
+
+func (e *ConfigMismatchError) Error() string {
+	b := &strings.Builder{}
+	_, _ = fmt.Fprintf(b, "container %q has inconsistent configurations", e.Container)
+	for _, m := range e.Mismatches {
+		b.WriteString("\n\t" + m.String())
+	}
+	return b.String()
+}
+
+// defaultConsistencyModes lists the fields to be checked and its default mode.
+// The fields not listed here are not checked.
+var defaultConsistencyModes = map[string]ConsistencyMode{
+	"Config.Hostname":     ConsistencySubset,
+	"Config.Domainname":   ConsistencySubset,
+	"Config.User":         ConsistencySubset,
+	"Config.ExposedPorts": ConsistencySubset,
+	"Config.Env":          ConsistencySubset,
+	"Config.Cmd":          ConsistencySubset,
+	"Config.Healthcheck":  ConsistencySubset,
+	"Config.ArgsEscaped":  ConsistencyEqual,
+	"Config.Image":        ConsistencyEqual,
+	"Config.Volumes":      ConsistencySubset,
+	"Config.WorkingDir":   ConsistencyEqual,
+	"Config.Entrypoint":   ConsistencySubset,
+	"Config.MacAddress":   ConsistencySubset,
+	"Config.Labels":       ConsistencySubset,
+	"Config.StopSignal":   ConsistencySubset,
+	"Config.StopTimeout":  ConsistencySubset,
+	"Config.Shell":        ConsistencySubset,
+
+	"HostConfig.Binds":           ConsistencySubset,
+	"HostConfig.NetworkMode":     ConsistencySubset,
+	"HostConfig.PortBindings":    ConsistencySubset,
+	"HostConfig.RestartPolicy":   ConsistencySubset,
+	"HostConfig.VolumeDriver":    ConsistencySubset,
+	"HostConfig.VolumesFrom":     ConsistencySubset,
+	"HostConfig.CapAdd":          ConsistencySubset,
+	"HostConfig.CapDrop":         ConsistencySubset,
+	"HostConfig.CgroupnsMode":    ConsistencySubset,
+	"HostConfig.DNS":             ConsistencySubset,
+	"HostConfig.DNSOptions":      ConsistencySubset,
+	"HostConfig.DNSSearch":       ConsistencySubset,
+	"HostConfig.ExtraHosts":      ConsistencySubset,
+	"HostConfig.GroupAdd":        ConsistencySubset,
+	"HostConfig.IpcMode":         ConsistencySubset,
+	"HostConfig.Cgroup":          ConsistencySubset,
+	"HostConfig.Links":           ConsistencySubset,
+	"HostConfig.PidMode":         ConsistencySubset,
+	"HostConfig.Privileged":      ConsistencyEqual,
+	"HostConfig.PublishAllPorts": ConsistencyEqual,
+	"HostConfig.ReadonlyRootfs":  ConsistencyEqual,
+	"HostConfig.SecurityOpt":     ConsistencySubset,
+	"HostConfig.StorageOpt":      ConsistencySubset,
+	"HostConfig.Tmpfs":           ConsistencySubset,
+	"HostConfig.Sysctls":         ConsistencySubset,
+	"HostConfig.Resources":       ConsistencySubset,
+	"HostConfig.Mounts":          ConsistencySubset,
+
+	"EndpointSettings.IPAMConfig":        ConsistencySubset,
+	"EndpointSettings.Links":             ConsistencySubset,
+	"EndpointSettings.Aliases":           ConsistencySubset,
+	"EndpointSettings.NetworkID":         ConsistencyEqual,
+	"EndpointSettings.Gateway":           ConsistencySubset,
+	"EndpointSettings.IPAddress":         ConsistencySubset,
+	"EndpointSettings.IPv6Gateway":       ConsistencySubset,
+	"EndpointSettings.GlobalIPv6Address": ConsistencySubset,
+	"EndpointSettings.MacAddress":        ConsistencySubset,
+	"EndpointSettings.DriverOpts":        ConsistencySubset,
+}
+
+// validateConsistencyModes checks that the modes are specified for the known fields.
+func validateConsistencyModes(modes map[string]ConsistencyMode) error {
+	var unknown []string
+	for path, mode := range modes {
+		if _, ok := defaultConsistencyModes[path]; !ok {
+			unknown = append(unknown, path)
+			continue
+		}
+		switch mode {
+		case ConsistencyIgnore, ConsistencySubset, ConsistencyEqual:
+		default:
+			return fmt.Errorf("invalid consistency mode of %s: %q", path, mode)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown fields for consistency check: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// consistencyChecker collects the mismatches of the configurations.
+type consistencyChecker struct {
+	modes      map[string]ConsistencyMode
+	network    string
+	mismatches []ConfigMismatch
+}
+
+// check records the mismatch of the field according to its mode.
+// subset reports whether expected is a subset of actual.
+func (c *consistencyChecker) check(path string, expected, actual any, subset bool) {
+	mode, ok := c.modes[path]
+	if !ok {
+		mode = defaultConsistencyModes[path]
+	}
+	switch mode {
+	case ConsistencySubset:
+		if subset {
+			return
+		}
+	case ConsistencyEqual:
+		if isEmptyValue(expected) && isEmptyValue(actual) || reflect.DeepEqual(expected, actual) {
+			return
+		}
+	default:
+		return
+	}
+	c.mismatches = append(c.mismatches, ConfigMismatch{
+		Path:     path,
+		Network:  c.network,
+		Expected: expected,
+		Actual:   actual,
+	})
+}
+
+// checkConfigConsistency checks the specified configurations(container1, host1 and network1)
+// are consistent with the configurations of the existing container(container2, host2 and network2).
 func checkConfigConsistency(
+	name string, modes map[string]ConsistencyMode,
 	container1, container2 *container.Config,
 	host1, host2 *container.HostConfig,
 	network1, network2 map[string]*network.EndpointSettings,
-) (err error) {
-	if err = checkContainerConfigConsistency(container2, container1); err != nil {
-		return fmt.Errorf("inconsistent container config\n %w", err)
-	}
-	if err = checkHostConfigConsistency(host2, host1); err != nil {
-		return fmt.Errorf("inconsistent host config\n%w", err)
+) error {
+	c := &consistencyChecker{
+		modes: modes,
 	}
-	if err = checkEndpointSettingsConsistency(network2, network1); err != nil {
-		return fmt.Errorf("inconsistent network config\n%w", err)
+	c.checkContainerConfig(container1, container2)
+	c.checkHostConfig(host1, host2)
+	c.checkEndpointSettings(network1, network2)
+	if len(c.mismatches) > 0 {
+		return &ConfigMismatchError{
+			Container:  name,
+			Mismatches: c.mismatches,
+		}
 	}
 	return nil
 }
 
-func checkContainerConfigConsistency(expected, target *container.Config) error {
-	return multierr.Combine(
-		stringSubset("Hostname", expected.Hostname, target.Hostname),
-		stringSubset("Hostname", expected.Hostname, target.Hostname),
-		stringSubset("Domainname", expected.Domainname, target.Domainname),
-		stringSubset("User", expected.User, target.User),
-		// AttachStdin
-		// AttachStdout
-		// AttachStderr
-		mapSubset("ExposedPorts", expected.ExposedPorts, target.ExposedPorts),
-		// Tty
-		// OpenStdin
-		// StdinOnce
-		sliceSubset("Env", expected.Env, target.Env),
-		sequentialSubset("Cmd", expected.Cmd, target.Cmd),
-		pointerSubset("Healthcheck", expected.Healthcheck, target.Healthcheck),
-		equals("ArgsEscaped", expected.ArgsEscaped, target.ArgsEscaped),
-		equals("Image", expected.Image, target.Image),
-		mapSubset("Volumes", expected.Volumes, target.Volumes),
-		equals("WorkingDir", expected.WorkingDir, target.WorkingDir),
-		sequentialSubset("Entrypoint", expected.Entrypoint, target.Entrypoint),
-		// NetworkDisabled
-		stringSubset("MacAddress", expected.MacAddress, target.MacAddress),
-		// OnBuild
-		mapSubset("Labels", expected.Labels, target.Labels),
-		stringSubset("StopSignal", expected.StopSignal, target.StopSignal),
-		pointerSubset("StopTimeout", expected.StopTimeout, target.StopTimeout),
-		sequentialSubset("Shell", expected.Shell, target.Shell),
-	)
-}
-
-func checkHostConfigConsistency(expected, target *container.HostConfig) error {
-	return multierr.Combine(
-		sliceSubset("Binds", expected.Binds, target.Binds),
-		// ContainerIDFile string
-		// LogConfig
-		stringSubset("NetworkMode", string(expected.NetworkMode), string(target.NetworkMode)),
-		// TODO: ("PortBindings", expected.PortBindings, target.PortBindings),
-		stringSubset("RestartPolicy", expected.RestartPolicy.Name, target.RestartPolicy.Name),
-		// AutoRemove
-		stringSubset("VolumeDriver", expected.VolumeDriver, target.VolumeDriver),
-		sliceSubset("VolumesFrom", expected.VolumesFrom, target.VolumesFrom),
-
-		// Applicable to UNIX platforms
-		sliceSubset("CapAdd", expected.CapAdd, target.CapAdd),
-		sliceSubset("CapDrop", expected.CapDrop, target.CapDrop),
-		stringSubset("CgroupsMode", string(expected.CgroupnsMode), string(target.CgroupnsMode)),
-		sliceSubset("DNS", expected.DNS, target.DNS),
-		sliceSubset("DNSOptions", expected.DNSOptions, target.DNSOptions),
-		sliceSubset("DNSSearch", expected.DNSSearch, target.DNSSearch),
-		sliceSubset("ExtraHosts", expected.ExtraHosts, target.ExtraHosts),
-		sliceSubset("GroupAdd", expected.GroupAdd, target.GroupAdd),
-		stringSubset("IpcMode", string(expected.IpcMode), string(target.IpcMode)),
-		stringSubset("Cgroup", string(expected.Cgroup), string(target.Cgroup)),
-		sliceSubset("Links", expected.Links, target.Links),
-		// OomScoreAdj
-		stringSubset("PidMode", string(expected.PidMode), string(target.PidMode)),
-		equals("Privileged", expected.Privileged, target.Privileged),
-		equals("PublishAllPorts", expected.PublishAllPorts, target.PublishAllPorts),
-		equals("ReadonlyRootfs", expected.ReadonlyRootfs, target.ReadonlyRootfs),
-		sliceSubset("SecurityOpt", expected.SecurityOpt, target.SecurityOpt),
-		mapSubset("StorageOpt", expected.StorageOpt, target.StorageOpt),
-		mapSubset("Tmpfs", expected.Tmpfs, target.Tmpfs),
-		// UTSMode
-		// UsernsMode
-		// ShmSize
-		// Sysctls
-		// Runtime
-
-		// Applicable to Windows
-		// ConsoleSize
-		// Isolation
-
-		// Contains container's resources (cgroups, ulimits)
-		// Resources
-
-		// Mounts specs used by the container
-		// TODO: ("Mounts", expected.Mounts, target.Mounts),
-
-		// MaskedPaths is the list of paths to be masked inside the container (this overrides the default set of paths)
-		// MaskedPaths
-
-		// ReadonlyPaths is the list of paths to be set as read-only inside the container (this overrides the default set of paths)
-		// ReadonlyPaths
-
-		// Run a custom init inside the container, if null, use the daemon's configured settings
-		// Init
-	)
-}
-
-func checkEndpointSettingsConsistency(expected, target map[string]*network.EndpointSettings) (err error) {
-	for networkName, settings := range target {
-		var e error
-		if expectedSettings, ok := expected[networkName]; !ok {
-			// e = fmt.Errorf("%s: %s", networkName, cmp.Diff(expectedSettings, settings))
-		} else {
-			e = multierr.Combine(
-				pointerSubset("IPAMConfig", expectedSettings.IPAMConfig, settings.IPAMConfig),
-				sliceSubset("Links", expectedSettings.Links, settings.Links),
-				sliceSubset("Aliases", expectedSettings.Aliases, settings.Aliases),
-				equals("NetworkID", expectedSettings.NetworkID, settings.NetworkID),
-				// EndpointID
-				stringSubset("Gateway", expectedSettings.Gateway, settings.Gateway),
-				stringSubset("IPAddress", expectedSettings.IPAddress, settings.IPAddress),
-				// equals("IPPrefixLen", expectedSettings.IPPrefixLen, settings.IPPrefixLen),
-				stringSubset("IPv6Gateway", expectedSettings.IPv6Gateway, settings.IPv6Gateway),
-				stringSubset("GlobalIPv6Address", expectedSettings.GlobalIPv6Address, settings.GlobalIPv6Address),
-				// equals("GlobalIPv6PrefixLen", expectedSettings.GlobalIPv6PrefixLen, settings.GlobalIPv6PrefixLen),
-				stringSubset("MacAddress", expectedSettings.MacAddress, settings.MacAddress),
-				mapSubset("DriverOpts", expectedSettings.DriverOpts, settings.DriverOpts),
-			)
-		}
-		if e != nil {
-			err = multierr.Append(err, fmt.Errorf("%s: %w", networkName, e))
+func (c *consistencyChecker) checkContainerConfig(expected, actual *container.Config) {
+	// fingerprint differs even if the configurations are consistent
+	expectedLabels := withoutFingerprint(expected.Labels)
+	actualLabels := withoutFingerprint(actual.Labels)
+
+	c.check("Config.Hostname", expected.Hostname, actual.Hostname, stringSubset(expected.Hostname, actual.Hostname))
+	c.check("Config.Domainname", expected.Domainname, actual.Domainname, stringSubset(expected.Domainname, actual.Domainname))
+	c.check("Config.User", expected.User, actual.User, stringSubset(expected.User, actual.User))
+	// AttachStdin
+	// AttachStdout
+	// AttachStderr
+	c.check("Config.ExposedPorts", expected.ExposedPorts, actual.ExposedPorts, mapSubset(expected.ExposedPorts, actual.ExposedPorts))
+	// Tty
+	// OpenStdin
+	// StdinOnce
+	c.check("Config.Env", expected.Env, actual.Env, sliceSubset(expected.Env, actual.Env))
+	c.check("Config.Cmd", expected.Cmd, actual.Cmd, sequentialSubset(expected.Cmd, actual.Cmd))
+	c.check("Config.Healthcheck", expected.Healthcheck, actual.Healthcheck, pointerSubset(expected.Healthcheck, actual.Healthcheck))
+	c.check("Config.ArgsEscaped", expected.ArgsEscaped, actual.ArgsEscaped, boolSubset(expected.ArgsEscaped, actual.ArgsEscaped))
+	c.check("Config.Image", expected.Image, actual.Image, stringSubset(expected.Image, actual.Image))
+	c.check("Config.Volumes", expected.Volumes, actual.Volumes, mapSubset(expected.Volumes, actual.Volumes))
+	c.check("Config.WorkingDir", expected.WorkingDir, actual.WorkingDir, stringSubset(expected.WorkingDir, actual.WorkingDir))
+	c.check("Config.Entrypoint", expected.Entrypoint, actual.Entrypoint, sequentialSubset(expected.Entrypoint, actual.Entrypoint))
+	// NetworkDisabled
+	c.check("Config.MacAddress", expected.MacAddress, actual.MacAddress, stringSubset(expected.MacAddress, actual.MacAddress))
+	// OnBuild
+	c.check("Config.Labels", expectedLabels, actualLabels, mapSubset(expectedLabels, actualLabels))
+	c.check("Config.StopSignal", expected.StopSignal, actual.StopSignal, stringSubset(expected.StopSignal, actual.StopSignal))
+	c.check("Config.StopTimeout", expected.StopTimeout, actual.StopTimeout, pointerSubset(expected.StopTimeout, actual.StopTimeout))
+	c.check("Config.Shell", expected.Shell, actual.Shell, sequentialSubset(expected.Shell, actual.Shell))
+}
+
+func (c *consistencyChecker) checkHostConfig(expected, actual *container.HostConfig) {
+	c.check("HostConfig.Binds", expected.Binds, actual.Binds, sliceSubset(expected.Binds, actual.Binds))
+	// ContainerIDFile string
+	// LogConfig
+	c.check("HostConfig.NetworkMode", expected.NetworkMode, actual.NetworkMode, stringSubset(expected.NetworkMode, actual.NetworkMode))
+	c.check("HostConfig.PortBindings", expected.PortBindings, actual.PortBindings, portBindingsSubset(expected.PortBindings, actual.PortBindings))
+	c.check("HostConfig.RestartPolicy", expected.RestartPolicy, actual.RestartPolicy, structSubset(expected.RestartPolicy, actual.RestartPolicy))
+	// AutoRemove
+	c.check("HostConfig.VolumeDriver", expected.VolumeDriver, actual.VolumeDriver, stringSubset(expected.VolumeDriver, actual.VolumeDriver))
+	c.check("HostConfig.VolumesFrom", expected.VolumesFrom, actual.VolumesFrom, sliceSubset(expected.VolumesFrom, actual.VolumesFrom))
+
+	// Applicable to UNIX platforms
+	c.check("HostConfig.CapAdd", expected.CapAdd, actual.CapAdd, sliceSubset(expected.CapAdd, actual.CapAdd))
+	c.check("HostConfig.CapDrop", expected.CapDrop, actual.CapDrop, sliceSubset(expected.CapDrop, actual.CapDrop))
+	c.check("HostConfig.CgroupnsMode", expected.CgroupnsMode, actual.CgroupnsMode, stringSubset(expected.CgroupnsMode, actual.CgroupnsMode))
+	c.check("HostConfig.DNS", expected.DNS, actual.DNS, sliceSubset(expected.DNS, actual.DNS))
+	c.check("HostConfig.DNSOptions", expected.DNSOptions, actual.DNSOptions, sliceSubset(expected.DNSOptions, actual.DNSOptions))
+	c.check("HostConfig.DNSSearch", expected.DNSSearch, actual.DNSSearch, sliceSubset(expected.DNSSearch, actual.DNSSearch))
+	c.check("HostConfig.ExtraHosts", expected.ExtraHosts, actual.ExtraHosts, sliceSubset(expected.ExtraHosts, actual.ExtraHosts))
+	c.check("HostConfig.GroupAdd", expected.GroupAdd, actual.GroupAdd, sliceSubset(expected.GroupAdd, actual.GroupAdd))
+	c.check("HostConfig.IpcMode", expected.IpcMode, actual.IpcMode, stringSubset(expected.IpcMode, actual.IpcMode))
+	c.check("HostConfig.Cgroup", expected.Cgroup, actual.Cgroup, stringSubset(expected.Cgroup, actual.Cgroup))
+	c.check("HostConfig.Links", expected.Links, actual.Links, sliceSubset(expected.Links, actual.Links))
+	// OomScoreAdj
+	c.check("HostConfig.PidMode", expected.PidMode, actual.PidMode, stringSubset(expected.PidMode, actual.PidMode))
+	c.check("HostConfig.Privileged", expected.Privileged, actual.Privileged, boolSubset(expected.Privileged, actual.Privileged))
+	c.check("HostConfig.PublishAllPorts", expected.PublishAllPorts, actual.PublishAllPorts, boolSubset(expected.PublishAllPorts, actual.PublishAllPorts))
+	c.check("HostConfig.ReadonlyRootfs", expected.ReadonlyRootfs, actual.ReadonlyRootfs, boolSubset(expected.ReadonlyRootfs, actual.ReadonlyRootfs))
+	c.check("HostConfig.SecurityOpt", expected.SecurityOpt, actual.SecurityOpt, sliceSubset(expected.SecurityOpt, actual.SecurityOpt))
+	c.check("HostConfig.StorageOpt", expected.StorageOpt, actual.StorageOpt, mapSubset(expected.StorageOpt, actual.StorageOpt))
+	c.check("HostConfig.Tmpfs", expected.Tmpfs, actual.Tmpfs, mapSubset(expected.Tmpfs, actual.Tmpfs))
+	// UTSMode
+	// UsernsMode
+	// ShmSize
+	c.check("HostConfig.Sysctls", expected.Sysctls, actual.Sysctls, mapSubset(expected.Sysctls, actual.Sysctls))
+	// Runtime
+
+	// Applicable to Windows
+	// ConsoleSize
+	// Isolation
+
+	// Contains container's resources (cgroups, ulimits)
+	c.check("HostConfig.Resources", expected.Resources, actual.Resources, structSubset(expected.Resources, actual.Resources))
+
+	// Mounts specs used by the container
+	c.check("HostConfig.Mounts", expected.Mounts, actual.Mounts, mountsSubset(expected.Mounts, actual.Mounts))
+
+	// MaskedPaths is the list of paths to be masked inside the container (this overrides the default set of paths)
+	// MaskedPaths
+
+	// ReadonlyPaths is the list of paths to be set as read-only inside the container (this overrides the default set of paths)
+	// ReadonlyPaths
+
+	// Run a custom init inside the container, if null, use the daemon's configured settings
+	// Init
+}
+
+func (c *consistencyChecker) checkEndpointSettings(expected, actual map[string]*network.EndpointSettings) {
+	names := make([]string, 0, len(expected))
+	for networkName := range expected {
+		names = append(names, networkName)
+	}
+	sort.Strings(names)
+
+	for _, networkName := range names {
+		e := expected[networkName]
+		a, ok := actual[networkName]
+		if !ok {
+			// the existing container is connected to the network later
+			continue
 		}
+		c.network = networkName
+		c.check("EndpointSettings.IPAMConfig", e.IPAMConfig, a.IPAMConfig, pointerSubset(e.IPAMConfig, a.IPAMConfig))
+		c.check("EndpointSettings.Links", e.Links, a.Links, sliceSubset(e.Links, a.Links))
+		c.check("EndpointSettings.Aliases", e.Aliases, a.Aliases, sliceSubset(e.Aliases, a.Aliases))
+		c.check("EndpointSettings.NetworkID", e.NetworkID, a.NetworkID, stringSubset(e.NetworkID, a.NetworkID))
+		// EndpointID
+		c.check("EndpointSettings.Gateway", e.Gateway, a.Gateway, stringSubset(e.Gateway, a.Gateway))
+		c.check("EndpointSettings.IPAddress", e.IPAddress, a.IPAddress, stringSubset(e.IPAddress, a.IPAddress))
+		// IPPrefixLen
+		c.check("EndpointSettings.IPv6Gateway", e.IPv6Gateway, a.IPv6Gateway, stringSubset(e.IPv6Gateway, a.IPv6Gateway))
+		c.check("EndpointSettings.GlobalIPv6Address", e.GlobalIPv6Address, a.GlobalIPv6Address, stringSubset(e.GlobalIPv6Address, a.GlobalIPv6Address))
+		// GlobalIPv6PrefixLen
+		c.check("EndpointSettings.MacAddress", e.MacAddress, a.MacAddress, stringSubset(e.MacAddress, a.MacAddress))
+		c.check("EndpointSettings.DriverOpts", e.DriverOpts, a.DriverOpts, mapSubset(e.DriverOpts, a.DriverOpts))
 	}
-	return err
+	c.network = ""
 }
 
-func stringSubset(name, expected, target string) (err error) {
-	if target == "" || target == expected {
-		return nil
+func withoutFingerprint(labels map[string]string) map[string]string {
+	if _, ok := labels[labelFingerprint]; !ok {
+		return labels
 	}
-	return diffError(name, expected, target)
+	m := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if k != labelFingerprint {
+			m[k] = v
+		}
+	}
+	return m
 }
 
-func sliceSubset[T comparable](name string, expected, target []T) (err error) {
-	if len(target) == 0 {
-		return nil
-	} else if len(expected) == 0 {
-		return diffError(name, expected, target)
+func stringSubset[T ~string](expected, actual T) bool {
+	return expected == "" || expected == actual
+}
+
+func boolSubset(expected, actual bool) bool {
+	return !expected || actual
+}
+
+func sliceSubset[T comparable](expected, actual []T) bool {
+	act := make(map[T]bool, len(actual))
+	for _, t := range actual {
+		act[t] = true
 	}
-	exp := make(map[T]bool)
 	for _, t := range expected {
-		exp[t] = true
-	}
-	for _, t := range target {
-		if !exp[t] {
-			return diffError(name, expected, target)
+		if !act[t] {
+			return false
 		}
 	}
-	return nil
+	return true
 }
 
-func sequentialSubset[T comparable](name string, expected, target []T) (err error) {
-	if len(target) == 0 {
-		return nil
-	} else if len(expected) == 0 || !reflect.DeepEqual(expected, target) {
-		return diffError(name, expected, target)
-	}
-	return nil
+func sequentialSubset[T comparable](expected, actual []T) bool {
+	return len(expected) == 0 || reflect.DeepEqual(expected, actual)
 }
 
-func mapSubset[K, V comparable](name string, expected, target map[K]V) error {
-	if len(target) == 0 {
-		return nil
-	} else if len(expected) == 0 {
-		return diffError(name, expected, target)
+func mapSubset[K, V comparable](expected, actual map[K]V) bool {
+	for k, v := range expected {
+		a, ok := actual[k]
+		if !ok || a != v {
+			return false
+		}
 	}
-	for k, v := range target {
-		e, ok := expected[k]
-		if !ok || e != v {
-			return diffError(name, expected, target)
+	return true
+}
+
+func pointerSubset[T any](expected, actual *T) bool {
+	return expected == nil || reflect.DeepEqual(expected, actual)
+}
+
+// structSubset reports whether the non-zero fields of expected equal to the fields of actual.
+func structSubset[T any](expected, actual T) bool {
+	e := reflect.ValueOf(expected)
+	a := reflect.ValueOf(actual)
+	for i := 0; i < e.NumField(); i++ {
+		f := e.Field(i)
+		if isEmptyValue(f.Interface()) {
+			continue
+		}
+		if !reflect.DeepEqual(f.Interface(), a.Field(i).Interface()) {
+			return false
 		}
 	}
-	return nil
+	return true
 }
 
-func pointerSubset[T any](name string, expected, target *T) (err error) {
-	if target == nil {
-		return nil
-	} else if expected == nil || !reflect.DeepEqual(expected, target) {
-		return diffError(name, expected, target)
+// portBindingsSubset reports whether each binding of expected has the corresponding
+// binding in actual. Empty HostIP or HostPort of expected matches any value.
+func portBindingsSubset(expected, actual nat.PortMap) bool {
+	for port, bindings := range expected {
+		actualBindings, ok := actual[port]
+		if !ok {
+			return false
+		}
+	BINDINGS:
+		for _, e := range bindings {
+			for _, a := range actualBindings {
+				if stringSubset(e.HostIP, a.HostIP) && stringSubset(e.HostPort, a.HostPort) {
+					continue BINDINGS
+				}
+			}
+			return false
+		}
 	}
-	return nil
+	return true
 }
 
-func equals(name string, expected, target any) error {
-	diff := cmp.Diff(expected, target)
-	if diff != "" {
-		return fmt.Errorf("%s\n%s", name, diff)
+// mountsSubset reports whether each mount of expected has the mount of the same target
+// in actual. The non-zero fields of the mount of expected must equal to actual's.
+func mountsSubset(expected, actual []mount.Mount) bool {
+	targets := make(map[string]mount.Mount, len(actual))
+	for _, m := range actual {
+		targets[m.Target] = m
 	}
-	return nil
+	for _, e := range expected {
+		a, ok := targets[e.Target]
+		if !ok || !structSubset(e, a) {
+			return false
+		}
+	}
+	return true
 }
 
-func diffError(msg string, expected, target any) error {
-	diff := cmp.Diff(expected, target)
-	return fmt.Errorf("%s\n%s", msg, diff)
+// isEmptyValue reports whether v is nil or the zero value, or has no elements.
+func isEmptyValue(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return rv.IsNil()
+	}
+	return rv.IsZero()
 }
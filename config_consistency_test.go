@@ -0,0 +1,217 @@
+package confort
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestCheckConfigConsistency(t *testing.T) {
+	t.Parallel()
+
+	existing := func() (*container.Config, *container.HostConfig, map[string]*network.EndpointSettings) {
+		return &container.Config{
+			Image: "alpine:3.16",
+			Env:   []string{"PATH=/usr/bin", "A=1", "B=2"},
+			Cmd:   []string{"sleep", "infinity"},
+			Labels: map[string]string{
+				"label":          "value",
+				labelFingerprint: "existing",
+			},
+		}, &container.HostConfig{
+			PortBindings: nat.PortMap{
+				"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "49153"}},
+			},
+			Mounts: []mount.Mount{
+				{Type: mount.TypeVolume, Source: "data", Target: "/data"},
+				{Type: mount.TypeTmpfs, Target: "/tmp"},
+			},
+			Resources: container.Resources{
+				Memory:    64 << 20,
+				CPUShares: 512,
+			},
+			Sysctls: map[string]string{
+				"net.core.somaxconn": "1024",
+			},
+			Tmpfs: map[string]string{
+				"/run": "rw",
+			},
+		}, map[string]*network.EndpointSettings{
+			"network": {NetworkID: "id", Aliases: []string{"alpine", "abcdef"}},
+		}
+	}
+
+	testCases := []struct {
+		desc   string
+		modify func(c *container.Config, h *container.HostConfig, n map[string]*network.EndpointSettings)
+		modes  map[string]ConsistencyMode
+		paths  []string
+	}{
+		{
+			desc: "same",
+		}, {
+			desc: "subset",
+			modify: func(c *container.Config, h *container.HostConfig, n map[string]*network.EndpointSettings) {
+				c.Env = []string{"A=1"}
+				c.Labels = map[string]string{labelFingerprint: "specified"}
+				h.PortBindings["80/tcp"][0].HostPort = ""
+				h.Mounts = h.Mounts[:1]
+				h.Resources = container.Resources{Memory: 64 << 20}
+				h.Sysctls = nil
+				h.Tmpfs = nil
+				n["network"].Aliases = []string{"alpine"}
+			},
+		}, {
+			desc: "extra values",
+			modify: func(c *container.Config, h *container.HostConfig, n map[string]*network.EndpointSettings) {
+				c.Env = append(c.Env, "C=3")
+				h.PortBindings["8080/tcp"] = []nat.PortBinding{{HostIP: "127.0.0.1"}}
+				h.Mounts = append(h.Mounts, mount.Mount{Type: mount.TypeVolume, Source: "logs", Target: "/logs"})
+				h.Resources.PidsLimit = new(int64)
+				h.Sysctls["net.ipv4.ip_forward"] = "1"
+				h.Tmpfs["/var"] = "rw"
+				n["network"].Aliases = append(n["network"].Aliases, "busybox")
+			},
+			paths: []string{
+				"Config.Env",
+				"HostConfig.PortBindings",
+				"HostConfig.Tmpfs",
+				"HostConfig.Sysctls",
+				"HostConfig.Resources",
+				"HostConfig.Mounts",
+				"EndpointSettings.Aliases",
+			},
+		}, {
+			desc: "different values",
+			modify: func(c *container.Config, h *container.HostConfig, n map[string]*network.EndpointSettings) {
+				c.Image = "alpine:3.17"
+				h.PortBindings["80/tcp"][0].HostIP = "0.0.0.0"
+				h.Mounts[0].Source = "other"
+				h.Resources.Memory = 128 << 20
+				h.Sysctls["net.core.somaxconn"] = "4096"
+			},
+			paths: []string{
+				"Config.Image",
+				"HostConfig.PortBindings",
+				"HostConfig.Sysctls",
+				"HostConfig.Resources",
+				"HostConfig.Mounts",
+			},
+		}, {
+			desc: "ignore",
+			modify: func(c *container.Config, h *container.HostConfig, _ map[string]*network.EndpointSettings) {
+				c.Env = append(c.Env, "C=3")
+				h.Mounts = nil
+				h.Resources.Memory = 128 << 20
+			},
+			modes: map[string]ConsistencyMode{
+				"Config.Env":           ConsistencyIgnore,
+				"HostConfig.Resources": ConsistencyIgnore,
+			},
+		}, {
+			desc: "equal",
+			modify: func(c *container.Config, h *container.HostConfig, _ map[string]*network.EndpointSettings) {
+				c.Env = []string{"A=1"}
+				h.Mounts = h.Mounts[:1]
+				h.Sysctls = map[string]string{
+					"net.core.somaxconn": "1024",
+				}
+			},
+			modes: map[string]ConsistencyMode{
+				"Config.Env":         ConsistencyEqual,
+				"HostConfig.Mounts":  ConsistencyEqual,
+				"HostConfig.Sysctls": ConsistencyEqual,
+			},
+			paths: []string{
+				"Config.Env",
+				"HostConfig.Mounts",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			c1, h1, n1 := existing()
+			c2, h2, n2 := existing()
+			if tc.modify != nil {
+				tc.modify(c1, h1, n1)
+			}
+			err := checkConfigConsistency("container", tc.modes, c1, c2, h1, h2, n1, n2)
+			if len(tc.paths) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			var mismatchErr *ConfigMismatchError
+			if !errors.As(err, &mismatchErr) {
+				t.Fatalf("expected *ConfigMismatchError, but got %v", err)
+			}
+			assertEqual(t, "container", mismatchErr.Container)
+			paths := make([]string, 0, len(mismatchErr.Mismatches))
+			for _, m := range mismatchErr.Mismatches {
+				paths = append(paths, m.Path)
+			}
+			assertEqual(t, strings.Join(tc.paths, ","), strings.Join(paths, ","))
+		})
+	}
+}
+
+func TestConfigMismatchError(t *testing.T) {
+	t.Parallel()
+
+	err := &ConfigMismatchError{
+		Container: "container",
+		Mismatches: []ConfigMismatch{
+			{
+				Path:     "Config.Env",
+				Expected: []string{"A=1"},
+				Actual:   []string{"A=2"},
+			}, {
+				Path:     "EndpointSettings.Aliases",
+				Network:  "network",
+				Expected: []string{"alpine"},
+				Actual:   []string{"busybox"},
+			},
+		},
+	}
+	expected := `container "container" has inconsistent configurations
+	Config.Env: expected [A=1], actual [A=2]
+	EndpointSettings.Aliases(network "network"): expected [alpine], actual [busybox]`
+	assertEqual(t, expected, err.Error())
+}
+
+func TestValidateConsistencyModes(t *testing.T) {
+	t.Parallel()
+
+	err := validateConsistencyModes(map[string]ConsistencyMode{
+		"Config.Env":        ConsistencyEqual,
+		"HostConfig.Mounts": ConsistencyIgnore,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = validateConsistencyModes(map[string]ConsistencyMode{
+		"Config.Unknown": ConsistencyEqual,
+	})
+	if err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+
+	err = validateConsistencyModes(map[string]ConsistencyMode{
+		"Config.Env": "strict",
+	})
+	if err == nil {
+		t.Fatal("expected error for invalid mode")
+	}
+}
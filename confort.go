@@ -5,14 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"testing"
 	"time"
 
 	"github.com/daichitakahashi/confort/internal/beacon"
 	"github.com/daichitakahashi/confort/internal/beacon/proto"
+	"github.com/daichitakahashi/confort/internal/coverage"
 	"github.com/daichitakahashi/confort/internal/exclusion"
 	"github.com/daichitakahashi/confort/internal/logging"
 	"github.com/daichitakahashi/confort/wait"
@@ -49,6 +55,12 @@ type Confort struct {
 	cli            *client.Client
 	defaultTimeout time.Duration
 	ex             exclusion.Control
+	holders        *lockHolders
+	faults         *faults
+	proxies        *proxies
+	netemImage     string
+	helperImage    string
+	coverDir       string
 	term           func() error
 }
 
@@ -157,6 +169,9 @@ func New(ctx context.Context, opts ...NewOption) (cft *Confort, err error) {
 		namespace = os.Getenv(beacon.NamespaceEnv)
 		timeout   = time.Minute
 		policy    ResourcePolicy
+		netem     = defaultNetemImage
+		helper    = defaultHelperImage
+		coverDir  = os.Getenv(beacon.CoverDirEnv)
 	)
 	if s := os.Getenv(beacon.ResourcePolicyEnv); s != "" {
 		policy = ResourcePolicy(s)
@@ -182,6 +197,12 @@ func New(ctx context.Context, opts ...NewOption) (cft *Confort, err error) {
 				logging.Infof("resource policy is overwritten by WithResourcePolicy: %q -> %q", policy, newPolicy)
 			}
 			policy = newPolicy
+		case identOptionNetemImage{}:
+			netem = opt.Value().(string)
+		case identOptionHelperImage{}:
+			helper = opt.Value().(string)
+		case identOptionCoverDir{}:
+			coverDir = opt.Value().(string)
 		case identOptionBeacon{}:
 			conn, err := beacon.Connect(ctx)
 			if err != nil {
@@ -217,6 +238,18 @@ func New(ctx context.Context, opts ...NewOption) (cft *Confort, err error) {
 	}
 	logging.Debugf("resource policy: %s", policy)
 
+	if coverDir != "" {
+		coverDir, err = filepath.Abs(coverDir)
+		if err != nil {
+			return nil, fmt.Errorf("confort: %w", err)
+		}
+		_, err = coverage.Prepare(coverDir)
+		if err != nil {
+			return nil, fmt.Errorf("confort: %w", err)
+		}
+		logging.Debugf("coverage directory: %s", coverDir)
+	}
+
 	ctx, cancel := applyTimeout(ctx, timeout)
 	defer cancel()
 	cli, err := client.NewClientWithOpts(clientOps...)
@@ -231,6 +264,7 @@ func New(ctx context.Context, opts ...NewOption) (cft *Confort, err error) {
 	}
 	backend := &dockerBackend{
 		cli:    cli,
+		ex:     ex,
 		policy: policy,
 		labels: map[string]string{
 			beacon.LabelIdentifier: beacon.Identifier(beaconAddr),
@@ -264,7 +298,14 @@ func New(ctx context.Context, opts ...NewOption) (cft *Confort, err error) {
 		}
 		// release all resources
 		logging.Debugf("release all resources bound with namespace %q", namespace)
-		return multierr.Append(err, ns.Release(context.Background()))
+		err = multierr.Append(err, ns.Release(context.Background()))
+		if coverDir != "" {
+			// merge the coverage data written by the stopped containers
+			logging.Debugf("merge coverage data into %s", coverDir)
+			goCmd := coverage.GoCommand(os.Getenv(beacon.GoCommandEnv))
+			err = multierr.Append(err, coverage.Merge(context.Background(), goCmd, coverDir))
+		}
+		return err
 	}
 
 	return &Confort{
@@ -273,13 +314,19 @@ func New(ctx context.Context, opts ...NewOption) (cft *Confort, err error) {
 		cli:            cli,
 		defaultTimeout: timeout,
 		ex:             ex,
+		holders:        newLockHolders(),
+		faults:         newFaults(),
+		proxies:        newProxies(),
+		netemImage:     netem,
+		helperImage:    helper,
+		coverDir:       coverDir,
 		term:           term,
 	}, nil
 }
 
 // Close releases all created resources with cft.
 func (cft *Confort) Close() error {
-	return cft.term()
+	return multierr.Append(cft.proxies.close(), cft.term())
 }
 
 func applyTimeout(ctx context.Context, defaultTimeout time.Duration) (context.Context, context.CancelFunc) {
@@ -310,6 +357,8 @@ type (
 	}
 	identOptionImageBuildOptions struct{}
 	identOptionForceBuild        struct{}
+	identOptionBuildHash         struct{}
+	identOptionBuildKit          struct{}
 	identOptionBuildOutput       struct{}
 	buildOption                  struct{ option.Interface }
 )
@@ -331,6 +380,28 @@ func WithForceBuild() BuildOption {
 	}.build()
 }
 
+// WithBuildHash enables Build to detect the change of the build context. The hash of
+// the files in the context directory, the path of Dockerfile, build args, target and
+// platform is stored as the label of the image, and the image is rebuilt only if the
+// hash differs from the one of the existing image.
+//
+// Note that the whole build context is loaded on memory to calculate the hash.
+func WithBuildHash() BuildOption {
+	return buildOption{
+		Interface: option.New(identOptionBuildHash{}, true),
+	}.build()
+}
+
+// WithBuildKit enables Build to use BuildKit instead of the legacy builder.
+// It makes the features such as "RUN --mount=type=cache" available, and the progress
+// of BuildKit is written to the writer specified by WithBuildOutput.
+// When BuildParams.Secrets or BuildParams.SSH is specified, BuildKit is always used.
+func WithBuildKit() BuildOption {
+	return buildOption{
+		Interface: option.New(identOptionBuildKit{}, true),
+	}.build()
+}
+
 // WithBuildOutput sets dst that the output during build will be written.
 func WithBuildOutput(dst io.Writer) BuildOption {
 	return buildOption{
@@ -341,8 +412,17 @@ func WithBuildOutput(dst io.Writer) BuildOption {
 type BuildParams struct {
 	Image      string
 	Dockerfile string
+	// DockerfileContent specifies the content of Dockerfile instead of Dockerfile.
+	DockerfileContent string
+	// ContextDir specifies the directory of the build context. Files matched with
+	// .dockerignore in the directory are excluded.
 	ContextDir string
-	BuildArgs  map[string]*string
+	// ContextFS specifies the build context by fs.FS instead of ContextDir, e.g.
+	// embed.FS and fstest.MapFS. Like ContextDir, .dockerignore is respected.
+	// If both ContextDir and ContextFS are empty and DockerfileContent is specified,
+	// the build context is empty.
+	ContextFS fs.FS
+	BuildArgs map[string]*string
 	// RegistryAuth sets authentication config per registry host.
 	//
 	//  BuildParam{
@@ -355,12 +435,23 @@ type BuildParams struct {
 	//  }
 	RegistryAuth map[string]types.AuthConfig
 	Platform     string
+	// Target sets the build stage to build in multi-stage Dockerfile.
+	Target string
+	// Secrets exposes the secret files to the build with BuildKit, like "--secret"
+	// flag of "docker build". The key is the id of the secret, and the value is the
+	// path of the file.
+	Secrets map[string]string
+	// SSH forwards the SSH agent sockets or keys to the build with BuildKit, like
+	// "--ssh" flag of "docker build". The key is the id, and the value is the paths
+	// of the sockets or keys. If the paths are empty, $SSH_AUTH_SOCK is used.
+	SSH map[string][]string
 }
 
-// Build creates new image from given Dockerfile and context directory.
+// Build creates new image from given Dockerfile and build context.
 //
 // When same name image already exists, it doesn't perform building.
-// WithForceBuild enables us to build image on every call of Build.
+// WithForceBuild enables us to build image on every call of Build, and
+// WithBuildHash enables us to rebuild image only if the build context is changed.
 func (cft *Confort) Build(ctx context.Context, b *BuildParams, opts ...BuildOption) error {
 	buildOut := io.Discard
 
@@ -370,6 +461,9 @@ func (cft *Confort) Build(ctx context.Context, b *BuildParams, opts ...BuildOpti
 	var (
 		modifyBuildOptions func(option *types.ImageBuildOptions)
 		force              bool
+		buildHash          bool
+		buildKit           = len(b.Secrets) > 0 || len(b.SSH) > 0
+		cover              bool
 	)
 	for _, opt := range opts {
 		switch opt.Ident() {
@@ -377,6 +471,12 @@ func (cft *Confort) Build(ctx context.Context, b *BuildParams, opts ...BuildOpti
 			modifyBuildOptions = opt.Value().(func(option *types.ImageBuildOptions))
 		case identOptionForceBuild{}:
 			force = opt.Value().(bool)
+		case identOptionBuildHash{}:
+			buildHash = opt.Value().(bool)
+		case identOptionBuildKit{}:
+			buildKit = buildKit || opt.Value().(bool)
+		case identOptionBuildCoverage{}:
+			cover = opt.Value().(bool)
 		case identOptionBuildOutput{}:
 			out := opt.Value().(io.Writer)
 			if out != nil {
@@ -385,7 +485,7 @@ func (cft *Confort) Build(ctx context.Context, b *BuildParams, opts ...BuildOpti
 		}
 	}
 
-	tarball, relDockerfile, err := createArchive(b.ContextDir, b.Dockerfile)
+	tarball, relDockerfile, err := createBuildContext(b)
 	if err != nil {
 		return fmt.Errorf("confort: %w", err)
 	}
@@ -401,10 +501,16 @@ func (cft *Confort) Build(ctx context.Context, b *BuildParams, opts ...BuildOpti
 		Dockerfile:     relDockerfile,
 		BuildArgs:      b.BuildArgs,
 		AuthConfigs:    b.RegistryAuth,
-		Target:         "",
+		Target:         b.Target,
 		SessionID:      "",
 		Platform:       b.Platform,
 	}
+	if buildKit {
+		buildOption.Version = types.BuilderBuildKit
+	}
+	if cover {
+		buildOption.BuildArgs = coverFlags(buildOption.BuildArgs)
+	}
 	if modifyBuildOptions != nil {
 		modifyBuildOptions(&buildOption)
 	}
@@ -412,6 +518,21 @@ func (cft *Confort) Build(ctx context.Context, b *BuildParams, opts ...BuildOpti
 	if len(buildOption.Tags) == 0 {
 		return errors.New("confort: image tag not specified")
 	}
+	var (
+		buildContext io.Reader = tarball
+		hash         string
+	)
+	if buildHash {
+		buildContext, hash, err = hashBuildContext(tarball, buildOption)
+		if err != nil {
+			return fmt.Errorf("confort: %w", err)
+		}
+	}
+	attachables, err := buildKitAttachables(b.Secrets, b.SSH)
+	if err != nil {
+		return fmt.Errorf("confort: %w", err)
+	}
+
 	logging.Debugf("LockForBuild: %s", buildOption.Tags[0])
 	unlock, err := cft.ex.LockForBuild(ctx, buildOption.Tags[0])
 	if err != nil {
@@ -423,7 +544,7 @@ func (cft *Confort) Build(ctx context.Context, b *BuildParams, opts ...BuildOpti
 	}()
 
 	logging.Debugf("build image %q", buildOption.Tags[0])
-	err = cft.backend.BuildImage(ctx, tarball, buildOption, force, buildOut)
+	err = cft.backend.BuildImage(ctx, buildContext, buildOption, attachables, force, hash, buildOut)
 	if err != nil {
 		return fmt.Errorf("confort: %w", err)
 	}
@@ -444,41 +565,90 @@ type ContainerParams struct {
 }
 
 func (cft *Confort) createContainer(ctx context.Context, name, alias string, c *ContainerParams, opts ...RunOption) (string, error) {
-	var modifyContainer func(config *container.Config)
-	var modifyHost func(config *container.HostConfig)
-	var modifyNetworking func(config *network.NetworkingConfig)
+	var modifyContainer []func(config *container.Config)
+	var modifyHost []func(config *container.HostConfig)
+	var modifyNetworking []func(config *network.NetworkingConfig)
 	var checkConsistency bool
+	var consistencyModes map[string]ConsistencyMode
+	var keepExited bool
+	var withoutAutoRemove bool
+	stopped := StoppedContainerError
+	var pullPolicy PullPolicy
 	var pullOpts *types.ImagePullOptions
 	pullOut := io.Discard
+	var cover bool
+	var withoutHostPorts bool
 
 	for _, opt := range opts {
 		switch opt.Ident() {
 		case identOptionContainerConfig{}:
-			modifyContainer = opt.Value().(func(config *container.Config))
+			modifyContainer = append(modifyContainer, opt.Value().(func(config *container.Config)))
 		case identOptionHostConfig{}:
-			modifyHost = opt.Value().(func(config *container.HostConfig))
+			modifyHost = append(modifyHost, opt.Value().(func(config *container.HostConfig)))
 		case identOptionNetworkingConfig{}:
-			modifyNetworking = opt.Value().(func(config *network.NetworkingConfig))
+			modifyNetworking = append(modifyNetworking, opt.Value().(func(config *network.NetworkingConfig)))
 		case identOptionConfigConsistency{}:
 			checkConsistency = opt.Value().(bool)
+		case identOptionConsistencyMode{}:
+			m := opt.Value().(consistencyMode)
+			if consistencyModes == nil {
+				consistencyModes = map[string]ConsistencyMode{}
+			}
+			consistencyModes[m.path] = m.mode
+		case identOptionKeepExited{}:
+			keepExited = opt.Value().(bool)
+		case identOptionWithoutAutoRemove{}:
+			withoutAutoRemove = opt.Value().(bool)
+		case identOptionStoppedContainerPolicy{}:
+			stopped = opt.Value().(StoppedContainerPolicy)
 		case identOptionPullOption{}:
 			o := opt.Value().(pullOptions)
 			pullOpts = o.pullOption
 			if o.pullOut != nil {
 				pullOut = o.pullOut
 			}
+		case identOptionPullPolicy{}:
+			pullPolicy = opt.Value().(PullPolicy)
+		case identOptionCoverage{}:
+			cover = opt.Value().(bool)
+		case identOptionWithoutHostPorts{}:
+			withoutHostPorts = opt.Value().(bool)
+		}
+	}
+
+	switch stopped {
+	case StoppedContainerError, StoppedContainerResume, StoppedContainerRecreate:
+	default:
+		return "", fmt.Errorf("invalid stopped container policy: %q", stopped)
+	}
+	if err := validateConsistencyModes(consistencyModes); err != nil {
+		return "", err
+	}
+	switch pullPolicy {
+	case "":
+		// for compatibility, WithPullOptions without WithPullPolicy always pulls the image
+		pullPolicy = PullNever
+		if pullOpts != nil {
+			pullPolicy = PullAlways
 		}
+	case PullAlways, PullIfMissing, PullNever:
+	default:
+		return "", fmt.Errorf("invalid pull policy: %q", pullPolicy)
 	}
 
 	portSet, portBindings, err := nat.ParsePortSpecs(c.ExposedPorts)
 	if err != nil {
 		return "", err
 	}
+	if withoutHostPorts {
+		portBindings = nil
+	}
 
 	env := make([]string, 0, len(c.Env))
 	for envKey, envVar := range c.Env {
 		env = append(env, envKey+"="+envVar)
 	}
+	sort.Strings(env) // keep the fingerprint of the configurations stable
 
 	cc := &container.Config{
 		Image:        c.Image,
@@ -489,16 +659,31 @@ func (cft *Confort) createContainer(ctx context.Context, name, alias string, c *
 		WorkingDir:   c.WorkingDir,
 		StopTimeout:  c.StopTimeout,
 	}
-	if modifyContainer != nil {
-		modifyContainer(cc)
+	for _, modify := range modifyContainer {
+		modify(cc)
 	}
 	hc := &container.HostConfig{
 		PortBindings: portBindings,
 		AutoRemove:   true,
 		Mounts:       c.Mounts,
 	}
-	if modifyHost != nil {
-		modifyHost(hc)
+	for _, modify := range modifyHost {
+		modify(hc)
+	}
+	if withoutAutoRemove {
+		hc.AutoRemove = false
+	}
+	if keepExited {
+		hc.AutoRemove = false
+		labels := make(map[string]string, len(cc.Labels)+1)
+		for k, v := range cc.Labels {
+			labels[k] = v
+		}
+		labels[labelOneShot] = "true"
+		cc.Labels = labels
+	}
+	if cover && cft.coverDir != "" {
+		applyCoverage(cc, hc, coverage.RawDir(cft.coverDir))
 	}
 	nw := cft.namespace.Network()
 	nc := &network.NetworkingConfig{
@@ -509,11 +694,11 @@ func (cft *Confort) createContainer(ctx context.Context, name, alias string, c *
 			},
 		},
 	}
-	if modifyNetworking != nil {
-		modifyNetworking(nc)
+	for _, modify := range modifyNetworking {
+		modify(nc)
 	}
 
-	return cft.namespace.CreateContainer(ctx, name, cc, hc, nc, checkConsistency, c.Waiter, pullOpts, pullOut)
+	return cft.namespace.CreateContainer(ctx, name, cc, hc, nc, checkConsistency, consistencyModes, stopped, c.Waiter, pullPolicy, pullOpts, pullOut)
 }
 
 type (
@@ -521,12 +706,20 @@ type (
 		option.Interface
 		run() RunOption
 	}
-	identOptionContainerConfig   struct{}
-	identOptionHostConfig        struct{}
-	identOptionNetworkingConfig  struct{}
-	identOptionConfigConsistency struct{}
-	identOptionPullOption        struct{}
-	pullOptions                  struct {
+	identOptionContainerConfig        struct{}
+	identOptionHostConfig             struct{}
+	identOptionNetworkingConfig       struct{}
+	identOptionConfigConsistency      struct{}
+	identOptionConsistencyMode        struct{}
+	identOptionPullOption             struct{}
+	identOptionKeepExited             struct{}
+	identOptionStoppedContainerPolicy struct{}
+	identOptionPullPolicy             struct{}
+	consistencyMode                   struct {
+		path string
+		mode ConsistencyMode
+	}
+	pullOptions struct {
 		pullOption *types.ImagePullOptions
 		pullOut    io.Writer
 	}
@@ -538,6 +731,7 @@ func (o runOption) run() RunOption { return o }
 // WithContainerConfig modifies the configuration of container.
 // The argument `config` already contains required values to create container,
 // apply your values with care.
+// When specified multiple times, the modifications are applied in order.
 func WithContainerConfig(f func(config *container.Config)) RunOption {
 	return runOption{
 		Interface: option.New(identOptionContainerConfig{}, f),
@@ -547,6 +741,7 @@ func WithContainerConfig(f func(config *container.Config)) RunOption {
 // WithHostConfig modifies the configuration of container from host side.
 // The argument `config` already contains required values to create container,
 // apply your values with care.
+// When specified multiple times, the modifications are applied in order.
 func WithHostConfig(f func(config *container.HostConfig)) RunOption {
 	return runOption{
 		Interface: option.New(identOptionHostConfig{}, f),
@@ -556,6 +751,8 @@ func WithHostConfig(f func(config *container.HostConfig)) RunOption {
 // WithNetworkingConfig modifies the configuration of network.
 // The argument `config` already contains required values to connecting to bridge network,
 // and a container cannot join multi-networks on container creation.
+// To connect the container to additional networks, use WithNetwork.
+// When specified multiple times, the modifications are applied in order.
 func WithNetworkingConfig(f func(config *network.NetworkingConfig)) RunOption {
 	return runOption{
 		Interface: option.New(identOptionNetworkingConfig{}, f),
@@ -564,18 +761,32 @@ func WithNetworkingConfig(f func(config *network.NetworkingConfig)) RunOption {
 
 // WithConfigConsistency enables/disables the test checking consistency of configurations.
 // By default, this test is disabled.
-// NOTICE: This is quite experimental feature.
+// If the configurations of the existing container are inconsistent with the specified
+// ones, Run returns *ConfigMismatchError which reports the mismatched fields.
 func WithConfigConsistency(check bool) RunOption {
 	return runOption{
 		Interface: option.New(identOptionConfigConsistency{}, check),
 	}.run()
 }
 
+// WithConsistencyMode sets the strictness of the consistency check of the field
+// specified by path, such as "Config.Env", "HostConfig.Mounts" and "EndpointSettings.Aliases".
+// The check itself is enabled by WithConfigConsistency.
+func WithConsistencyMode(path string, mode ConsistencyMode) RunOption {
+	return runOption{
+		Interface: option.New(identOptionConsistencyMode{}, consistencyMode{
+			path: path,
+			mode: mode,
+		}),
+	}.run()
+}
+
 // WithPullOptions enables to pull image that not exists.
 // For example, if you want to use an image hosted in private repository,
 // you have to fill RegistryAuth field.
 //
 // The output will be written to `out`. If nil, io.Discard will be used.
+// To control when to pull the image, use WithPullPolicy.
 func WithPullOptions(opts *types.ImagePullOptions, out io.Writer) RunOption {
 	return runOption{
 		Interface: option.New(identOptionPullOption{}, pullOptions{
@@ -585,17 +796,55 @@ func WithPullOptions(opts *types.ImagePullOptions, out io.Writer) RunOption {
 	}.run()
 }
 
+// WithStoppedContainerPolicy specifies how to handle the existing container which is
+// paused or exited, e.g. after the crash of the test or pausing the container manually.
+// By default, StoppedContainerError is used and Run fails.
+func WithStoppedContainerPolicy(p StoppedContainerPolicy) RunOption {
+	return runOption{
+		Interface: option.New(identOptionStoppedContainerPolicy{}, p),
+	}.run()
+}
+
+// WithPullPolicy specifies when to pull the image of the container. The image is
+// pulled only when the container is created. Parallel pulls of the same image are
+// deduplicated, and other tests (including ones in other packages, with beacon
+// server) wait for the completion of the pull.
+//
+// The options and the output of the pull are configured by WithPullOptions.
+// By default, the image is not pulled, or pulled always if WithPullOptions is specified.
+func WithPullPolicy(p PullPolicy) RunOption {
+	return runOption{
+		Interface: option.New(identOptionPullPolicy{}, p),
+	}.run()
+}
+
+// oneShot keeps the container after exit, to check its exit status.
+// The container is labeled as one-shot, and it is not started again once it has
+// exited with status 0.
+func oneShot() RunOption {
+	return runOption{
+		Interface: option.New(identOptionKeepExited{}, true),
+	}.run()
+}
+
 // Container represents a created container and its controller.
 type Container struct {
-	cft   *Confort
-	id    string
-	name  string
-	alias string
-	ports Ports
+	cft     *Confort
+	id      string
+	name    string
+	alias   string
+	ports   Ports
+	logDump *string
 }
 
-// ID returns its container id.
-func (c *Container) ID() string { return c.id }
+// ID returns its container id. The id can be changed by Container.Restore.
+func (c *Container) ID() string {
+	id, err := c.cft.namespace.ContainerID(c.name)
+	if err != nil {
+		return c.id
+	}
+	return id
+}
 
 // Name returns an actual name of the container.
 func (c *Container) Name() string { return c.name }
@@ -636,20 +885,39 @@ func (cft *Confort) Run(ctx context.Context, c *ContainerParams, opts ...RunOpti
 		return nil, fmt.Errorf("confort: %w", err)
 	}
 
+	for _, n := range networks(opts) {
+		logging.Debugf("connect container to network %q: %s", n.name, name)
+		err = cft.namespace.AttachNetwork(ctx, name, n.name, append([]string{alias}, n.aliases...))
+		if err != nil {
+			return nil, fmt.Errorf("confort: %w", err)
+		}
+	}
+
 	logging.Debugf("start container if not started: %s", name)
 	ports, err := cft.namespace.StartContainer(ctx, name)
 	if err != nil {
 		return nil, fmt.Errorf("confort: %w", err)
 	}
 	return &Container{
-		cft:   cft,
-		id:    containerID,
-		name:  name,
-		alias: alias,
-		ports: ports,
+		cft:     cft,
+		id:      containerID,
+		name:    name,
+		alias:   alias,
+		ports:   ports,
+		logDump: logDumpDir(opts),
 	}, nil
 }
 
+// RunT is similar to Run, but makes the test fail if it fails to start the container.
+func (cft *Confort) RunT(t testing.TB, ctx context.Context, c *ContainerParams, opts ...RunOption) *Container {
+	t.Helper()
+	container, err := cft.Run(ctx, c, opts...)
+	if err != nil {
+		t.Fatalf("failed to run container %q(image: %s): %s", c.Name, c.Image, err)
+	}
+	return container
+}
+
 type (
 	UseOption interface {
 		option.Interface
@@ -680,24 +948,47 @@ func WithInitFunc(init InitFunc) UseOption {
 	}.use()
 }
 
-// Use acquires a lock for using the container and returns its endpoint. If exclusive is true, it requires to
+// Use acquires a lock for using the container and returns its endpoints. If exclusive is true, it requires to
 // use the container exclusively.
 // When other tests have already acquired an exclusive or shared lock for the container, it blocks until all
 // previous locks are released.
-func (c *Container) Use(ctx context.Context, exclusive bool, opts ...UseOption) (Ports, ReleaseFunc, error) {
-	var initFunc InitFunc
+func (c *Container) Use(ctx context.Context, exclusive bool, opts ...UseOption) (Endpoints, ReleaseFunc, error) {
+	return c.use(ctx, "", exclusive, logReleaseError, opts...)
+}
+
+// logReleaseError reports the error occurred in ReleaseFunc.
+func logReleaseError(err error) {
+	log.Println(err)
+}
+
+func (c *Container) use(ctx context.Context, test string, exclusive bool, reportErr func(error), opts ...UseOption) (Endpoints, ReleaseFunc, error) {
+	var (
+		initFunc InitFunc
+		snapshot string
+	)
 	for _, opt := range opts {
 		switch opt.Ident() {
 		case identOptionInitFunc{}:
 			initFunc = opt.Value().(InitFunc)
+		case identOptionRestoreOnRelease{}:
+			snapshot = opt.Value().(string)
 		}
 	}
+	if snapshot != "" && !exclusive {
+		return Endpoints{}, nil, errors.New("confort: WithRestoreOnRelease requires exclusive lock")
+	}
 
 	var init func(ctx context.Context) error
 	if initFunc != nil {
 		init = func(ctx context.Context) error {
+			if err := c.cft.namespace.SyncContainer(ctx, c.name); err != nil {
+				return err
+			}
+			// InitFunc is called with the exclusive lock
+			removeHolder := c.cft.holders.add(c.name, test, true)
+			defer removeHolder()
 			logging.Debugf("call InitFunc: %s", c.name)
-			return initFunc(ctx, c.ports)
+			return initFunc(ctx, c.latestPorts())
 		}
 	}
 	// If initFunc is not nil, it will be called after acquisition of exclusive lock.
@@ -711,34 +1002,99 @@ func (c *Container) Use(ctx context.Context, exclusive bool, opts ...UseOption)
 		},
 	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("confort: %w", err)
+		return Endpoints{}, nil, fmt.Errorf("confort: %w", err)
+	}
+	// the container may be restarted by other processes
+	err = c.cft.namespace.SyncContainer(ctx, c.name)
+	if err != nil {
+		unlockContainer()
+		return Endpoints{}, nil, fmt.Errorf("confort: %w", err)
 	}
+	ports := c.latestPorts()
+	resetProxies := func() {}
+	if targets, ok := proxyPorts(opts); ok {
+		ports, resetProxies, err = c.proxiedPorts(targets)
+		if err != nil {
+			unlockContainer()
+			return Endpoints{}, nil, err
+		}
+	}
+	removeHolder := c.cft.holders.add(c.name, test, exclusive)
 	release := func() {
+		resetProxies()
+		if exclusive {
+			if err := c.revertFaults(); err != nil {
+				reportErr(err)
+			}
+		}
+		if snapshot != "" {
+			if err := c.restoreOnRelease(snapshot); err != nil {
+				reportErr(err)
+			}
+		}
 		logging.Debugf("release LockForContainerUse: %s(exclusive=%t)", c.name, exclusive)
+		removeHolder()
 		unlockContainer()
 	}
 
-	return c.ports, release, nil
+	return c.endpoints(ports), release, nil
+}
+
+func (c *Container) useT(t testing.TB, ctx context.Context, exclusive bool, opts ...UseOption) Endpoints {
+	t.Helper()
+	ports, release, err := c.use(ctx, t.Name(), exclusive, func(err error) {
+		t.Error(err)
+	}, opts...)
+	if err != nil {
+		mode := "shared"
+		if exclusive {
+			mode = "exclusive"
+		}
+		t.Fatalf("failed to acquire %s lock of container %q: %s (%s)", mode, c.alias, err, c.cft.holders.describe(c.name))
+	}
+	t.Cleanup(release)
+	c.registerLogDump(t, logDumpDir(opts))
+	return ports
 }
 
 // UseExclusive acquires an exclusive lock for using the container explicitly and returns its endpoint.
-func (c *Container) UseExclusive(ctx context.Context, opts ...UseOption) (Ports, ReleaseFunc, error) {
+func (c *Container) UseExclusive(ctx context.Context, opts ...UseOption) (Endpoints, ReleaseFunc, error) {
 	return c.Use(ctx, true, opts...)
 }
 
 // UseShared acquires a shared lock for using the container explicitly and returns its endpoint.
-func (c *Container) UseShared(ctx context.Context, opts ...UseOption) (Ports, ReleaseFunc, error) {
+func (c *Container) UseShared(ctx context.Context, opts ...UseOption) (Endpoints, ReleaseFunc, error) {
 	return c.Use(ctx, false, opts...)
 }
 
+// UseExclusiveT is similar to UseExclusive, but the lock is released automatically
+// at the end of the test. If the acquisition fails, the test fails with the current
+// holders of the lock.
+func (c *Container) UseExclusiveT(t testing.TB, ctx context.Context, opts ...UseOption) Endpoints {
+	t.Helper()
+	return c.useT(t, ctx, true, opts...)
+}
+
+// UseSharedT is similar to UseShared, but the lock is released automatically
+// at the end of the test. If the acquisition fails, the test fails with the current
+// holders of the lock.
+func (c *Container) UseSharedT(t testing.TB, ctx context.Context, opts ...UseOption) Endpoints {
+	t.Helper()
+	return c.useT(t, ctx, false, opts...)
+}
+
 // Network returns docker network representation associated with Confort.
 func (cft *Confort) Network() *types.NetworkResource {
 	return cft.namespace.Network()
 }
 
 type Acquirer struct {
-	targets []*Container
-	params  map[string]exclusion.ContainerUseParam
+	targets   []*Container
+	params    map[string]exclusion.ContainerUseParam
+	logDumps  map[*Container]*string
+	snapshots map[*Container]string
+	proxies   map[*Container][]nat.Port
+	err       error
 }
 
 // Acquire initiates the acquisition of locks of the multi-containers.
@@ -762,25 +1118,45 @@ type Acquirer struct {
 //	* Returned func releases all acquired locks
 func Acquire() *Acquirer {
 	return &Acquirer{
-		params: map[string]exclusion.ContainerUseParam{},
+		params:    map[string]exclusion.ContainerUseParam{},
+		logDumps:  map[*Container]*string{},
+		snapshots: map[*Container]string{},
+		proxies:   map[*Container][]nat.Port{},
 	}
 }
 
 // Use registers a container as the target of acquiring lock.
 func (a *Acquirer) Use(c *Container, exclusive bool, opts ...UseOption) *Acquirer {
-	var initFunc InitFunc
+	var (
+		initFunc InitFunc
+		snapshot string
+	)
 	for _, opt := range opts {
 		switch opt.Ident() {
 		case identOptionInitFunc{}:
 			initFunc = opt.Value().(InitFunc)
+		case identOptionRestoreOnRelease{}:
+			snapshot = opt.Value().(string)
+		}
+	}
+	if snapshot != "" {
+		if !exclusive {
+			a.err = multierr.Append(a.err, fmt.Errorf("confort: WithRestoreOnRelease requires exclusive lock: %s", c.alias))
 		}
+		a.snapshots[c] = snapshot
 	}
 
 	var init func(ctx context.Context) error
 	if initFunc != nil {
 		init = func(ctx context.Context) error {
+			if err := c.cft.namespace.SyncContainer(ctx, c.name); err != nil {
+				return err
+			}
+			// InitFunc is called with the exclusive lock
+			removeHolder := c.cft.holders.add(c.name, "", true)
+			defer removeHolder()
 			logging.Debugf("call InitFunc: %s", c.name)
-			return initFunc(ctx, c.ports)
+			return initFunc(ctx, c.latestPorts())
 		}
 	}
 
@@ -790,6 +1166,10 @@ func (a *Acquirer) Use(c *Container, exclusive bool, opts ...UseOption) *Acquire
 		Exclusive: exclusive,
 		Init:      init,
 	}
+	a.logDumps[c] = logDumpDir(opts)
+	if targets, ok := proxyPorts(opts); ok {
+		a.proxies[c] = targets
+	}
 	return a
 }
 
@@ -804,9 +1184,15 @@ func (a *Acquirer) UseShared(c *Container, opts ...UseOption) *Acquirer {
 }
 
 // Do acquisition of locks.
-func (a *Acquirer) Do(ctx context.Context) (map[*Container]Ports, ReleaseFunc, error) {
+func (a *Acquirer) Do(ctx context.Context) (map[*Container]Endpoints, ReleaseFunc, error) {
+	return a.do(ctx, "", logReleaseError)
+}
+
+func (a *Acquirer) do(ctx context.Context, test string, reportErr func(error)) (map[*Container]Endpoints, ReleaseFunc, error) {
 	if len(a.targets) == 0 {
 		return nil, nil, errors.New("no targets")
+	} else if a.err != nil {
+		return nil, nil, a.err
 	}
 	ex := a.targets[0].cft.ex
 
@@ -816,11 +1202,74 @@ func (a *Acquirer) Do(ctx context.Context) (map[*Container]Ports, ReleaseFunc, e
 		return nil, nil, err
 	}
 
-	ports := map[*Container]Ports{}
+	endpoints := map[*Container]Endpoints{}
+	resetProxies := make([]func(), 0, len(a.targets))
 	for _, c := range a.targets {
-		ports[c] = c.ports
+		// the container may be restarted by other processes
+		err = c.cft.namespace.SyncContainer(ctx, c.name)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		ports := c.latestPorts()
+		if targets, ok := a.proxies[c]; ok {
+			proxied, reset, err := c.proxiedPorts(targets)
+			if err != nil {
+				release()
+				return nil, nil, err
+			}
+			ports = proxied
+			resetProxies = append(resetProxies, reset)
+		}
+		endpoints[c] = c.endpoints(ports)
+	}
+	removeHolders := make([]func(), 0, len(a.targets))
+	for _, c := range a.targets {
+		removeHolders = append(removeHolders, c.cft.holders.add(c.name, test, a.params[c.name].Exclusive))
 	}
 
-	logging.Debugf("release LockForContainerUse: %p", a)
-	return ports, release, nil
+	return endpoints, func() {
+		for _, reset := range resetProxies {
+			reset()
+		}
+		for _, c := range a.targets {
+			if a.params[c.name].Exclusive {
+				if err := c.revertFaults(); err != nil {
+					reportErr(err)
+				}
+			}
+			if snapshot, ok := a.snapshots[c]; ok {
+				if err := c.restoreOnRelease(snapshot); err != nil {
+					reportErr(err)
+				}
+			}
+		}
+		logging.Debugf("release LockForContainerUse: %p", a)
+		for _, remove := range removeHolders {
+			remove()
+		}
+		release()
+	}, nil
+}
+
+// DoT is similar to Do, but the locks are released automatically at the end of
+// the test. If the acquisition fails, the test fails with the current holders of
+// the locks.
+func (a *Acquirer) DoT(t testing.TB, ctx context.Context) map[*Container]Endpoints {
+	t.Helper()
+	ports, release, err := a.do(ctx, t.Name(), func(err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		holders := make([]string, 0, len(a.targets))
+		for _, c := range a.targets {
+			holders = append(holders, fmt.Sprintf("%q: %s", c.alias, c.cft.holders.describe(c.name)))
+		}
+		t.Fatalf("failed to acquire locks: %s (%s)", err, strings.Join(holders, ", "))
+	}
+	t.Cleanup(release)
+	for _, c := range a.targets {
+		c.registerLogDump(t, a.logDumps[c])
+	}
+	return ports
 }
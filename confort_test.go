@@ -12,22 +12,27 @@ import (
 	"net/http/httputil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/daichitakahashi/confort"
 	"github.com/daichitakahashi/confort/internal/beacon"
 	"github.com/daichitakahashi/confort/internal/beacon/server"
+	"github.com/daichitakahashi/confort/proxy"
 	"github.com/daichitakahashi/confort/unique"
 	"github.com/daichitakahashi/confort/wait"
 	"github.com/daichitakahashi/testingc"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
 	"golang.org/x/sync/errgroup"
@@ -981,6 +986,133 @@ func TestWithResourcePolicy_reusable(t *testing.T) {
 	}
 }
 
+func TestWithResourcePolicy_recreate(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	namespace := uuid.NewString()
+	run := func(t *testing.T, policy confort.ResourcePolicy, env map[string]string) (string, string) {
+		t.Helper()
+
+		cft, err := confort.New(ctx,
+			confort.WithNamespace(namespace, true),
+			confort.WithResourcePolicy(policy),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = cft.Close()
+		}()
+		echo, err := cft.Run(ctx, &confort.ContainerParams{
+			Name:  "echo",
+			Image: imageEcho,
+			Env:   env,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return echo.ID(), cft.Network().ID
+	}
+
+	// create the container remaining after Close
+	containerID, networkID := run(t, confort.ResourcePolicyReusable, map[string]string{"VAR": "1"})
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		err := cli.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{
+			Force: true,
+		})
+		if err != nil && !errdefs.IsNotFound(err) {
+			t.Log(err)
+		}
+		err = cli.NetworkRemove(ctx, networkID)
+		if err != nil && !errdefs.IsNotFound(err) {
+			t.Log(err)
+		}
+	})
+
+	// same configurations
+	reusedID, _ := run(t, confort.ResourcePolicyRecreate, map[string]string{"VAR": "1"})
+	if reusedID != containerID {
+		t.Fatalf("expected to reuse the container %s, but got %s", containerID, reusedID)
+	}
+
+	// changed configurations
+	recreatedID, _ := run(t, confort.ResourcePolicyRecreate, map[string]string{"VAR": "2"})
+	if recreatedID == containerID {
+		t.Fatal("expected to recreate the container, but reused")
+	}
+	_, err = cli.ContainerInspect(ctx, containerID)
+	if !errdefs.IsNotFound(err) {
+		t.Fatalf("expected that the old container is removed: %v", err)
+	}
+}
+
+func TestWithResourcePolicy_recreate_inUse(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uuid.NewString(), true),
+		confort.WithResourcePolicy(confort.ResourcePolicyRecreate),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+	run := func(value string) (*confort.Container, error) {
+		return cft.Run(ctx, &confort.ContainerParams{
+			Name:  "echo",
+			Image: imageEcho,
+			Env: map[string]string{
+				"VAR": value,
+			},
+		})
+	}
+	var initCount int
+	use := func(t *testing.T, echo *confort.Container) confort.ReleaseFunc {
+		t.Helper()
+		_, release, err := echo.UseShared(ctx, confort.WithInitFunc(func(ctx context.Context, ports confort.Ports) error {
+			initCount++
+			return nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return release
+	}
+
+	echo, err := run("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := use(t, echo)
+
+	// the container in use is not recreated
+	_, err = run("2")
+	if err == nil {
+		t.Fatal("expected to fail to recreate the container in use")
+	}
+	release()
+
+	recreated, err := run("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recreated.ID() == echo.ID() {
+		t.Fatal("expected to recreate the container, but reused")
+	}
+	use(t, recreated)()
+	if initCount != 2 {
+		t.Fatalf("expected InitFunc to be called again on the recreated container: %d", initCount)
+	}
+}
+
 func TestWithResourcePolicy_invalid(t *testing.T) {
 
 	t.Run("invalid policy from env", func(t *testing.T) {
@@ -1258,7 +1390,7 @@ func TestWithForceBuild_WithBuildOutput(t *testing.T) {
 	}
 }
 
-func TestWithContainerConfig(t *testing.T) {
+func TestWithBuildHash(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
 
@@ -1274,40 +1406,71 @@ func TestWithContainerConfig(t *testing.T) {
 
 	cli := cft.APIClient()
 
-	var (
-		label      = "daichitakahashi.confort.test"
-		labelValue = t.Name()
-	)
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		t.Helper()
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("Dockerfile", "FROM alpine:3.16.2\nCOPY file /file\nENTRYPOINT [\"cat\", \"/file\"]\n")
+	writeFile("file", "first")
 
-	_, err = cft.Run(ctx, &confort.ContainerParams{
-		Name:  "echo",
-		Image: imageEcho,
-	}, confort.WithContainerConfig(func(config *container.Config) {
-		if config.Labels == nil {
-			config.Labels = map[string]string{}
+	build := &confort.BuildParams{
+		Image:      imageLs + "hash",
+		Dockerfile: filepath.Join(dir, "Dockerfile"),
+		ContextDir: dir,
+	}
+	buf := bytes.NewBuffer(nil)
+	doBuild := func(t *testing.T) (built bool) {
+		t.Helper()
+		buf.Reset()
+		err := cft.Build(ctx, build,
+			confort.WithBuildHash(),
+			confort.WithBuildOutput(buf),
+		)
+		if err != nil {
+			t.Fatal(err)
 		}
-		config.Labels[label] = labelValue
-	}))
-	if err != nil {
-		t.Fatal(err)
+		return buf.Len() > 0
 	}
 
-	// check the labeled container exists
-	list, err := cli.ContainerList(ctx, types.ContainerListOptions{
-		All: true,
-		Filters: filters.NewArgs(
-			filters.Arg("label", fmt.Sprintf("%s=%s", label, labelValue)),
-		),
+	// build once
+	if !doBuild(t) {
+		t.Fatal("expected image to be built")
+	}
+	t.Cleanup(func() {
+		removeImageIfExists(t, cli, build.Image)
 	})
-	if err != nil {
-		t.Fatal(err)
+
+	// skip build because nothing is changed
+	if doBuild(t) {
+		t.Error("expected build to be skipped, but build log is written")
+		t.Log(buf.String())
 	}
-	if len(list) == 0 {
-		t.Fatalf(`there is no container labeled "%s=%s"`, label, labelValue)
+
+	// rebuild because the content is changed
+	writeFile("file", "second")
+	if !doBuild(t) {
+		t.Fatal("expected image to be rebuilt")
+	}
+	if doBuild(t) {
+		t.Error("expected build to be skipped, but build log is written")
+		t.Log(buf.String())
+	}
+
+	// rebuild because the build arg is changed
+	arg := "arg"
+	build.BuildArgs = map[string]*string{
+		"ARG": &arg,
+	}
+	if !doBuild(t) {
+		t.Fatal("expected image to be rebuilt")
 	}
 }
 
-func TestWithHostConfig(t *testing.T) {
+func TestWithBuildKit(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
 
@@ -1321,106 +1484,105 @@ func TestWithHostConfig(t *testing.T) {
 		_ = cft.Close()
 	})
 
-	communicator, err := cft.Run(ctx, &confort.ContainerParams{
-		Name:  "communicator",
-		Image: imageCommunicator,
-		Env: map[string]string{
-			"CM_TARGET": "reflect",
-		},
-		ExposedPorts: []string{"80/tcp"},
-		Waiter:       wait.Healthy(),
-	}, confort.WithHostConfig(func(config *container.HostConfig) {
-		// configure container to communicate with itself using extra_hosts
-		config.ExtraHosts = append(config.ExtraHosts, "reflect:127.0.0.1")
-	}))
+	cli := cft.APIClient()
+
+	secret := filepath.Join(t.TempDir(), "token")
+	err = os.WriteFile(secret, []byte("confort"), 0600)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ports, release, err := communicator.UseExclusive(ctx)
+	build := &confort.BuildParams{
+		Image:      imageLs + "buildkit",
+		Dockerfile: "testdata/buildkit/Dockerfile",
+		ContextDir: "testdata/buildkit",
+		Target:     "base", // stage "broken" fails
+		Secrets: map[string]string{
+			"token": secret,
+		},
+	}
+	buf := bytes.NewBuffer(nil)
+	err = cft.Build(ctx, build,
+		confort.WithForceBuild(),
+		confort.WithBuildOutput(buf),
+	)
 	if err != nil {
+		t.Log(buf.String())
 		t.Fatal(err)
 	}
-	t.Cleanup(release)
-	host := ports.HostPort("80/tcp")
-	if host == "" {
-		t.Fatal("two: bound port not found")
+	t.Cleanup(func() {
+		removeImageIfExists(t, cli, build.Image)
+	})
+	if !strings.Contains(buf.String(), "DONE") {
+		t.Fatalf("expected progress of BuildKit to be written, but got:\n%s", buf.String())
 	}
 
-	// set status
-	communicate(t, host, "set", "reflecting")
-	// exchange status with container-self
-	communicate(t, host, "exchange", "")
-	// get exchanged status
-	status := communicate(t, host, "get", "")
+	t.Run("secret not provided", func(t *testing.T) {
+		t.Parallel()
 
-	if status != "reflecting" {
-		t.Fatalf(`expected status "reflecting", but got %q`, status)
-	}
+		err := cft.Build(ctx, &confort.BuildParams{
+			Image:      imageLs + "buildkit-no-secret",
+			Dockerfile: "testdata/buildkit/Dockerfile",
+			ContextDir: "testdata/buildkit",
+			Target:     "base",
+		}, confort.WithBuildKit(), confort.WithBuildOutput(io.Discard))
+		if err == nil {
+			removeImageIfExists(t, cli, imageLs+"buildkit-no-secret")
+			t.Fatal("expected error because required secret is not provided")
+		}
+	})
 }
 
-func TestWithNetworkingConfig(t *testing.T) {
+func TestConfort_Build_InMemory(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
 
-	var (
-		name  = "com"
-		alias = "com_alias"
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(t.Name(), true),
 	)
-
-	// create a communicator with two aliases
-	cft1, err := confort.New(ctx, confort.WithNamespace(t.Name(), true))
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Cleanup(func() {
-		_ = cft1.Close()
+		_ = cft.Close()
 	})
-	communicator, err := cft1.Run(ctx, &confort.ContainerParams{
-		Name:  name,
-		Image: imageCommunicator,
-		Env: map[string]string{
-			"CM_TARGET": alias,
+
+	cli := cft.APIClient()
+
+	build := &confort.BuildParams{
+		Image: imageLs + "in-memory",
+		DockerfileContent: `FROM alpine:3.16.2
+COPY . /ctx
+RUN test "$(cat /ctx/file)" = "content" && test ! -e /ctx/ignored
+ENTRYPOINT ["ls", "-la", "/ctx"]
+`,
+		ContextFS: fstest.MapFS{
+			"file":          {Data: []byte("content")},
+			"ignored":       {Data: []byte("ignored")},
+			".dockerignore": {Data: []byte("ignored\n")},
 		},
-		ExposedPorts: []string{"80/tcp"},
-		Waiter:       wait.Healthy(),
-	}, confort.WithNetworkingConfig(func(config *network.NetworkingConfig) {
-		for _, cfg := range config.EndpointsConfig {
-			// add alias
-			cfg.Aliases = append(cfg.Aliases, alias)
-		}
-	}))
-	if err != nil {
-		t.Fatal(err)
 	}
-	ports, release, err := communicator.UseExclusive(ctx)
+	buf := bytes.NewBuffer(nil)
+	err = cft.Build(ctx, build,
+		confort.WithForceBuild(),
+		confort.WithBuildOutput(buf),
+	)
 	if err != nil {
+		t.Log(buf.String())
 		t.Fatal(err)
 	}
-	t.Cleanup(release)
-	host := ports.HostPort("80/tcp")
-	if host == "" {
-		t.Fatalf("%s: bound port not found", name)
-	}
-
-	// set status
-	communicate(t, host, "set", "hello")
-	// exchange status with container-self
-	communicate(t, host, "exchange", "")
-	// get exchanged status
-	status := communicate(t, host, "get", "")
-
-	if status != "hello" {
-		t.Fatalf(`expected status "hello", but got %q`, status)
-	}
+	t.Cleanup(func() {
+		removeImageIfExists(t, cli, build.Image)
+	})
 }
 
-func TestWithConfigConsistency(t *testing.T) {
+func TestConfort_BuildGo(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
 
-	namespace := t.Name()
-	cft, err := confort.New(ctx, confort.WithNamespace(namespace, true))
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(t.Name(), true),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1428,156 +1590,1210 @@ func TestWithConfigConsistency(t *testing.T) {
 		_ = cft.Close()
 	})
 
-	ports := []string{"80/tcp", "8080/tcp"}
-	env := map[string]string{
-		"ENV1": "VALUE",
-		"ENV2": "VALUE",
-	}
-	_, err = cft.Run(ctx, &confort.ContainerParams{
-		Name:         "echo",
-		Image:        imageEcho,
-		ExposedPorts: ports,
-		Env:          env,
-		Waiter:       wait.Healthy(),
-	})
+	cli := cft.APIClient()
+
+	buf := bytes.NewBuffer(nil)
+	image, err := cft.BuildGo(ctx, "./testdata/gomain",
+		confort.WithGoBuildOptions(confort.WithBuildOutput(buf)),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	t.Cleanup(func() {
+		removeImageIfExists(t, cli, image)
+	})
+	if image != "github.com/daichitakahashi/confort/testdata/gomain:confort" {
+		t.Fatalf("unexpected image name: %s", image)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("expected image to be built")
+	}
 
-	testCases := []struct {
-		desc   string
-		ports  []string
-		env    map[string]string
-		failed bool
-	}{
-		{
-			desc:   "less ports",
-			ports:  []string{"80/tcp"},
-			env:    env,
-			failed: false,
-		}, {
-			desc:   "extra ports",
-			ports:  []string{"80/tcp", "8443/tcp"},
-			env:    env,
-			failed: true,
-		}, {
-			desc:  "less env",
-			ports: ports,
-			env: map[string]string{
-				"ENV1": "VALUE",
-			},
-			failed: false,
-		}, {
-			desc:  "extra env",
-			ports: ports,
-			env: map[string]string{
-				"ENV1":     "VALUE",
-				"ENV2":     "VALUE",
-				"MORE_ENV": "VALUE",
-			},
-			failed: true,
+	// skip build because the binary is not changed
+	buf.Reset()
+	_, err = cft.BuildGo(ctx, "./testdata/gomain",
+		confort.WithGoBuildOptions(confort.WithBuildOutput(buf)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 0 {
+		t.Error("expected build to be skipped, but build log is written")
+		t.Log(buf.String())
+	}
+
+	c, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:  "gomain",
+		Image: image,
+		Env: map[string]string{
+			"GOMAIN_MESSAGE": "built by BuildGo",
 		},
+		ExposedPorts: []string{"80/tcp"},
+		Waiter:       wait.LogContains("gomain is ready", 1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports := c.UseSharedT(t, ctx)
+	msg := communicate(t, ports.HostPort("80/tcp"), "", "")
+	if msg != "built by BuildGo" {
+		t.Fatalf("unexpected response: %q", msg)
 	}
 
-	for _, tc := range testCases {
-		t.Run(tc.desc, func(t *testing.T) {
-			cft, err := confort.New(ctx, confort.WithNamespace(namespace, true))
-			if err != nil {
-				t.Fatal(err)
-			}
-			t.Cleanup(func() {
-				_ = cft.Close()
-			})
+	t.Run("not main package", func(t *testing.T) {
+		t.Parallel()
 
-			_, err = cft.Run(ctx, &confort.ContainerParams{
-				Name:         "echo",
-				Image:        imageEcho,
-				ExposedPorts: tc.ports,
-				Env:          tc.env,
-				Waiter:       wait.Healthy(),
-			}, confort.WithConfigConsistency(true))
-			if tc.failed && err == nil {
-				t.Fatal("expected fail because of inconsistency, but not failed")
-			} else if !tc.failed && err != nil {
-				t.Fatalf("expected not to fail, but failed: %s", err)
-			}
-		})
-	}
+		_, err := cft.BuildGo(ctx, ".")
+		if err == nil {
+			t.Fatal("error expected")
+		}
+	})
 }
 
-func TestWithPullOptions(t *testing.T) {
+func TestWithCoverage(t *testing.T) {
 	t.Parallel()
-
-	const pullImage = "ghcr.io/daichitakahashi/confort/testdata/echo:test"
-
 	ctx := context.Background()
-	namespace := uniqueName.Must(t)
-	containerName := uniqueName.Must(t)
-	var terminated bool
 
+	coverDir := t.TempDir()
 	cft, err := confort.New(ctx,
-		confort.WithNamespace(namespace, true),
+		confort.WithNamespace(t.Name(), true),
+		confort.WithCoverDir(coverDir),
 	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	closed := false
 	t.Cleanup(func() {
-		if !terminated {
+		if !closed {
 			_ = cft.Close()
 		}
 	})
 
-	cli := cft.APIClient()
-
-	// remove target image if already exists
-	_ = removeImageIfExists(t, cli, pullImage)
+	image, err := cft.BuildGo(ctx, "./testdata/gomain",
+		confort.WithGoImage(imageLs+"cover"),
+		confort.WithGoCoverage(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		removeImageIfExists(t, cft.APIClient(), image)
+	})
 
-	// pull and run
-	out := &bytes.Buffer{}
 	c, err := cft.Run(ctx, &confort.ContainerParams{
-		Name:         containerName,
-		Image:        pullImage,
+		Name:         "gomain",
+		Image:        image,
 		ExposedPorts: []string{"80/tcp"},
-		Waiter:       wait.Healthy(),
-	}, confort.WithPullOptions(&types.ImagePullOptions{}, out))
+		Waiter:       wait.LogContains("gomain is ready", 1),
+	}, confort.WithCoverage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports, release, err := c.UseShared(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
+	communicate(t, ports.HostPort("80/tcp"), "", "")
+	release()
 
-	t.Log(out.String())
-	if out.Len() == 0 {
-		t.Fatal("pull image not performed")
+	// the container is stopped gracefully and the coverage data is merged
+	closed = true
+	if err := cft.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{"covmeta.*", "covcounters.*"} {
+		matches, err := filepath.Glob(filepath.Join(coverDir, pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) == 0 {
+			t.Errorf("%s not found in coverage directory", pattern)
+		}
 	}
+}
 
-	// check if container works
-	ports, release, err := c.UseShared(ctx)
+func TestWithContainerConfig(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(t.Name(), true),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Cleanup(release)
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
 
-	endpoint := ports.HostPort("80/tcp")
-	if endpoint == "" {
-		t.Fatal("endpoint not found")
+	cli := cft.APIClient()
+
+	var (
+		label      = "daichitakahashi.confort.test"
+		labelValue = t.Name()
+	)
+
+	_, err = cft.Run(ctx, &confort.ContainerParams{
+		Name:  "echo",
+		Image: imageEcho,
+	}, confort.WithContainerConfig(func(config *container.Config) {
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+		config.Labels[label] = labelValue
+	}), confort.WithContainerConfig(func(config *container.Config) {
+		// applied after the preceding one
+		config.Labels[label+".second"] = labelValue
+	}))
+	if err != nil {
+		t.Fatal(err)
 	}
-	assertEchoWorks(t, endpoint)
 
-	// remove container
-	_ = cft.Close()
-	terminated = true
+	// check the labeled container exists
+	list, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", fmt.Sprintf("%s=%s", label, labelValue)),
+			filters.Arg("label", fmt.Sprintf("%s.second=%s", label, labelValue)),
+		),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 {
+		t.Fatalf(`there is no container labeled "%s=%s" and "%s.second=%s"`, label, labelValue, label, labelValue)
+	}
+}
 
-	// remove pulled image
-	removed := removeImageIfExists(t, cli, pullImage)
-	if !removed {
-		t.Fatalf("cannot remove pulled image %q", pullImage)
+func TestWithHostConfig(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(t.Name(), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	communicator, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:  "communicator",
+		Image: imageCommunicator,
+		Env: map[string]string{
+			"CM_TARGET": "reflect",
+		},
+		ExposedPorts: []string{"80/tcp"},
+		Waiter:       wait.Healthy(),
+	}, confort.WithHostConfig(func(config *container.HostConfig) {
+		// configure container to communicate with itself using extra_hosts
+		config.ExtraHosts = append(config.ExtraHosts, "reflect:127.0.0.1")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ports, release, err := communicator.UseExclusive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(release)
+	host := ports.HostPort("80/tcp")
+	if host == "" {
+		t.Fatal("two: bound port not found")
+	}
+
+	// set status
+	communicate(t, host, "set", "reflecting")
+	// exchange status with container-self
+	communicate(t, host, "exchange", "")
+	// get exchanged status
+	status := communicate(t, host, "get", "")
+
+	if status != "reflecting" {
+		t.Fatalf(`expected status "reflecting", but got %q`, status)
 	}
 }
 
-func TestWithInitFunc(t *testing.T) {
+func TestWithNetworkingConfig(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	var (
+		name  = "com"
+		alias = "com_alias"
+	)
+
+	// create a communicator with two aliases
+	cft1, err := confort.New(ctx, confort.WithNamespace(t.Name(), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft1.Close()
+	})
+	communicator, err := cft1.Run(ctx, &confort.ContainerParams{
+		Name:  name,
+		Image: imageCommunicator,
+		Env: map[string]string{
+			"CM_TARGET": alias,
+		},
+		ExposedPorts: []string{"80/tcp"},
+		Waiter:       wait.Healthy(),
+	}, confort.WithNetworkingConfig(func(config *network.NetworkingConfig) {
+		for _, cfg := range config.EndpointsConfig {
+			// add alias
+			cfg.Aliases = append(cfg.Aliases, alias)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports, release, err := communicator.UseExclusive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(release)
+	host := ports.HostPort("80/tcp")
+	if host == "" {
+		t.Fatalf("%s: bound port not found", name)
+	}
+
+	// set status
+	communicate(t, host, "set", "hello")
+	// exchange status with container-self
+	communicate(t, host, "exchange", "")
+	// get exchanged status
+	status := communicate(t, host, "get", "")
+
+	if status != "hello" {
+		t.Fatalf(`expected status "hello", but got %q`, status)
+	}
+}
+
+func TestWithConfigConsistency(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	namespace := t.Name()
+	cft, err := confort.New(ctx, confort.WithNamespace(namespace, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	ports := []string{"80/tcp", "8080/tcp"}
+	env := map[string]string{
+		"ENV1": "VALUE",
+		"ENV2": "VALUE",
+	}
+	_, err = cft.Run(ctx, &confort.ContainerParams{
+		Name:         "echo",
+		Image:        imageEcho,
+		ExposedPorts: ports,
+		Env:          env,
+		Waiter:       wait.Healthy(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc      string
+		ports     []string
+		env       map[string]string
+		failed    bool
+		path      string
+		ignoreEnv bool
+	}{
+		{
+			desc:   "less ports",
+			ports:  []string{"80/tcp"},
+			env:    env,
+			failed: false,
+		}, {
+			desc:   "extra ports",
+			ports:  []string{"80/tcp", "8443/tcp"},
+			env:    env,
+			failed: true,
+			path:   "Config.ExposedPorts",
+		}, {
+			desc:  "less env",
+			ports: ports,
+			env: map[string]string{
+				"ENV1": "VALUE",
+			},
+			failed: false,
+		}, {
+			desc:  "extra env",
+			ports: ports,
+			env: map[string]string{
+				"ENV1":     "VALUE",
+				"ENV2":     "VALUE",
+				"MORE_ENV": "VALUE",
+			},
+			failed: true,
+			path:   "Config.Env",
+		}, {
+			desc:  "extra env with ignore",
+			ports: ports,
+			env: map[string]string{
+				"ENV1":     "VALUE",
+				"ENV2":     "VALUE",
+				"MORE_ENV": "VALUE",
+			},
+			ignoreEnv: true,
+			failed:    false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			cft, err := confort.New(ctx, confort.WithNamespace(namespace, true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				_ = cft.Close()
+			})
+
+			opts := []confort.RunOption{
+				confort.WithConfigConsistency(true),
+			}
+			if tc.ignoreEnv {
+				opts = append(opts, confort.WithConsistencyMode("Config.Env", confort.ConsistencyIgnore))
+			}
+			_, err = cft.Run(ctx, &confort.ContainerParams{
+				Name:         "echo",
+				Image:        imageEcho,
+				ExposedPorts: tc.ports,
+				Env:          tc.env,
+				Waiter:       wait.Healthy(),
+			}, opts...)
+			if tc.failed && err == nil {
+				t.Fatal("expected fail because of inconsistency, but not failed")
+			} else if !tc.failed && err != nil {
+				t.Fatalf("expected not to fail, but failed: %s", err)
+			}
+			if !tc.failed {
+				return
+			}
+			var mismatchErr *confort.ConfigMismatchError
+			if !errors.As(err, &mismatchErr) {
+				t.Fatalf("expected *confort.ConfigMismatchError, but got %v", err)
+			}
+			for _, m := range mismatchErr.Mismatches {
+				if m.Path == tc.path {
+					return
+				}
+			}
+			t.Fatalf("mismatch of %s is not reported: %s", tc.path, err)
+		})
+	}
+}
+
+func TestWithoutHostPorts(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	server, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:         "server",
+		Image:        imageEcho,
+		ExposedPorts: []string{"80/tcp"},
+		Waiter:       wait.Healthy(),
+	}, confort.WithoutHostPorts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:   "client",
+		Image:  imageEcho,
+		Waiter: wait.Healthy(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints := server.UseSharedT(t, ctx)
+	if hostPort := endpoints.HostPort("80/tcp"); hostPort != "" {
+		t.Fatalf("expected that the port is not published, but bound to %s", hostPort)
+	}
+	if endpoints.Alias() != server.Alias() {
+		t.Fatalf("unexpected alias: %s", endpoints.Alias())
+	}
+	if endpoints.IPAddress() == "" {
+		t.Fatal("IP address is empty")
+	}
+
+	for _, url := range []string{
+		endpoints.InternalURL("80/tcp", ""),
+		"http://" + endpoints.InternalIPPort("80/tcp"),
+	} {
+		ce, err := client.CreateExec(ctx, []string{"wget", "-q", "--spider", url})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ce.CombinedOutput(ctx)
+		if err != nil {
+			t.Fatalf("failed to access %s: %s: %s", url, err, out)
+		}
+	}
+}
+
+func TestResource(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	echo, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:         "echo",
+		Image:        imageEcho,
+		ExposedPorts: []string{"80/tcp"},
+		Waiter:       wait.Healthy(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type client struct {
+		url    string
+		closed bool
+	}
+	var created int
+	r := confort.NewResource(echo, func(ctx context.Context, ports confort.Ports) (*client, error) {
+		created++
+		return &client{url: ports.URL("80/tcp", "")}, nil
+	}, func(c *client) error {
+		c.closed = true
+		return nil
+	})
+
+	c1, release1, err := r.UseShared(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, release2, err := r.UseShared(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Fatal("expected to share the client")
+	}
+	resp, err := http.Get(c1.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	release1()
+	if c1.closed {
+		t.Fatal("client is closed while used")
+	}
+	release2()
+	if !c1.closed {
+		t.Fatal("client is not closed after the last user released")
+	}
+
+	c3 := r.UseExclusiveT(t, ctx)
+	if c3 == c1 || created != 2 {
+		t.Fatalf("expected to create new client: created=%d", created)
+	}
+}
+
+func TestWithPullPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	t.Run("if-missing", func(t *testing.T) {
+		t.Parallel()
+
+		// imageEcho is built locally and not pushed, so pulling it fails
+		out := &bytes.Buffer{}
+		_, err := cft.Run(ctx, &confort.ContainerParams{
+			Name:  "if-missing",
+			Image: imageEcho,
+		},
+			confort.WithPullPolicy(confort.PullIfMissing),
+			confort.WithPullOptions(&types.ImagePullOptions{}, out),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.Len() > 0 {
+			t.Fatalf("unexpected pull: %s", out)
+		}
+	})
+
+	t.Run("always", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := cft.Run(ctx, &confort.ContainerParams{
+			Name:  "always",
+			Image: imageEcho,
+		}, confort.WithPullPolicy(confort.PullAlways))
+		if err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+
+	t.Run("never", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := cft.Run(ctx, &confort.ContainerParams{
+			Name:  "never",
+			Image: "github.com/daichitakahashi/confort/testdata/not-exist:test",
+		},
+			confort.WithPullPolicy(confort.PullNever),
+			confort.WithPullOptions(&types.ImagePullOptions{}, nil),
+		)
+		if err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := cft.Run(ctx, &confort.ContainerParams{
+			Name:  "invalid",
+			Image: imageEcho,
+		}, confort.WithPullPolicy("sometimes"))
+		if err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+}
+
+func TestWithPullOptions(t *testing.T) {
+	t.Parallel()
+
+	const pullImage = "ghcr.io/daichitakahashi/confort/testdata/echo:test"
+
+	ctx := context.Background()
+	namespace := uniqueName.Must(t)
+	containerName := uniqueName.Must(t)
+	var terminated bool
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(namespace, true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if !terminated {
+			_ = cft.Close()
+		}
+	})
+
+	cli := cft.APIClient()
+
+	// remove target image if already exists
+	_ = removeImageIfExists(t, cli, pullImage)
+
+	// pull and run
+	out := &bytes.Buffer{}
+	c, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:         containerName,
+		Image:        pullImage,
+		ExposedPorts: []string{"80/tcp"},
+		Waiter:       wait.Healthy(),
+	}, confort.WithPullOptions(&types.ImagePullOptions{}, out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log(out.String())
+	if out.Len() == 0 {
+		t.Fatal("pull image not performed")
+	}
+
+	// check if container works
+	ports, release, err := c.UseShared(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(release)
+
+	endpoint := ports.HostPort("80/tcp")
+	if endpoint == "" {
+		t.Fatal("endpoint not found")
+	}
+	assertEchoWorks(t, endpoint)
+
+	// remove container
+	_ = cft.Close()
+	terminated = true
+
+	// remove pulled image
+	removed := removeImageIfExists(t, cli, pullImage)
+	if !removed {
+		t.Fatalf("cannot remove pulled image %q", pullImage)
+	}
+}
+
+func TestWithInitFunc(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx, confort.WithNamespace(t.Name(), true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	echo, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:         "echo",
+		Image:        imageEcho,
+		ExposedPorts: []string{"80/tcp"},
+		Waiter:       wait.Healthy(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var try, done int
+	use := func() error {
+		_, release, err := echo.UseShared(ctx, confort.WithInitFunc(func(ctx context.Context, ports confort.Ports) error {
+			if try++; try < 3 {
+				return errors.New("dummy error")
+			}
+			if len(ports["80/tcp"]) == 0 {
+				return errors.New("port not found")
+			}
+			done++
+			return nil
+		}))
+		if err == nil {
+			t.Cleanup(release)
+		}
+		return err
+	}
+
+	for i := 0; i < 5; i++ {
+		err := use()
+		if i < 2 {
+			if err == nil {
+				t.Fatal("expected error on init")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected failure: %s", err)
+		}
+	}
+	if done != 1 {
+		t.Fatalf("expected call of init: 1, actual: %d", done)
+	}
+}
+
+func removeImageIfExists(t *testing.T, cli *client.Client, image string) (removed bool) {
+	t.Helper()
+	ctx := context.Background()
+
+	images, err := cli.ImageList(ctx, types.ImageListOptions{
+		All: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+find:
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			if tag == image {
+				found = true
+				break find
+			}
+		}
+	}
+	if found {
+		_, err = cli.ImageRemove(ctx, image, types.ImageRemoveOptions{
+			Force: false,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return true
+	}
+	return false
+}
+
+func assertEchoWorks(t *testing.T, endpoint string) {
+	t.Helper()
+
+	resp, err := http.Post("http://"+endpoint, "text/plain", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != "ping" {
+		t.Fatalf("unexpected echo response: %q", response)
+	}
+}
+
+func TestConfort_Run_UnsupportedStatus(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	namespace := uniqueName.Must(t)
+	containerName := namespace + "-" + "foo"
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(namespace, true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	cli := cft.APIClient()
+
+	// start container
+	created, err := cli.ContainerCreate(ctx, &container.Config{
+		Image: imageEcho,
+	}, &container.HostConfig{}, &network.NetworkingConfig{}, nil, containerName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.ContainerStart(ctx, created.ID, types.ContainerStartOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cli.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{
+			RemoveVolumes: true,
+			Force:         true,
+		})
+	})
+
+	tryRun := func() error {
+		_, err := cft.Run(ctx, &confort.ContainerParams{
+			Name:  "foo",
+			Image: imageEcho,
+		})
+		return err
+	}
+
+	// unsupported container status "pause"
+	err = cli.ContainerPause(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tryRun()
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	// unsupported container status "exited"
+	err = cli.ContainerStop(ctx, created.ID, container.StopOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tryRun()
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestConfort_Run_StoppedContainerPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	for _, state := range []string{"paused", "exited"} {
+		state := state
+		for _, policy := range []confort.StoppedContainerPolicy{
+			confort.StoppedContainerResume,
+			confort.StoppedContainerRecreate,
+		} {
+			policy := policy
+			t.Run(fmt.Sprintf("%s/%s", state, policy), func(t *testing.T) {
+				t.Parallel()
+
+				namespace := uniqueName.Must(t)
+				cft, err := confort.New(ctx,
+					confort.WithNamespace(namespace, true),
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() {
+					_ = cft.Close()
+				})
+				cli := cft.APIClient()
+
+				params := &confort.ContainerParams{
+					Name:         "foo",
+					Image:        imageCommunicator,
+					ExposedPorts: []string{"80/tcp"},
+					Waiter:       wait.Healthy(),
+				}
+				c, err := cft.Run(ctx, params, confort.WithHostConfig(func(config *container.HostConfig) {
+					config.AutoRemove = false
+				}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if state == "paused" {
+					err = cli.ContainerPause(ctx, c.ID())
+				} else {
+					err = cli.ContainerStop(ctx, c.ID(), container.StopOptions{})
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// another process tries to use the stopped container
+				cft2, err := confort.New(ctx,
+					confort.WithNamespace(namespace, true),
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() {
+					_ = cft2.Close()
+				})
+
+				_, err = cft2.Run(ctx, params)
+				if err == nil {
+					t.Fatal("error expected without WithStoppedContainerPolicy")
+				}
+				c2, err := cft2.Run(ctx, params, confort.WithStoppedContainerPolicy(policy))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resumed := c2.ID() == c.ID(); resumed != (policy == confort.StoppedContainerResume) {
+					t.Fatalf("unexpected container id: before %s, after %s", c.ID(), c2.ID())
+				}
+				info, err := cli.ContainerInspect(ctx, c2.ID())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !info.State.Running || info.State.Paused {
+					t.Fatalf("container is not running: %s", info.State.Status)
+				}
+
+				ports, release, err := c2.UseShared(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer release()
+				communicate(t, ports.HostPort("80/tcp"), "get", "")
+			})
+		}
+	}
+}
+
+func TestContainer_Lifecycle(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+	cli := cft.APIClient()
+
+	exposed := nat.Port("80/tcp")
+	c, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:         "lifecycle",
+		Image:        imageCommunicator,
+		ExposedPorts: []string{string(exposed)},
+		Waiter:       wait.Healthy(),
+	}, confort.WithoutAutoRemove())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("without exclusive lock", func(t *testing.T) {
+		_, release, err := c.UseShared(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		if err := c.Stop(ctx); err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		ports := c.UseExclusiveT(t, ctx)
+		communicate(t, ports.HostPort(exposed), "set", "before restart")
+
+		restarted, err := c.Restart(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the state on memory is lost
+		if status := communicate(t, restarted.HostPort(exposed), "get", ""); status != "" {
+			t.Fatalf("unexpected status: %q", status)
+		}
+	})
+
+	t.Run("Stop and Start", func(t *testing.T) {
+		_ = c.UseExclusiveT(t, ctx)
+		if err := c.Stop(ctx); err != nil {
+			t.Fatal(err)
+		}
+		info, err := cli.ContainerInspect(ctx, c.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.State.Running {
+			t.Fatal("container is not stopped")
+		}
+		ports, err := c.Start(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		communicate(t, ports.HostPort(exposed), "get", "")
+	})
+
+	t.Run("Kill and Start", func(t *testing.T) {
+		_ = c.UseExclusiveT(t, ctx)
+		if err := c.Kill(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Pause and Unpause", func(t *testing.T) {
+		ports := c.UseExclusiveT(t, ctx)
+		if err := c.Pause(ctx); err != nil {
+			t.Fatal(err)
+		}
+		info, err := cli.ContainerInspect(ctx, c.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.State.Paused {
+			t.Fatal("container is not paused")
+		}
+		if err := c.Unpause(ctx); err != nil {
+			t.Fatal(err)
+		}
+		communicate(t, ports.HostPort(exposed), "get", "")
+	})
+
+	// subsequent user gets the latest endpoint
+	ports := c.UseSharedT(t, ctx)
+	communicate(t, ports.HostPort(exposed), "get", "")
+}
+
+func TestContainer_Lifecycle_AutoRemove(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	namespace := uniqueName.Must(t)
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(namespace, true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	exposed := nat.Port("80/tcp")
+	params := &confort.ContainerParams{
+		Name:         "auto-remove",
+		Image:        imageCommunicator,
+		ExposedPorts: []string{string(exposed)},
+		Waiter:       wait.Healthy(),
+	}
+	c, err := cft.Run(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Stop and Kill", func(t *testing.T) {
+		_ = c.UseExclusiveT(t, ctx)
+		if err := c.Stop(ctx); err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+		if err := c.Kill(ctx); err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+
+	var restarted confort.Ports
+	t.Run("Restart", func(t *testing.T) {
+		_ = c.UseExclusiveT(t, ctx)
+		restarted, err = c.Restart(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		communicate(t, restarted.HostPort(exposed), "get", "")
+	})
+
+	// another process gets the endpoint of the restarted container
+	cft2, err := confort.New(ctx,
+		confort.WithNamespace(namespace, true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft2.Close()
+	})
+	c2, err := cft2.Run(ctx, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports := c2.UseSharedT(t, ctx)
+	if ports.HostPort(exposed) != restarted.HostPort(exposed) {
+		t.Fatalf("stale endpoint: expected %s, actual %s", restarted.HostPort(exposed), ports.HostPort(exposed))
+	}
+}
+
+func TestContainer_NetworkFault(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	exposed := nat.Port("80/tcp")
+	run := func(name, target string) *confort.Container {
+		c, err := cft.Run(ctx, &confort.ContainerParams{
+			Name:  name,
+			Image: imageCommunicator,
+			Env: map[string]string{
+				"CM_TARGET": target,
+			},
+			ExposedPorts: []string{string(exposed)},
+			Waiter:       wait.Healthy(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	one := run("one", "two")
+	two := run("two", "one")
+
+	// exchange returns whether "one" can reach "two", and the duration
+	exchange := func(t *testing.T) (bool, time.Duration) {
+		t.Helper()
+		ports := one.UseSharedT(t, ctx)
+		start := time.Now()
+		resp, err := http.Post("http://"+ports.HostPort(exposed)+"/exchange", "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusOK, time.Since(start)
+	}
+
+	t.Run("DisconnectNetwork", func(t *testing.T) {
+		t.Run("disconnect", func(t *testing.T) {
+			_ = two.UseExclusiveT(t, ctx)
+			if err := two.DisconnectNetwork(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := exchange(t); ok {
+				t.Fatal("disconnected container is reachable")
+			}
+		})
+		// reverted on release
+		if ok, _ := exchange(t); !ok {
+			t.Fatal("container is not reachable after release")
+		}
+	})
+
+	t.Run("SetNetworkCondition", func(t *testing.T) {
+		latency := 500 * time.Millisecond
+		t.Run("latency", func(t *testing.T) {
+			_ = two.UseExclusiveT(t, ctx)
+			err := two.SetNetworkCondition(ctx, confort.NetworkCondition{
+				Latency: latency,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, d := exchange(t); d < latency {
+				t.Fatalf("latency is not applied: %s", d)
+			}
+		})
+		// reverted on release
+		if _, d := exchange(t); d >= latency {
+			t.Fatalf("latency is not reverted: %s", d)
+		}
+	})
+
+	t.Run("without exclusive lock", func(t *testing.T) {
+		if err := two.DisconnectNetwork(ctx); err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+}
+
+func TestContainer_Proxy(t *testing.T) {
 	t.Parallel()
-	ctx := context.Background()
 
-	cft, err := confort.New(ctx, confort.WithNamespace(t.Name(), true))
+	ctx := context.Background()
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1585,165 +2801,223 @@ func TestWithInitFunc(t *testing.T) {
 		_ = cft.Close()
 	})
 
-	echo, err := cft.Run(ctx, &confort.ContainerParams{
-		Name:         "echo",
-		Image:        imageEcho,
-		ExposedPorts: []string{"80/tcp"},
+	exposed := nat.Port("80/tcp")
+	c, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:         "proxied",
+		Image:        imageCommunicator,
+		ExposedPorts: []string{string(exposed)},
 		Waiter:       wait.Healthy(),
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	direct := c.UseSharedT(t, ctx)
 
-	var try, done int
-	use := func() error {
-		_, release, err := echo.UseShared(ctx, confort.WithInitFunc(func(ctx context.Context, ports confort.Ports) error {
-			if try++; try < 3 {
-				return errors.New("dummy error")
-			}
-			if len(ports["80/tcp"]) == 0 {
-				return errors.New("port not found")
-			}
-			done++
-			return nil
-		}))
-		if err == nil {
-			t.Cleanup(release)
-		}
-		return err
+	p, err := c.Proxy(exposed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Proxy("12345/tcp"); err == nil {
+		t.Fatal("error expected for unbound port")
 	}
 
-	for i := 0; i < 5; i++ {
-		err := use()
-		if i < 2 {
-			if err == nil {
-				t.Fatal("expected error on init")
-			}
-			continue
+	latency := 200 * time.Millisecond
+	t.Run("WithProxy", func(t *testing.T) {
+		ports := c.UseSharedT(t, ctx, confort.WithProxy())
+		if ports.HostPort(exposed) != p.Addr() {
+			t.Fatalf("unexpected endpoint: want %s, got %s", p.Addr(), ports.HostPort(exposed))
 		}
-		if err != nil {
-			t.Fatalf("unexpected failure: %s", err)
+		if ports.HostPort(exposed) == direct.HostPort(exposed) {
+			t.Fatal("endpoint is not proxied")
 		}
+		communicate(t, ports.HostPort(exposed), "set", "proxied")
+		if status := communicate(t, direct.HostPort(exposed), "get", ""); status != "proxied" {
+			t.Fatalf("unexpected status: %q", status)
+		}
+
+		p.SetToxics(proxy.Toxics{
+			Latency: latency,
+		})
+		start := time.Now()
+		communicate(t, ports.HostPort(exposed), "get", "")
+		if d := time.Since(start); d < latency {
+			t.Fatalf("latency is not applied: %s", d)
+		}
+	})
+
+	// toxics are reset on release
+	if toxics := p.Toxics(); toxics != (proxy.Toxics{}) {
+		t.Fatalf("toxics are not reset: %+v", toxics)
 	}
-	if done != 1 {
-		t.Fatalf("expected call of init: 1, actual: %d", done)
-	}
+
+	t.Run("Acquirer", func(t *testing.T) {
+		ports := confort.Acquire().
+			UseShared(c, confort.WithProxy(exposed)).
+			DoT(t, ctx)
+		if ports[c].HostPort(exposed) != p.Addr() {
+			t.Fatalf("unexpected endpoint: want %s, got %s", p.Addr(), ports[c].HostPort(exposed))
+		}
+	})
 }
 
-func removeImageIfExists(t *testing.T, cli *client.Client, image string) (removed bool) {
-	t.Helper()
-	ctx := context.Background()
+func TestConfort_CreateNetwork(t *testing.T) {
+	t.Parallel()
 
-	images, err := cli.ImageList(ctx, types.ImageListOptions{
-		All: true,
-	})
+	ctx := context.Background()
+	namespace := uniqueName.Must(t)
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(namespace, true),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
-	var found bool
-find:
-	for _, img := range images {
-		for _, tag := range img.RepoTags {
-			if tag == image {
-				found = true
-				break find
-			}
+	closed := false
+	t.Cleanup(func() {
+		if !closed {
+			_ = cft.Close()
 		}
+	})
+	cli := cft.APIClient()
+
+	nw, err := cft.CreateNetwork(ctx, "backend")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if found {
-		_, err = cli.ImageRemove(ctx, image, types.ImageRemoveOptions{
-			Force: false,
-		})
+	if nw.Name != namespace+"-backend" {
+		t.Fatalf("unexpected network name: %s", nw.Name)
+	}
+	if again, err := cft.CreateNetwork(ctx, "backend"); err != nil {
+		t.Fatal(err)
+	} else if again.ID != nw.ID {
+		t.Fatal("network is created twice")
+	}
+
+	exposed := nat.Port("80/tcp")
+	run := func(name, target string, opts ...confort.RunOption) *confort.Container {
+		c, err := cft.Run(ctx, &confort.ContainerParams{
+			Name:  name,
+			Image: imageCommunicator,
+			Env: map[string]string{
+				"CM_TARGET": target,
+			},
+			ExposedPorts: []string{string(exposed)},
+			Waiter:       wait.Healthy(),
+		}, opts...)
 		if err != nil {
 			t.Fatal(err)
 		}
-		return true
+		return c
 	}
-	return false
-}
+	server := run("server", "", confort.WithNetwork("backend", "db"))
+	client := run("client", "db", confort.WithNetwork("backend"))
+	outsider := run("outsider", "db")
 
-func assertEchoWorks(t *testing.T, endpoint string) {
-	t.Helper()
-
-	resp, err := http.Post("http://"+endpoint, "text/plain", strings.NewReader("ping"))
+	// "db" is resolvable only in the "backend" network
+	ports := confort.Acquire().
+		UseExclusive(server).
+		UseExclusive(client).
+		UseExclusive(outsider).
+		DoT(t, ctx)
+	communicate(t, ports[server].HostPort(exposed), "set", "server")
+	communicate(t, ports[client].HostPort(exposed), "set", "client")
+	if status := communicate(t, ports[client].HostPort(exposed), "exchange", ""); status != "server" {
+		t.Fatalf("unexpected status: %q", status)
+	}
+	resp, err := http.Post("http://"+ports[outsider].HostPort(exposed)+"/exchange", "text/plain", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-	response, err := io.ReadAll(resp.Body)
-	if err != nil {
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("outsider must not reach the alias in the backend network")
+	}
+
+	t.Run("unknown network", func(t *testing.T) {
+		_, err := cft.Run(ctx, &confort.ContainerParams{
+			Name:  "unknown",
+			Image: imageCommunicator,
+		}, confort.WithNetwork("unknown"))
+		if err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+
+	// network is removed by Close
+	closed = true
+	if err := cft.Close(); err != nil {
 		t.Fatal(err)
 	}
-	if string(response) != "ping" {
-		t.Fatalf("unexpected echo response: %q", response)
+	_, err = cli.NetworkInspect(ctx, nw.ID, types.NetworkInspectOptions{})
+	if !errdefs.IsNotFound(err) {
+		t.Fatalf("network is not removed: %v", err)
 	}
 }
 
-func TestConfort_Run_UnsupportedStatus(t *testing.T) {
+func TestConfort_Volume(t *testing.T) {
 	t.Parallel()
 
 	ctx := context.Background()
 	namespace := uniqueName.Must(t)
-	containerName := namespace + "-" + "foo"
-
 	cft, err := confort.New(ctx,
 		confort.WithNamespace(namespace, true),
 	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	closed := false
 	t.Cleanup(func() {
-		_ = cft.Close()
+		if !closed {
+			_ = cft.Close()
+		}
 	})
-
 	cli := cft.APIClient()
 
-	// start container
-	created, err := cli.ContainerCreate(ctx, &container.Config{
-		Image: imageEcho,
-	}, &container.HostConfig{}, &network.NetworkingConfig{}, nil, containerName)
+	m, err := cft.Volume(ctx, "data")
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = cli.ContainerStart(ctx, created.ID, types.ContainerStartOptions{})
+	if m.Type != mount.TypeVolume || m.Source != namespace+"-data" {
+		t.Fatalf("unexpected mount: %+v", m)
+	}
+	v, err := cli.VolumeInspect(ctx, m.Source)
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Cleanup(func() {
-		_ = cli.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{
-			RemoveVolumes: true,
-			Force:         true,
-		})
-	})
-
-	tryRun := func() error {
-		_, err := cft.Run(ctx, &confort.ContainerParams{
-			Name:  "foo",
-			Image: imageEcho,
-		})
-		return err
+	if _, ok := v.Labels[beacon.LabelIdentifier]; !ok {
+		t.Fatalf("volume is not labelled: %v", v.Labels)
 	}
 
-	// unsupported container status "pause"
-	err = cli.ContainerPause(ctx, created.ID)
+	m.Target = "/data"
+	c, err := cft.Run(ctx, &confort.ContainerParams{
+		Name:   "volume",
+		Image:  imageEcho,
+		Mounts: []mount.Mount{m},
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = tryRun()
-	if err == nil {
-		t.Fatal("unexpected success")
+	info, err := cli.ContainerInspect(ctx, c.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mounted bool
+	for _, mp := range info.Mounts {
+		if mp.Name == m.Source && mp.Destination == m.Target {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Fatalf("volume is not mounted: %+v", info.Mounts)
 	}
 
-	// unsupported container status "exited"
-	err = cli.ContainerStop(ctx, created.ID, container.StopOptions{})
-	if err != nil {
+	// volume is removed by Close
+	closed = true
+	if err := cft.Close(); err != nil {
 		t.Fatal(err)
 	}
-	err = tryRun()
-	if err == nil {
-		t.Fatal("unexpected success")
+	_, err = cli.VolumeInspect(ctx, m.Source)
+	if !errdefs.IsNotFound(err) {
+		t.Fatalf("volume is not removed: %v", err)
 	}
 }
 
@@ -1794,7 +3068,7 @@ func TestAcquire(t *testing.T) {
 			return nil
 		}
 	}
-	test := func(ports map[*confort.Container]confort.Ports) error {
+	test := func(ports map[*confort.Container]confort.Endpoints) error {
 		oneHost := ports[one].HostPort(exposed)
 		twoHost := ports[two].HostPort(exposed)
 		communicate(t, oneHost, "exchange", "")
@@ -1868,3 +3142,226 @@ func TestAcquire(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// failureRecorder records the failure instead of failing the test.
+type failureRecorder struct {
+	testing.TB
+	name     string
+	m        sync.Mutex
+	failed   bool
+	failure  string
+	logs     []string
+	cleanups []func()
+}
+
+func (r *failureRecorder) Name() string { return r.name }
+func (r *failureRecorder) Helper()      {}
+
+func (r *failureRecorder) Fail() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.failed = true
+}
+
+func (r *failureRecorder) Failed() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.failed
+}
+
+func (r *failureRecorder) Logf(format string, args ...any) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.logs = append(r.logs, fmt.Sprintf(format, args...))
+}
+
+func (r *failureRecorder) Fatalf(format string, args ...any) {
+	r.m.Lock()
+	r.failed = true
+	r.failure = fmt.Sprintf(format, args...)
+	r.m.Unlock()
+	runtime.Goexit()
+}
+
+func (r *failureRecorder) Cleanup(f func()) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.cleanups = append(r.cleanups, f)
+}
+
+// run calls f in another goroutine to handle runtime.Goexit.
+func (r *failureRecorder) run(f func(tb testing.TB)) string {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(r)
+	}()
+	<-done
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.failure
+}
+
+func (r *failureRecorder) cleanup() {
+	r.m.Lock()
+	cleanups := r.cleanups
+	r.cleanups = nil
+	r.m.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+}
+
+func TestConfort_RunT(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	exposed := nat.Port("80/tcp")
+	one := cft.RunT(t, ctx, &confort.ContainerParams{
+		Name:  "one",
+		Image: imageCommunicator,
+		Env: map[string]string{
+			"CM_TARGET": "two",
+		},
+		ExposedPorts: []string{string(exposed)},
+		Waiter:       wait.LogContains("communicator is ready", 1),
+	})
+	two := cft.RunT(t, ctx, &confort.ContainerParams{
+		Name:  "two",
+		Image: imageCommunicator,
+		Env: map[string]string{
+			"CM_TARGET": "one",
+		},
+		ExposedPorts: []string{string(exposed)},
+		Waiter:       wait.LogContains("communicator is ready", 1),
+	})
+
+	t.Run("UseExclusiveT", func(t *testing.T) {
+		holder := &failureRecorder{TB: t, name: "HolderTest"}
+		failure := holder.run(func(tb testing.TB) {
+			one.UseExclusiveT(tb, ctx)
+		})
+		if failure != "" {
+			t.Fatal(failure)
+		}
+
+		// the lock is held until the cleanup of the holder
+		timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		waiter := &failureRecorder{TB: t, name: "WaiterTest"}
+		failure = waiter.run(func(tb testing.TB) {
+			one.UseSharedT(tb, timeoutCtx)
+		})
+		if !strings.Contains(failure, "HolderTest[exclusive") {
+			t.Fatalf("holder of the lock is not reported: %q", failure)
+		}
+		waiter.cleanup()
+
+		holder.cleanup()
+		ports := one.UseSharedT(t, ctx)
+		if ports.HostPort(exposed) == "" {
+			t.Fatal("bound port not found")
+		}
+	})
+
+	t.Run("DoT", func(t *testing.T) {
+		ports := confort.Acquire().
+			UseExclusive(one).
+			UseExclusive(two).
+			DoT(t, ctx)
+		oneHost := ports[one].HostPort(exposed)
+		twoHost := ports[two].HostPort(exposed)
+		communicate(t, oneHost, "set", "one")
+		communicate(t, twoHost, "set", "two")
+		communicate(t, oneHost, "exchange", "")
+		if s := communicate(t, oneHost, "get", ""); s != "two" {
+			t.Fatalf("one: expected status is %q, but actual %q", "two", s)
+		}
+	})
+
+	t.Run("RunT failure", func(t *testing.T) {
+		r := &failureRecorder{TB: t, name: t.Name()}
+		failure := r.run(func(tb testing.TB) {
+			cft.RunT(tb, ctx, &confort.ContainerParams{
+				Name:  "invalid",
+				Image: "",
+			})
+		})
+		if !strings.Contains(failure, `"invalid"`) {
+			t.Fatalf("unexpected failure: %q", failure)
+		}
+	})
+}
+
+func TestWithLogDump(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	dir := t.TempDir()
+	c := cft.RunT(t, ctx, &confort.ContainerParams{
+		Name:       "logger",
+		Image:      "alpine:3.16.2",
+		Entrypoint: []string{"sh", "-c", "while true; do echo logger is running; sleep 0.1; done"},
+	},
+		confort.WithPullOptions(&types.ImagePullOptions{}, io.Discard),
+		confort.WithLogDump(dir),
+	)
+
+	run := func(t *testing.T, fail bool, opts ...confort.UseOption) *failureRecorder {
+		r := &failureRecorder{TB: t, name: t.Name()}
+		r.run(func(tb testing.TB) {
+			c.UseSharedT(tb, ctx, opts...)
+		})
+		time.Sleep(500 * time.Millisecond)
+		if fail {
+			r.Fail()
+		}
+		r.cleanup()
+		return r
+	}
+
+	t.Run("passed", func(t *testing.T) {
+		r := run(t, false, confort.WithLogDump(""))
+		if len(r.logs) > 0 {
+			t.Fatalf("unexpected logs: %v", r.logs)
+		}
+	})
+
+	t.Run("failed with t.Log", func(t *testing.T) {
+		r := run(t, true, confort.WithLogDump(""))
+		if len(r.logs) != 1 || !strings.Contains(r.logs[0], "logger is running") {
+			t.Fatalf("unexpected logs: %v", r.logs)
+		}
+	})
+
+	t.Run("failed with directory", func(t *testing.T) {
+		r := run(t, true)
+		data, err := os.ReadFile(filepath.Join(dir, "TestWithLogDump_failed_with_directory", "logger.log"))
+		if err != nil {
+			t.Fatal(err, r.logs)
+		}
+		if !strings.Contains(string(data), "logger is running") {
+			t.Fatalf("unexpected logs: %s", data)
+		}
+	})
+}
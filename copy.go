@@ -0,0 +1,342 @@
+package confort
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/daichitakahashi/confort/internal/logging"
+	"github.com/docker/docker/api/types"
+	"github.com/lestrrat-go/option"
+)
+
+type (
+	copyIdent  interface{ copy() }
+	CopyOption interface {
+		option.Interface
+		copyIdent
+	}
+	identOptionCopyMode  struct{}
+	identOptionCopyOwner struct{}
+	copyOption           struct {
+		option.Interface
+		copyIdent
+	}
+	copyOwner struct {
+		uid, gid int
+	}
+)
+
+// WithCopyMode overwrites the permission of the files copied into the container.
+// The permission of the directories is not changed.
+func WithCopyMode(mode fs.FileMode) CopyOption {
+	return copyOption{
+		Interface: option.New(identOptionCopyMode{}, mode.Perm()),
+	}
+}
+
+// WithCopyOwner specifies the owner of the files and directories copied into the container.
+// By default, the owner is root.
+func WithCopyOwner(uid, gid int) CopyOption {
+	return copyOption{
+		Interface: option.New(identOptionCopyOwner{}, copyOwner{
+			uid: uid,
+			gid: gid,
+		}),
+	}
+}
+
+type copyConfig struct {
+	mode  *fs.FileMode
+	owner *copyOwner
+}
+
+func newCopyConfig(opts []CopyOption) copyConfig {
+	var cfg copyConfig
+	for _, opt := range opts {
+		switch opt.Ident() {
+		case identOptionCopyMode{}:
+			mode := opt.Value().(fs.FileMode)
+			cfg.mode = &mode
+		case identOptionCopyOwner{}:
+			owner := opt.Value().(copyOwner)
+			cfg.owner = &owner
+		}
+	}
+	return cfg
+}
+
+func (cfg copyConfig) apply(hdr *tar.Header) {
+	if cfg.mode != nil && hdr.Typeflag == tar.TypeReg {
+		hdr.Mode = int64(*cfg.mode)
+	}
+	if cfg.owner != nil {
+		hdr.Uid, hdr.Gid = cfg.owner.uid, cfg.owner.gid
+	}
+	hdr.Uname, hdr.Gname = "", ""
+}
+
+// CopyTo copies the file or directory src on the host into the container as dst.
+// dst must be an absolute path, and its parent directories are created if not exist.
+// If dst already exists, it is overwritten.
+//
+// CopyTo can be used in InitFunc to seed the files into the container exactly once:
+//
+//	ports, err := c.UseShared(ctx, confort.WithInitFunc(func(ctx context.Context, _ confort.Ports) error {
+//		return c.CopyTo(ctx, "testdata/fixture.sql", "/docker-entrypoint-initdb.d/fixture.sql")
+//	}))
+func (c *Container) CopyTo(ctx context.Context, src, dst string, opts ...CopyOption) error {
+	if !path.IsAbs(dst) {
+		return fmt.Errorf("confort: copy: destination must be an absolute path: %s", dst)
+	}
+	cfg := newCopyConfig(opts)
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	root := strings.TrimPrefix(path.Clean(dst), "/")
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(root, filepath.ToSlash(rel))
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if cfg.owner == nil {
+			hdr.Uid, hdr.Gid = 0, 0
+		}
+		cfg.apply(hdr)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("confort: copy: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("confort: copy: %w", err)
+	}
+	return c.copyArchive(ctx, buf, cfg.owner != nil)
+}
+
+// CopyReaderTo copies the content read from r into the container as the file dst.
+// dst must be an absolute path, and its parent directories are created if not exist.
+// The permission of the file is 0644 unless WithCopyMode is specified.
+func (c *Container) CopyReaderTo(ctx context.Context, r io.Reader, dst string, opts ...CopyOption) error {
+	if !path.IsAbs(dst) {
+		return fmt.Errorf("confort: copy: destination must be an absolute path: %s", dst)
+	}
+	cfg := newCopyConfig(opts)
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("confort: copy: %w", err)
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     strings.TrimPrefix(path.Clean(dst), "/"),
+		Mode:     0644,
+		Size:     int64(len(data)),
+	}
+	cfg.apply(hdr)
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("confort: copy: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("confort: copy: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("confort: copy: %w", err)
+	}
+	return c.copyArchive(ctx, buf, cfg.owner != nil)
+}
+
+func (c *Container) copyArchive(ctx context.Context, archive io.Reader, copyUIDGID bool) error {
+	logging.Debugf("copy files into container: %s", c.name)
+	// the entries of the archive have absolute paths without leading "/"
+	err := c.cft.cli.CopyToContainer(ctx, c.name, "/", archive, types.CopyToContainerOptions{
+		CopyUIDGID: copyUIDGID,
+	})
+	if err != nil {
+		return fmt.Errorf("confort: copy: %w", err)
+	}
+	return nil
+}
+
+// CopyFrom returns the content of the file src in the container.
+// If src is not a regular file, CopyFrom fails. To copy a directory, use CopyFromToDir.
+func (c *Container) CopyFrom(ctx context.Context, src string) (io.ReadCloser, error) {
+	logging.Debugf("copy file from container: %s", c.name)
+	rc, stat, err := c.cft.cli.CopyFromContainer(ctx, c.name, src)
+	if err != nil {
+		return nil, fmt.Errorf("confort: copy: %w", err)
+	}
+	if !stat.Mode.IsRegular() {
+		_ = rc.Close()
+		return nil, fmt.Errorf("confort: copy: not a regular file: %s", src)
+	}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			_ = rc.Close()
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("file not found in archive: %s", src)
+			}
+			return nil, fmt.Errorf("confort: copy: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return &struct {
+				io.Reader
+				io.Closer
+			}{
+				Reader: tr,
+				Closer: rc,
+			}, nil
+		}
+	}
+}
+
+// CopyFromToDir copies the file or directory src in the container into the directory
+// dir on the host. The base name of src is kept, so the file "/var/log/app" is copied
+// as "<dir>/app". dir is created if not exists.
+func (c *Container) CopyFromToDir(ctx context.Context, src, dir string) error {
+	logging.Debugf("copy files from container: %s", c.name)
+	rc, _, err := c.cft.cli.CopyFromContainer(ctx, c.name, src)
+	if err != nil {
+		return fmt.Errorf("confort: copy: %w", err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	if err := extractArchive(rc, dir); err != nil {
+		return fmt.Errorf("confort: copy: %w", err)
+	}
+	return nil
+}
+
+func extractArchive(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(path.Clean("/" + hdr.Name)) // avoid escaping from dir
+		dst := filepath.Join(dir, name)
+		if err := checkExtractPath(dir, dst); err != nil {
+			return err
+		}
+		mode := fs.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, mode|0700)
+		case tar.TypeReg:
+			err = writeFile(dst, tr, mode)
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+				err = os.Symlink(hdr.Linkname, dst)
+			}
+		default:
+			logging.Debugf("skip extracting %q: unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkExtractPath ensures that the entry extracted to dst is written inside dir.
+// The symbolic links extracted from the preceding entries may point outside dir,
+// so the path through them is rejected, and the existing link at dst is removed
+// not to be followed.
+func checkExtractPath(dir, dst string) error {
+	rel, err := filepath.Rel(dir, dst)
+	if err != nil {
+		return err
+	}
+	p := dir
+	for _, elem := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if elem == "." {
+			continue
+		}
+		p = filepath.Join(p, elem)
+		info, err := os.Lstat(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("cannot extract %q: path through symbolic link %q", rel, p)
+		}
+	}
+	info, err := os.Lstat(dst)
+	if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return os.Remove(dst)
+	}
+	return nil
+}
+
+func writeFile(dst string, r io.Reader, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
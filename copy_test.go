@@ -0,0 +1,301 @@
+package confort
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContainer_CopyTo(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx = context.Background()
+		c   = createExecEnv(t, ctx)
+	)
+
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(t *testing.T, cmd ...string) string {
+		t.Helper()
+		ce, err := c.CreateExec(ctx, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ce.Output(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	t.Run("directory", func(t *testing.T) {
+		err := c.CopyTo(ctx, src, "/copied/dir", WithCopyMode(0640), WithCopyOwner(1000, 1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := run(t, "cat", "/copied/dir/a.txt", "/copied/dir/sub/b.txt"); out != "ab" {
+			t.Fatalf("unexpected content: %q", out)
+		}
+		if out := run(t, "stat", "-c", "%a %u:%g", "/copied/dir/sub/b.txt"); out != "640 1000:1000" {
+			t.Fatalf("unexpected mode and owner: %q", out)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		err := c.CopyTo(ctx, filepath.Join(src, "a.txt"), "/copied/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := run(t, "cat", "/copied/file.txt"); out != "a" {
+			t.Fatalf("unexpected content: %q", out)
+		}
+		if out := run(t, "stat", "-c", "%a %u:%g", "/copied/file.txt"); out != "600 0:0" {
+			t.Fatalf("unexpected mode and owner: %q", out)
+		}
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		err := c.CopyReaderTo(ctx, strings.NewReader("from reader"), "/copied/reader.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := run(t, "cat", "/copied/reader.txt"); out != "from reader" {
+			t.Fatalf("unexpected content: %q", out)
+		}
+	})
+
+	t.Run("relative destination", func(t *testing.T) {
+		err := c.CopyReaderTo(ctx, strings.NewReader(""), "relative.txt")
+		if err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+}
+
+func TestContainer_CopyFrom(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx = context.Background()
+		c   = createExecEnv(t, ctx)
+	)
+
+	ce, err := c.CreateExec(ctx, []string{"sh", "-c", "mkdir -p /report/sub && echo -n result > /report/sub/result.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ce.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("file", func(t *testing.T) {
+		rc, err := c.CopyFrom(ctx, "/report/sub/result.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "result" {
+			t.Fatalf("unexpected content: %q", data)
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		_, err := c.CopyFrom(ctx, "/report")
+		if err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+
+		dir := t.TempDir()
+		err = c.CopyFromToDir(ctx, "/report", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "report", "sub", "result.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "result" {
+			t.Fatalf("unexpected content: %q", data)
+		}
+	})
+
+	t.Run("in InitFunc", func(t *testing.T) {
+		var count int
+		init := func(ctx context.Context, _ Ports) error {
+			count++
+			return c.CopyReaderTo(ctx, strings.NewReader("seed"), "/seed.txt")
+		}
+		for i := 0; i < 2; i++ {
+			_, release, err := c.UseShared(ctx, WithInitFunc(init))
+			if err != nil {
+				t.Fatal(err)
+			}
+			release()
+		}
+		if count != 1 {
+			t.Fatalf("InitFunc is called %d times", count)
+		}
+		rc, err := c.CopyFrom(ctx, "/seed.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+		data, _ := io.ReadAll(rc)
+		if string(data) != "seed" {
+			t.Fatalf("unexpected content: %q", data)
+		}
+	})
+}
+
+func TestExtractArchive(t *testing.T) {
+	t.Parallel()
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, e := range []struct {
+		name string
+		typ  byte
+		data string
+	}{
+		{name: "dir/", typ: tar.TypeDir},
+		{name: "dir/file.txt", typ: tar.TypeReg, data: "file"},
+		{name: "../escape.txt", typ: tar.TypeReg, data: "escape"},
+	} {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: e.typ,
+			Name:     e.name,
+			Mode:     0644,
+			Size:     int64(len(e.data)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "dst")
+	if err := extractArchive(buf, dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"dir/file.txt": "file",
+		"escape.txt":   "escape", // extracted inside dir
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("%s: unexpected content: %q", name, data)
+		}
+	}
+}
+
+func TestExtractArchive_symlink(t *testing.T) {
+	t.Parallel()
+
+	outside := t.TempDir()
+	archive := func(t *testing.T, entries ...*tar.Header) io.Reader {
+		t.Helper()
+		buf := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(buf)
+		for _, hdr := range entries {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(make([]byte, hdr.Size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+
+	t.Run("path through symlink", func(t *testing.T) {
+		t.Parallel()
+
+		dir := filepath.Join(t.TempDir(), "dst")
+		err := extractArchive(archive(t,
+			&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: outside},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "link/evil", Mode: 0644, Size: 4},
+		), dir)
+		if err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+		if _, err := os.Stat(filepath.Join(outside, "evil")); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("file is written outside dir: %v", err)
+		}
+	})
+
+	t.Run("overwrite symlink", func(t *testing.T) {
+		t.Parallel()
+
+		target := filepath.Join(outside, "target")
+		dir := filepath.Join(t.TempDir(), "dst")
+		err := extractArchive(archive(t,
+			&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: target},
+			&tar.Header{Typeflag: tar.TypeReg, Name: "link", Mode: 0644, Size: 4},
+		), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(target); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("file is written outside dir: %v", err)
+		}
+		info, err := os.Lstat(filepath.Join(dir, "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.Mode().IsRegular() {
+			t.Fatalf("link is not replaced with the file: %s", info.Mode())
+		}
+	})
+
+	t.Run("absolute symlink", func(t *testing.T) {
+		t.Parallel()
+
+		// the link itself is kept as is
+		dir := filepath.Join(t.TempDir(), "dst")
+		err := extractArchive(archive(t,
+			&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/etc/passwd"},
+		), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		linkname, err := os.Readlink(filepath.Join(dir, "link"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, "/etc/passwd", linkname)
+	})
+}
@@ -0,0 +1,94 @@
+package confort
+
+import (
+	"github.com/daichitakahashi/confort/internal/beacon"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/lestrrat-go/option"
+)
+
+// coverDirInContainer is the path of GOCOVERDIR in the container.
+const coverDirInContainer = "/confort/coverage"
+
+type (
+	identOptionCoverDir      struct{}
+	identOptionCoverage      struct{}
+	identOptionBuildCoverage struct{}
+	identOptionGoCoverage    struct{}
+)
+
+// WithCoverDir sets the directory where the coverage data of the containers created
+// with WithCoverage is collected. By default, the value of CFT_COVERDIR environment
+// variable is used. The "confort test" command has "-coverdir" option that sets the
+// variable. If the directory is empty, the coverage data is not collected.
+//
+// The coverage data is written into the subdirectory ".confort" by the containers,
+// and merged into the directory at Close with the go command in GOROOT the test
+// binary is built with. With "confort test", it is merged by the command after all
+// tests finished instead, using the go command determined by the command.
+func WithCoverDir(dir string) NewOption {
+	return newOption{
+		Interface: option.New(identOptionCoverDir{}, dir),
+	}.new()
+}
+
+// WithCoverage makes the container write the coverage data into the directory
+// specified by WithCoverDir, by setting GOCOVERDIR and mounting the directory. The
+// binary in the container has to be built with "-cover" flag, see WithGoCoverage
+// and WithBuildCoverage.
+//
+// Because the coverage data is written when the process exits, the container is
+// stopped gracefully before it is removed. If the coverage directory is not
+// specified, WithCoverage does nothing.
+func WithCoverage() RunOption {
+	return runOption{
+		Interface: option.New(identOptionCoverage{}, true),
+	}.run()
+}
+
+// WithBuildCoverage sets "-cover" to the build arg GOFLAGS. To build the binary
+// with coverage instrumentation, the Dockerfile has to declare "ARG GOFLAGS" in the
+// stage which runs "go build".
+func WithBuildCoverage() BuildOption {
+	return buildOption{
+		Interface: option.New(identOptionBuildCoverage{}, true),
+	}.build()
+}
+
+// WithGoCoverage builds the binary with "-cover" flag in BuildGo.
+func WithGoCoverage() BuildGoOption {
+	return buildGoOption{
+		Interface: option.New(identOptionGoCoverage{}, true),
+	}.buildGo()
+}
+
+// applyCoverage configures the container to write the coverage data into raw.
+func applyCoverage(cc *container.Config, hc *container.HostConfig, raw string) {
+	cc.Env = append(cc.Env, "GOCOVERDIR="+coverDirInContainer)
+	labels := make(map[string]string, len(cc.Labels)+1)
+	for k, v := range cc.Labels {
+		labels[k] = v
+	}
+	labels[beacon.LabelCoverage] = "true"
+	cc.Labels = labels
+	// hc.Mounts may share the backing array with ContainerParams.Mounts
+	hc.Mounts = append(hc.Mounts[:len(hc.Mounts):len(hc.Mounts)], mount.Mount{
+		Type:   mount.TypeBind,
+		Source: raw,
+		Target: coverDirInContainer,
+	})
+}
+
+// coverFlags returns GOFLAGS with "-cover".
+func coverFlags(buildArgs map[string]*string) map[string]*string {
+	flags := "-cover"
+	if v := buildArgs["GOFLAGS"]; v != nil && *v != "" {
+		flags = *v + " " + flags
+	}
+	args := make(map[string]*string, len(buildArgs)+1)
+	for k, v := range buildArgs {
+		args[k] = v
+	}
+	args["GOFLAGS"] = &flags
+	return args
+}
@@ -0,0 +1,59 @@
+package confort
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestCoverFlags(t *testing.T) {
+	t.Parallel()
+
+	str := func(s string) *string { return &s }
+
+	testCases := []struct {
+		buildArgs map[string]*string
+		expected  string
+	}{
+		{
+			buildArgs: nil,
+			expected:  "-cover",
+		}, {
+			buildArgs: map[string]*string{"GOFLAGS": str("-mod=mod")},
+			expected:  "-mod=mod -cover",
+		}, {
+			buildArgs: map[string]*string{"GOFLAGS": nil, "OTHER": str("value")},
+			expected:  "-cover",
+		},
+	}
+	for _, tc := range testCases {
+		args := coverFlags(tc.buildArgs)
+		assertEqual(t, tc.expected, *args["GOFLAGS"])
+		for k, v := range tc.buildArgs {
+			if k != "GOFLAGS" && args[k] != v {
+				t.Fatalf("build arg %q is not kept", k)
+			}
+		}
+		if v, ok := tc.buildArgs["GOFLAGS"]; ok && v != nil && *v == *args["GOFLAGS"] {
+			t.Fatal("original build args must not be modified")
+		}
+	}
+}
+
+func TestApplyCoverage(t *testing.T) {
+	t.Parallel()
+
+	// the slice of ContainerParams.Mounts with spare capacity
+	mounts := make([]mount.Mount, 1, 2)
+	mounts[0] = mount.Mount{Type: mount.TypeVolume, Target: "/data"}
+
+	cc := &container.Config{}
+	hc := &container.HostConfig{Mounts: mounts}
+	applyCoverage(cc, hc, "/raw")
+	assertEqual(t, 2, len(hc.Mounts))
+	assertEqual(t, coverDirInContainer, hc.Mounts[1].Target)
+	if mounts[:2][1].Target != "" {
+		t.Fatal("the backing array of the original mounts must not be modified")
+	}
+}
@@ -64,38 +64,35 @@ func InitDatabase(ctx context.Context) (_ ConnectFunc, _ func(), err error) {
 		return nil, nil, err
 	}
 
-	return func(tb testing.TB, ctx context.Context, exclusive bool) *pgxpool.Pool {
-		tb.Helper()
-
-		ports, release, err := db.Use(ctx, exclusive, confort.WithInitFunc(func(ctx context.Context, ports confort.Ports) error {
-			cfg, err := configFromPorts(ports)
-			if err != nil {
-				return err
-			}
-			pool, err := Connect(ctx, cfg)
-			if err != nil {
-				return err
-			}
-			defer pool.Close()
-			return CreateTableIfNotExists(ctx, pool)
-		}))
+	pool := confort.NewResource(db, func(ctx context.Context, ports confort.Ports) (*pgxpool.Pool, error) {
+		cfg, err := configFromPorts(ports)
 		if err != nil {
-			tb.Fatal(err)
+			return nil, err
 		}
-		tb.Cleanup(release)
-
+		return Connect(ctx, cfg)
+	}, func(pool *pgxpool.Pool) error {
+		pool.Close()
+		return nil
+	})
+	initTable := confort.WithInitFunc(func(ctx context.Context, ports confort.Ports) error {
 		cfg, err := configFromPorts(ports)
 		if err != nil {
-			tb.Fatal(err)
+			return err
 		}
 		pool, err := Connect(ctx, cfg)
 		if err != nil {
-			tb.Fatal("ConnectFunc:", err)
+			return err
+		}
+		defer pool.Close()
+		return CreateTableIfNotExists(ctx, pool)
+	})
+
+	return func(tb testing.TB, ctx context.Context, exclusive bool) *pgxpool.Pool {
+		tb.Helper()
+		if exclusive {
+			return pool.UseExclusiveT(tb, ctx, initTable)
 		}
-		tb.Cleanup(func() {
-			pool.Close()
-		})
-		return pool
+		return pool.UseSharedT(tb, ctx, initTable)
 	}, term, nil
 }
 
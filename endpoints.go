@@ -0,0 +1,77 @@
+package confort
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/lestrrat-go/option"
+)
+
+type identOptionWithoutHostPorts struct{}
+
+// WithoutHostPorts runs the container without publishing ContainerParams.ExposedPorts
+// to the host. The container is reachable only from the other containers in the
+// network, with the addresses returned by Endpoints.InternalHostPort and its variants.
+func WithoutHostPorts() RunOption {
+	return runOption{
+		Interface: option.New(identOptionWithoutHostPorts{}, true),
+	}.run()
+}
+
+// Endpoints describes the addresses of the container. The embedded Ports holds the
+// host port bindings, which are available for the tests. The other methods return
+// the addresses in the network created by New, which are available for the other
+// containers in the network.
+type Endpoints struct {
+	Ports
+	alias     string
+	ipAddress string
+}
+
+// Alias returns the host name of the container in the network.
+func (e Endpoints) Alias() string {
+	return e.alias
+}
+
+// IPAddress returns the IP address of the container in the network.
+func (e Endpoints) IPAddress() string {
+	return e.ipAddress
+}
+
+// InternalHostPort returns "alias:port" style string of the given container port,
+// which is reachable from the other containers in the network.
+func (e Endpoints) InternalHostPort(port nat.Port) string {
+	return net.JoinHostPort(e.alias, port.Port())
+}
+
+// InternalIPPort returns "ip:port" style string of the given container port. If the
+// IP address of the container is unknown, InternalIPPort returns empty string.
+func (e Endpoints) InternalIPPort(port nat.Port) string {
+	if e.ipAddress == "" {
+		return ""
+	}
+	return net.JoinHostPort(e.ipAddress, port.Port())
+}
+
+// InternalURL returns "scheme://alias:port" style string of the given container port.
+// If scheme is empty, use "http" as a default scheme.
+func (e Endpoints) InternalURL(port nat.Port, scheme string) string {
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, e.InternalHostPort(port))
+}
+
+// endpoints returns Endpoints of the container with the given host port bindings.
+func (c *Container) endpoints(ports Ports) Endpoints {
+	ipAddress, err := c.cft.namespace.ContainerIPAddress(c.name)
+	if err != nil {
+		ipAddress = ""
+	}
+	return Endpoints{
+		Ports:     ports,
+		alias:     c.alias,
+		ipAddress: ipAddress,
+	}
+}
@@ -0,0 +1,30 @@
+package confort
+
+import (
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+func TestEndpoints(t *testing.T) {
+	t.Parallel()
+
+	e := Endpoints{
+		Ports: Ports{
+			"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "49153"}},
+		},
+		alias:     "echo",
+		ipAddress: "172.18.0.2",
+	}
+	assertEqual(t, "127.0.0.1:49153", e.HostPort("80/tcp"))
+	assertEqual(t, "http://127.0.0.1:49153", e.URL("80/tcp", ""))
+	assertEqual(t, "echo", e.Alias())
+	assertEqual(t, "172.18.0.2", e.IPAddress())
+	assertEqual(t, "echo:80", e.InternalHostPort("80/tcp"))
+	assertEqual(t, "172.18.0.2:80", e.InternalIPPort("80/tcp"))
+	assertEqual(t, "http://echo:80", e.InternalURL("80/tcp", ""))
+	assertEqual(t, "grpc://echo:80", e.InternalURL("80/tcp", "grpc"))
+
+	e.ipAddress = ""
+	assertEqual(t, "", e.InternalIPPort("80/tcp"))
+}
@@ -6,6 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strings"
+	"syscall"
 
 	"github.com/daichitakahashi/confort/internal/logging"
 	"github.com/docker/docker/api/types"
@@ -14,16 +17,44 @@ import (
 	"github.com/lestrrat-go/option"
 )
 
+const defaultHelperImage = "busybox:1.36"
+
+type identOptionHelperImage struct{}
+
+// WithHelperImage sets the image of the helper container used by ContainerExec.Signal.
+// The image must contain "sh", "tr" and "kill" commands. By default, "busybox:1.36" is
+// used. The image is pulled if not exists.
+func WithHelperImage(image string) NewOption {
+	return newOption{
+		Interface: option.New(identOptionHelperImage{}, image),
+	}.new()
+}
+
 type ContainerExec struct {
 	c          *Container
 	cmd        []string
 	workingDir string
 	env        []string
+	user       string
+	privileged bool
+	tty        bool
+	size       *[2]uint
 	cli        *client.Client
 	execID     string
 
+	// Stdin specifies the standard input of the command. If Stdin is nil, the standard
+	// input is not attached. The input is closed after all data is written.
+	Stdin io.Reader
+	// Stdout and Stderr specify the standard output and error of the command. With
+	// WithExecTTY, the standard error is written into Stdout.
 	Stdout io.Writer
 	Stderr io.Writer
+
+	resp        *types.HijackedResponse
+	copyDone    chan error
+	stdinDone   chan error
+	closeAfters []*io.PipeWriter
+	stdinPipe   *io.PipeReader
 }
 
 type (
@@ -34,6 +65,9 @@ type (
 	}
 	identOptionExecWorkingDir struct{}
 	identOptionExecEnv        struct{}
+	identOptionExecUser       struct{}
+	identOptionExecPrivileged struct{}
+	identOptionExecTTY        struct{}
 	execOption                struct {
 		option.Interface
 		execIdent
@@ -58,43 +92,124 @@ func WithExecEnv(kv map[string]string) ExecOption {
 	}
 }
 
+// WithExecUser specifies the user that executes the command, in the form of
+// "user", "user:group", "uid" or "uid:gid".
+func WithExecUser(user string) ExecOption {
+	return execOption{
+		Interface: option.New(identOptionExecUser{}, user),
+	}
+}
+
+// WithExecPrivileged executes the command with extended privileges.
+func WithExecPrivileged() ExecOption {
+	return execOption{
+		Interface: option.New(identOptionExecPrivileged{}, true),
+	}
+}
+
+// WithExecTTY allocates a pseudo-TTY for the command. The initial size of the
+// terminal is specified by height and width, if both are not zero. The size can be
+// changed by ContainerExec.Resize after Start.
+func WithExecTTY(height, width uint) ExecOption {
+	return execOption{
+		Interface: option.New(identOptionExecTTY{}, [2]uint{height, width}),
+	}
+}
+
 // CreateExec creates new ContainerExec that executes the specified command on the container.
 func (c *Container) CreateExec(ctx context.Context, cmd []string, opts ...ExecOption) (*ContainerExec, error) {
-	var (
-		workingDir string
-		execEnv    []string
-	)
+	e := &ContainerExec{
+		c:   c,
+		cmd: cmd,
+		cli: c.cft.cli,
+	}
 	for _, opt := range opts {
 		switch opt.Ident() {
 		case identOptionExecWorkingDir{}:
-			workingDir = opt.Value().(string)
+			e.workingDir = opt.Value().(string)
 		case identOptionExecEnv{}:
-			execEnv = opt.Value().([]string)
+			e.env = opt.Value().([]string)
+		case identOptionExecUser{}:
+			e.user = opt.Value().(string)
+		case identOptionExecPrivileged{}:
+			e.privileged = opt.Value().(bool)
+		case identOptionExecTTY{}:
+			e.tty = true
+			if size := opt.Value().([2]uint); size != [2]uint{} {
+				e.size = &size
+			}
 		}
 	}
 
-	if _, err := c.cft.cli.ContainerInspect(ctx, c.id); err != nil {
+	if _, err := c.cft.cli.ContainerInspect(ctx, c.name); err != nil {
 		return nil, err
 	}
-	return &ContainerExec{
-		c:          c,
-		cmd:        cmd,
-		workingDir: workingDir,
-		env:        execEnv,
-		cli:        c.cft.cli,
-	}, nil
+	return e, nil
+}
+
+// StdoutPipe returns a pipe that will be connected to the command's standard output
+// when the command starts. The pipe is closed when the output ends, so the caller
+// has to read all data from the pipe before calling Wait.
+func (e *ContainerExec) StdoutPipe() (io.ReadCloser, error) {
+	if e.Stdout != nil {
+		return nil, errors.New("confort: exec: Stdout already set")
+	}
+	if e.execID != "" {
+		return nil, errors.New("confort: exec: StdoutPipe after process started")
+	}
+	pr, pw := io.Pipe()
+	e.Stdout = pw
+	e.closeAfters = append(e.closeAfters, pw)
+	return pr, nil
+}
+
+// StderrPipe returns a pipe that will be connected to the command's standard error
+// when the command starts. The pipe is closed when the output ends, so the caller
+// has to read all data from the pipe before calling Wait.
+func (e *ContainerExec) StderrPipe() (io.ReadCloser, error) {
+	if e.Stderr != nil {
+		return nil, errors.New("confort: exec: Stderr already set")
+	}
+	if e.execID != "" {
+		return nil, errors.New("confort: exec: StderrPipe after process started")
+	}
+	pr, pw := io.Pipe()
+	e.Stderr = pw
+	e.closeAfters = append(e.closeAfters, pw)
+	return pr, nil
+}
+
+// StdinPipe returns a pipe that will be connected to the command's standard input
+// when the command starts. Closing the pipe closes the standard input of the command.
+func (e *ContainerExec) StdinPipe() (io.WriteCloser, error) {
+	if e.Stdin != nil {
+		return nil, errors.New("confort: exec: Stdin already set")
+	}
+	if e.execID != "" {
+		return nil, errors.New("confort: exec: StdinPipe after process started")
+	}
+	pr, pw := io.Pipe()
+	e.Stdin = pr
+	e.stdinPipe = pr
+	return pw, nil
 }
 
 // Start executes the command but does not wait for it to complete.
+// The standard input and output are copied in the background until Wait is called.
 func (e *ContainerExec) Start(ctx context.Context) error {
 	if e.execID != "" {
 		return errors.New("confort: exec: already started")
 	}
 	logging.Debugf("exec on container %q: %v", e.c.name, e.cmd)
 	execConfig := types.ExecConfig{
+		User:         e.user,
+		Privileged:   e.privileged,
+		Tty:          e.tty,
+		ConsoleSize:  e.size,
 		Cmd:          e.cmd,
 		WorkingDir:   e.workingDir,
 		Env:          e.env,
+		AttachStdin:  e.Stdin != nil,
 		AttachStdout: e.Stdout != nil,
 		AttachStderr: e.Stderr != nil,
 	}
@@ -103,14 +218,112 @@ func (e *ContainerExec) Start(ctx context.Context) error {
 	if !execConfig.AttachStdout && !execConfig.AttachStderr {
 		execConfig.AttachStdout = true
 	}
-	resp, err := e.cli.ContainerExecCreate(ctx, e.c.id, execConfig)
+	resp, err := e.cli.ContainerExecCreate(ctx, e.c.name, execConfig)
 	if err != nil {
 		return err
 	}
 	e.execID = resp.ID
+
+	hijackedResp, err := e.cli.ContainerExecAttach(ctx, e.execID, types.ExecStartCheck{
+		Tty:         e.tty,
+		ConsoleSize: e.size,
+	})
+	if err != nil {
+		e.closePipes(err)
+		return err
+	}
+	e.resp = &hijackedResp
+
+	var (
+		stdout = io.Discard
+		stderr = io.Discard
+	)
+	if e.Stdout != nil {
+		stdout = e.Stdout
+	}
+	if e.Stderr != nil {
+		stderr = e.Stderr
+	}
+	e.copyDone = make(chan error, 1)
+	go func() {
+		var err error
+		if e.tty {
+			// the output of TTY is not multiplexed
+			_, err = io.Copy(stdout, hijackedResp.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(stdout, stderr, hijackedResp.Reader)
+		}
+		e.closePipes(err)
+		e.copyDone <- err
+	}()
+
+	if e.Stdin != nil {
+		e.stdinDone = make(chan error, 1)
+		go func() {
+			_, err := io.Copy(hijackedResp.Conn, e.Stdin)
+			if err == nil {
+				err = hijackedResp.CloseWrite()
+			}
+			e.stdinDone <- err
+		}()
+	}
+	return nil
+}
+
+// closePipes closes the pipes created by StdoutPipe and StderrPipe.
+func (e *ContainerExec) closePipes(err error) {
+	for _, pw := range e.closeAfters {
+		_ = pw.CloseWithError(err)
+	}
+}
+
+// Resize changes the size of the TTY allocated by WithExecTTY.
+func (e *ContainerExec) Resize(ctx context.Context, height, width uint) error {
+	if e.execID == "" {
+		return errors.New("confort: exec: not started")
+	} else if !e.tty {
+		return errors.New("confort: exec: TTY is not allocated")
+	}
+	return e.cli.ContainerExecResize(ctx, e.execID, types.ResizeOptions{
+		Height: height,
+		Width:  width,
+	})
+}
+
+// Signal sends the signal to the process of the command, such as "SIGTERM" or "TERM".
+// Because Docker doesn't provide the API to signal the process of exec, the signal is
+// sent by the helper container sharing the PID namespace of the Docker daemon. The
+// helper container uses the image specified by WithHelperImage, which is pulled at
+// the first call if not exists. Pull it in advance if ctx has a short deadline.
+//
+// The helper container checks that the command line of the process matches the
+// command before sending the signal, so that the signal is not delivered to another
+// process reusing the PID of the finished one. Signal fails if the command rewrites
+// its command line or replaces itself by exec. The race between the check and the
+// delivery of the signal remains, but it is negligible in practice.
+func (e *ContainerExec) Signal(ctx context.Context, signal string) error {
+	if e.execID == "" {
+		return errors.New("confort: exec: not started")
+	}
+	info, err := e.cli.ContainerExecInspect(ctx, e.execID)
+	if err != nil {
+		return fmt.Errorf("confort: exec: %w", err)
+	} else if !info.Running || info.Pid == 0 {
+		return errors.New("confort: exec: process already finished")
+	}
+	logging.Debugf("send %s to exec on container %q: %v", signal, e.c.name, e.cmd)
+	err = e.c.cft.namespace.KillProcess(ctx, e.c.cft.helperImage, info.Pid, e.cmd, strings.TrimPrefix(signal, "SIG"))
+	if err != nil {
+		return fmt.Errorf("confort: exec: %w", err)
+	}
 	return nil
 }
 
+// Kill sends SIGKILL to the process of the command. See Signal.
+func (e *ContainerExec) Kill(ctx context.Context) error {
+	return e.Signal(ctx, "KILL")
+}
+
 type ExitError struct {
 	ExitCode int
 }
@@ -119,34 +332,79 @@ func (e *ExitError) Error() string {
 	return fmt.Sprintf("confort: exec: exit status %d", e.ExitCode)
 }
 
+// CanceledError is returned by Wait when the context is done before the command exits.
+// The process of the command is killed when the error occurs.
+type CanceledError struct {
+	// Err is the error of the context.
+	Err error
+	// KillErr is the error occurred in killing the process.
+	KillErr error
+}
+
+func (e *CanceledError) Error() string {
+	if e.KillErr != nil {
+		return fmt.Sprintf("confort: exec: %s (failed to kill the process: %s)", e.Err, e.KillErr)
+	}
+	return fmt.Sprintf("confort: exec: %s (process killed)", e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
 // Wait waits for the specified command to exit and waits for copying from stdout or stderr to complete.
 // The command must have been started by Start.
 // The returned error is nil if the command runs, has no problems copying stdin, stdout, and stderr, and exits with a zero exit status.
 // If the command fails to run or doesn't complete successfully, the error is of type *ExitError.
+// If ctx is done before the command exits, the process is killed by Kill and the error is of type *CanceledError.
+// The kill may pull the image of the helper container, see Signal.
 func (e *ContainerExec) Wait(ctx context.Context) error {
 	if e.execID == "" {
 		return errors.New("confort: exec: not started")
+	} else if e.resp == nil {
+		return errors.New("confort: exec: Wait was already called")
 	}
+	resp := e.resp
+	e.resp = nil
 
-	hijackedResp, err := e.cli.ContainerExecAttach(ctx, e.execID, types.ExecStartCheck{})
-	if err != nil {
-		return err
+	var copyErr error
+	select {
+	case copyErr = <-e.copyDone:
+	case <-ctx.Done():
+		// ctx is already done, so kill the process with another context
+		killCtx, cancel := applyTimeout(context.Background(), e.c.cft.defaultTimeout)
+		defer cancel()
+		killErr := e.Kill(killCtx)
+		if killErr != nil {
+			// the process has exited in the meantime
+			if info, err := e.cli.ContainerExecInspect(killCtx, e.execID); err == nil && !info.Running {
+				killErr = nil
+			}
+		}
+		resp.Close()
+		<-e.copyDone
+		if e.stdinPipe != nil {
+			_ = e.stdinPipe.Close()
+		}
+		return &CanceledError{
+			Err:     ctx.Err(),
+			KillErr: killErr,
+		}
 	}
-	defer hijackedResp.Close()
-
-	var (
-		stdout = io.Discard
-		stderr = io.Discard
-	)
-	if e.Stdout != nil {
-		stdout = e.Stdout
+	resp.Close()
+	if copyErr != nil {
+		return copyErr
 	}
-	if e.Stderr != nil {
-		stderr = e.Stderr
+	if e.stdinPipe != nil {
+		// unblock writing to the pipe after the command exited
+		_ = e.stdinPipe.Close()
 	}
-	_, err = stdcopy.StdCopy(stdout, stderr, hijackedResp.Reader)
-	if err != nil {
-		return err
+	if e.stdinDone != nil {
+		// these errors mean that the command exited without reading all input
+		err := <-e.stdinDone
+		if err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, syscall.EPIPE) && !errors.Is(err, io.ErrClosedPipe) {
+			return err
+		}
 	}
 
 	info, err := e.cli.ContainerExecInspect(ctx, e.execID)
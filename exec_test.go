@@ -1,11 +1,15 @@
 package confort
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
@@ -295,3 +299,318 @@ func TestWithExecEnv(t *testing.T) {
 		t.Fatalf("got unexpected value(key=%q): want %q, got %q", key2, value2, actual)
 	}
 }
+
+func TestContainerExec_Stdin(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx = context.Background()
+		c   = createExecEnv(t, ctx)
+	)
+	const input = "line1\nline2\nline3\n"
+
+	t.Run("Stdin", func(t *testing.T) {
+		t.Parallel()
+
+		ce, err := c.CreateExec(ctx, []string{"cat"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ce.Stdin = strings.NewReader(input)
+		out, err := ce.Output(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(input, string(out)); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("StdinPipe", func(t *testing.T) {
+		t.Parallel()
+
+		ce, err := c.CreateExec(ctx, []string{"wc", "-l"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdin, err := ce.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := bytes.NewBuffer(nil)
+		ce.Stdout = stdout
+		if err := ce.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(stdin, input); err != nil {
+			t.Fatal(err)
+		}
+		if err := stdin.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ce.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.TrimSpace(stdout.String()); lines != "3" {
+			t.Fatalf("unexpected number of lines: %q", lines)
+		}
+	})
+}
+
+func TestContainerExec_StdoutPipe(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx = context.Background()
+		c   = createExecEnv(t, ctx)
+	)
+
+	// the command waits for the file created after reading the first line
+	ce, err := c.CreateExec(ctx, []string{"/bin/sh", "-c", `echo ready; echo error >&2; while [ ! -f /tmp/go ]; do sleep 0.1; done; echo done`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := ce.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := ce.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ce.StdoutPipe(); err == nil {
+		t.Fatal("the second StdoutPipe must be failed")
+	}
+	if err := ce.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(stderr)
+		errc <- b
+	}()
+	scanner := bufio.NewScanner(stdout)
+	if !scanner.Scan() || scanner.Text() != "ready" {
+		t.Fatalf("unexpected first line: %q(%v)", scanner.Text(), scanner.Err())
+	}
+	touch, err := c.CreateExec(ctx, []string{"touch", "/tmp/go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := touch.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !scanner.Scan() || scanner.Text() != "done" {
+		t.Fatalf("unexpected second line: %q(%v)", scanner.Text(), scanner.Err())
+	}
+	if scanner.Scan() {
+		t.Fatalf("unexpected line: %q", scanner.Text())
+	}
+	if err := ce.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-errc; string(b) != "error\n" {
+		t.Fatalf("unexpected stderr: %q", b)
+	}
+}
+
+func TestWithExecTTY(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx = context.Background()
+		c   = createExecEnv(t, ctx)
+	)
+
+	ce, err := c.CreateExec(ctx, []string{"/bin/sh", "-c", `stty size; read line; stty size`},
+		WithExecTTY(24, 80),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := ce.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := ce.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ce.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "24 80" {
+		t.Fatalf("unexpected size: %q(%v)", scanner.Text(), scanner.Err())
+	}
+	if err := ce.Resize(ctx, 40, 120); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(stdin, "\n"); err != nil {
+		t.Fatal(err)
+	}
+	// skip the echo of the input
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			if line != "40 120" {
+				t.Fatalf("unexpected size after resize: %q", line)
+			}
+			break
+		}
+	}
+	_ = stdin.Close()
+	_, _ = io.Copy(io.Discard, stdout)
+	if err := ce.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ce, err = c.CreateExec(ctx, []string{"pwd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ce.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ce.Resize(ctx, 40, 120); err == nil {
+		t.Fatal("Resize without TTY must be failed")
+	}
+}
+
+func TestWithExecUser(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx = context.Background()
+		c   = createExecEnv(t, ctx)
+	)
+
+	ce, err := c.CreateExec(ctx, []string{"id", "-u"},
+		WithExecUser("nobody"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ce.Output(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := strings.TrimSpace(string(out)); uid != "65534" {
+		t.Fatalf("unexpected uid: %q", uid)
+	}
+}
+
+func TestWithExecPrivileged(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx = context.Background()
+		c   = createExecEnv(t, ctx)
+	)
+
+	capEff := func(t *testing.T, opts ...ExecOption) string {
+		t.Helper()
+		ce, err := c.CreateExec(ctx, []string{"/bin/sh", "-c", `grep CapEff /proc/self/status`}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ce.Output(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if capEff(t) == capEff(t, WithExecPrivileged()) {
+		t.Fatal("expected to have extended capabilities")
+	}
+}
+
+func TestContainerExec_Signal(t *testing.T) {
+	t.Parallel()
+	var (
+		ctx = context.Background()
+		c   = createExecEnv(t, ctx)
+	)
+
+	t.Run("Signal", func(t *testing.T) {
+		t.Parallel()
+
+		ce, err := c.CreateExec(ctx, []string{"/bin/sh", "-c", `trap 'echo terminated; exit 3' TERM; echo ready; while true; do sleep 0.1; done`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ce.Signal(ctx, "SIGTERM"); err == nil {
+			t.Fatal("Signal before Start must be failed")
+		}
+		stdout, err := ce.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ce.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(stdout)
+		if !scanner.Scan() || scanner.Text() != "ready" {
+			t.Fatalf("unexpected first line: %q(%v)", scanner.Text(), scanner.Err())
+		}
+		if err := ce.Signal(ctx, "SIGTERM"); err != nil {
+			t.Fatal(err)
+		}
+		if !scanner.Scan() || scanner.Text() != "terminated" {
+			t.Fatalf("unexpected second line: %q(%v)", scanner.Text(), scanner.Err())
+		}
+		var exitErr *ExitError
+		if err := ce.Wait(ctx); !errors.As(err, &exitErr) || exitErr.ExitCode != 3 {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Kill", func(t *testing.T) {
+		t.Parallel()
+
+		ce, err := c.CreateExec(ctx, []string{"sleep", "1000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ce.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := ce.Kill(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var exitErr *ExitError
+		if err := ce.Wait(ctx); !errors.As(err, &exitErr) || exitErr.ExitCode != 137 {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := ce.Kill(ctx); err == nil {
+			t.Fatal("Kill after the process finished must be failed")
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		t.Parallel()
+
+		ce, err := c.CreateExec(ctx, []string{"sleep", "2000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		runCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		err = ce.Run(runCtx)
+		var canceledErr *CanceledError
+		if !errors.As(err, &canceledErr) {
+			t.Fatalf("expected *CanceledError, but got %v", err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected to wrap context.DeadlineExceeded: %v", err)
+		}
+		if canceledErr.KillErr != nil {
+			t.Fatal(canceledErr.KillErr)
+		}
+
+		// confirm that the process is killed
+		pgrep, err := c.CreateExec(ctx, []string{"pgrep", "-f", "sleep 2000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var exitErr *ExitError
+		if err := pgrep.Run(ctx); !errors.As(err, &exitErr) {
+			t.Fatalf("expected that the process is not found: %v", err)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package confort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/option"
+	"go.uber.org/multierr"
+)
+
+const defaultNetemImage = "nicolaka/netshoot:v0.8"
+
+type identOptionNetemImage struct{}
+
+// WithNetemImage sets the image of the helper container used by Container.SetNetworkCondition.
+// The image must contain "sh", "ip", "awk" and "tc" commands.
+// By default, "nicolaka/netshoot:v0.8" is used. The image is pulled if not exists.
+func WithNetemImage(image string) NewOption {
+	return newOption{
+		Interface: option.New(identOptionNetemImage{}, image),
+	}.new()
+}
+
+// NetworkCondition is the condition of the network interface of the container,
+// emulated by "tc netem".
+type NetworkCondition struct {
+	// Latency is the delay added to the outgoing packets.
+	Latency time.Duration
+	// Jitter is the variation of Latency.
+	Jitter time.Duration
+	// Loss is the percentage of the outgoing packets to be dropped.
+	Loss float64
+	// Bandwidth is the limit of the rate of the outgoing packets in bits per second.
+	Bandwidth uint64
+}
+
+func (n NetworkCondition) args() ([]string, error) {
+	var args []string
+	if n.Jitter > 0 && n.Latency == 0 {
+		return nil, errors.New("jitter requires latency")
+	} else if n.Latency > 0 {
+		args = append(args, "delay", strconv.FormatInt(n.Latency.Microseconds(), 10)+"us")
+		if n.Jitter > 0 {
+			args = append(args, strconv.FormatInt(n.Jitter.Microseconds(), 10)+"us")
+		}
+	}
+	if n.Loss < 0 || n.Loss > 100 {
+		return nil, fmt.Errorf("invalid loss: %g", n.Loss)
+	} else if n.Loss > 0 {
+		args = append(args, "loss", strconv.FormatFloat(n.Loss, 'f', -1, 64)+"%")
+	}
+	if n.Bandwidth > 0 {
+		args = append(args, "rate", strconv.FormatUint(n.Bandwidth, 10)+"bit")
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty network condition")
+	}
+	return args, nil
+}
+
+// The methods below inject the network faults into the container. Like the lifecycle
+// methods such as Container.Stop, they require the exclusive lock of the container
+// acquired in this process. The injected faults are reverted automatically when the
+// lock is released.
+
+// DisconnectNetwork disconnects the container from the network of Confort.
+// Other containers cannot reach the container by its alias until ConnectNetwork
+// is called. The host ports published through the network become unreachable too.
+func (c *Container) DisconnectNetwork(ctx context.Context) error {
+	return c.control(ctx, "disconnect", func(ctx context.Context) error {
+		err := c.cft.namespace.DisconnectContainer(ctx, c.name)
+		if err != nil {
+			return err
+		}
+		c.cft.faults.add(c.name, faultDisconnected, func(ctx context.Context) error {
+			return c.cft.namespace.ConnectContainer(ctx, c.name)
+		})
+		return nil
+	})
+}
+
+// ConnectNetwork connects the container disconnected by DisconnectNetwork to the
+// network of Confort again, with the same aliases.
+func (c *Container) ConnectNetwork(ctx context.Context) error {
+	return c.control(ctx, "connect", func(ctx context.Context) error {
+		err := c.cft.namespace.ConnectContainer(ctx, c.name)
+		if err != nil {
+			return err
+		}
+		c.cft.faults.remove(c.name, faultDisconnected)
+		return nil
+	})
+}
+
+// SetNetworkCondition applies the latency, packet loss and bandwidth limit to the
+// outgoing packets of the container on the network of Confort, so it affects both the
+// communication between containers and the one from the host. The condition replaces
+// the previous one.
+//
+// The condition is applied by "tc netem" executed in the helper container, which
+// shares the network stack of the container with NET_ADMIN capability.
+// See WithNetemImage.
+func (c *Container) SetNetworkCondition(ctx context.Context, cond NetworkCondition) error {
+	args, err := cond.args()
+	if err != nil {
+		return fmt.Errorf("confort: netem: %w", err)
+	}
+	return c.control(ctx, "netem", func(ctx context.Context) error {
+		err := c.cft.namespace.NetemContainer(ctx, c.name, c.cft.netemImage, args)
+		if err != nil {
+			return err
+		}
+		c.cft.faults.add(c.name, faultNetem, func(ctx context.Context) error {
+			return c.cft.namespace.NetemContainer(ctx, c.name, c.cft.netemImage, nil)
+		})
+		return nil
+	})
+}
+
+// ClearNetworkCondition removes the condition applied by SetNetworkCondition.
+func (c *Container) ClearNetworkCondition(ctx context.Context) error {
+	return c.control(ctx, "netem", func(ctx context.Context) error {
+		err := c.cft.namespace.NetemContainer(ctx, c.name, c.cft.netemImage, nil)
+		if err != nil {
+			return err
+		}
+		c.cft.faults.remove(c.name, faultNetem)
+		return nil
+	})
+}
+
+func (c *Container) revertFaults() error {
+	ctx, cancel := applyTimeout(context.Background(), c.cft.defaultTimeout)
+	defer cancel()
+
+	err := c.cft.faults.revert(ctx, c.name)
+	if err != nil {
+		return fmt.Errorf("failed to revert network faults of container %q: %w", c.alias, err)
+	}
+	return nil
+}
+
+type faultKind int
+
+// The faults are reverted in this order.
+const (
+	faultDisconnected faultKind = iota
+	faultNetem
+)
+
+// faults records the functions to revert the faults injected into the containers.
+type faults struct {
+	m       sync.Mutex
+	reverts map[string]map[faultKind]func(ctx context.Context) error // key is the container name
+}
+
+func newFaults() *faults {
+	return &faults{
+		reverts: map[string]map[faultKind]func(ctx context.Context) error{},
+	}
+}
+
+func (f *faults) add(name string, kind faultKind, revert func(ctx context.Context) error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.reverts[name] == nil {
+		f.reverts[name] = map[faultKind]func(ctx context.Context) error{}
+	}
+	f.reverts[name][kind] = revert
+}
+
+func (f *faults) remove(name string, kind faultKind) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	delete(f.reverts[name], kind)
+}
+
+// revert reverts all faults of the container.
+func (f *faults) revert(ctx context.Context, name string) error {
+	f.m.Lock()
+	reverts := f.reverts[name]
+	delete(f.reverts, name)
+	f.m.Unlock()
+
+	var err error
+	for _, kind := range []faultKind{faultDisconnected, faultNetem} {
+		if revert, ok := reverts[kind]; ok {
+			err = multierr.Append(err, revert(ctx))
+		}
+	}
+	return err
+}
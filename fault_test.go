@@ -0,0 +1,64 @@
+package confort
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNetworkCondition_args(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		cond     NetworkCondition
+		expected []string
+		wantErr  bool
+	}{
+		{
+			cond: NetworkCondition{
+				Latency: 100 * time.Millisecond,
+			},
+			expected: []string{"delay", "100000us"},
+		}, {
+			cond: NetworkCondition{
+				Latency:   100 * time.Millisecond,
+				Jitter:    10 * time.Millisecond,
+				Loss:      0.5,
+				Bandwidth: 1_000_000,
+			},
+			expected: []string{"delay", "100000us", "10000us", "loss", "0.5%", "rate", "1000000bit"},
+		}, {
+			cond: NetworkCondition{
+				Loss: 100,
+			},
+			expected: []string{"loss", "100%"},
+		}, {
+			cond:    NetworkCondition{},
+			wantErr: true,
+		}, {
+			cond: NetworkCondition{
+				Jitter: time.Millisecond,
+			},
+			wantErr: true,
+		}, {
+			cond: NetworkCondition{
+				Loss: 101,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		args, err := tc.cond.args()
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%+v: error expected but succeeded", tc.cond)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %s", tc.cond, err)
+		} else if !reflect.DeepEqual(args, tc.expected) {
+			t.Errorf("%+v: unexpected args: want %q, got %q", tc.cond, tc.expected, args)
+		}
+	}
+}
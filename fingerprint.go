@@ -0,0 +1,50 @@
+package confort
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// labelFingerprint is attached to the container, and holds the fingerprint of the
+// configurations specified at creation.
+const labelFingerprint = "daichitakahashi.confort.fingerprint"
+
+// fingerprint calculates the hash of the configurations of the container.
+// It must be calculated before the labels of the namespace are merged, because they
+// can be changed in every execution of the test.
+//
+// The IDs of the networks are excluded, because the network of the namespace can be
+// recreated with the same name. The networks are identified by their names, which
+// are the keys of EndpointsConfig.
+func fingerprint(config *container.Config, host *container.HostConfig, networking *network.NetworkingConfig) (string, error) {
+	var endpoints map[string]*network.EndpointSettings
+	if networking != nil {
+		endpoints = make(map[string]*network.EndpointSettings, len(networking.EndpointsConfig))
+		for name, settings := range networking.EndpointsConfig {
+			if settings != nil {
+				s := *settings
+				s.NetworkID = ""
+				settings = &s
+			}
+			endpoints[name] = settings
+		}
+	}
+	data, err := json.Marshal(struct {
+		Container *container.Config
+		Host      *container.HostConfig
+		Endpoints map[string]*network.EndpointSettings
+	}{
+		Container: config,
+		Host:      host,
+		Endpoints: endpoints,
+	})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
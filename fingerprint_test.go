@@ -0,0 +1,86 @@
+package confort
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	configs := func() (*container.Config, *container.HostConfig, *network.NetworkingConfig) {
+		return &container.Config{
+			Image: "alpine:3.16",
+			Env:   []string{"A=1", "B=2"},
+			Cmd:   []string{"sleep", "infinity"},
+			ExposedPorts: nat.PortSet{
+				"80/tcp": struct{}{},
+			},
+		}, &container.HostConfig{
+			PortBindings: nat.PortMap{
+				"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1"}},
+			},
+		}, &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				"network": {NetworkID: "id", Aliases: []string{"alpine"}},
+			},
+		}
+	}
+	mustFingerprint := func(t *testing.T, c *container.Config, h *container.HostConfig, n *network.NetworkingConfig) string {
+		t.Helper()
+		fp, err := fingerprint(c, h, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+	c, h, n := configs()
+	base := mustFingerprint(t, c, h, n)
+
+	t.Run("same", func(t *testing.T) {
+		t.Parallel()
+		c, h, n := configs()
+		assertEqual(t, base, mustFingerprint(t, c, h, n))
+	})
+
+	t.Run("recreated network", func(t *testing.T) {
+		t.Parallel()
+		c, h, n := configs()
+		n.EndpointsConfig["network"].NetworkID = "recreated"
+		assertEqual(t, base, mustFingerprint(t, c, h, n))
+	})
+
+	testCases := map[string]func(c *container.Config, h *container.HostConfig, n *network.NetworkingConfig){
+		"env": func(c *container.Config, _ *container.HostConfig, _ *network.NetworkingConfig) {
+			c.Env = []string{"A=1", "B=3"}
+		},
+		"cmd": func(c *container.Config, _ *container.HostConfig, _ *network.NetworkingConfig) {
+			c.Cmd = []string{"sleep", "10"}
+		},
+		"port": func(_ *container.Config, h *container.HostConfig, _ *network.NetworkingConfig) {
+			h.PortBindings["80/tcp"][0].HostPort = "8080"
+		},
+		"alias": func(_ *container.Config, _ *container.HostConfig, n *network.NetworkingConfig) {
+			n.EndpointsConfig["network"].Aliases = []string{"busybox"}
+		},
+		"network name": func(_ *container.Config, _ *container.HostConfig, n *network.NetworkingConfig) {
+			n.EndpointsConfig = map[string]*network.EndpointSettings{
+				"other": n.EndpointsConfig["network"],
+			}
+		},
+	}
+	for name, modify := range testCases {
+		modify := modify
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			c, h, n := configs()
+			modify(c, h, n)
+			if mustFingerprint(t, c, h, n) == base {
+				t.Fatal("expected to differ")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package confort
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockHolder is a record of the lock for using the container.
+type lockHolder struct {
+	test      string // name of the test, empty if the lock is acquired without testing.TB
+	exclusive bool
+	since     time.Time
+}
+
+func (h *lockHolder) String() string {
+	test := h.test
+	if test == "" {
+		test = "(unknown test)"
+	}
+	mode := "shared"
+	if h.exclusive {
+		mode = "exclusive"
+	}
+	return fmt.Sprintf("%s[%s, %s]", test, mode, time.Since(h.since).Round(time.Millisecond))
+}
+
+// lockHolders records the holders of the locks acquired in this process for
+// diagnostics. The holders in other processes sharing the beacon server are not
+// recorded.
+type lockHolders struct {
+	m       sync.Mutex
+	holders map[string][]*lockHolder // key is the container name
+}
+
+func newLockHolders() *lockHolders {
+	return &lockHolders{
+		holders: map[string][]*lockHolder{},
+	}
+}
+
+// add records the holder of the lock of the container, and returns the function
+// to remove the record.
+func (l *lockHolders) add(name, test string, exclusive bool) func() {
+	h := &lockHolder{
+		test:      test,
+		exclusive: exclusive,
+		since:     time.Now(),
+	}
+	l.m.Lock()
+	l.holders[name] = append(l.holders[name], h)
+	l.m.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.m.Lock()
+			defer l.m.Unlock()
+			holders := l.holders[name]
+			for i, v := range holders {
+				if v == h {
+					l.holders[name] = append(holders[:i:i], holders[i+1:]...)
+					break
+				}
+			}
+			if len(l.holders[name]) == 0 {
+				delete(l.holders, name)
+			}
+		})
+	}
+}
+
+// describe returns the description of current holders of the lock of the container.
+func (l *lockHolders) describe(name string) string {
+	l.m.Lock()
+	defer l.m.Unlock()
+	holders := l.holders[name]
+	if len(holders) == 0 {
+		return "no holders in this process"
+	}
+	s := make([]string, 0, len(holders))
+	for _, h := range holders {
+		s = append(s, h.String())
+	}
+	sort.Strings(s)
+	return "held by " + strings.Join(s, ", ")
+}
+
+// exclusive reports whether the exclusive lock of the container is held in this process.
+func (l *lockHolders) exclusive(name string) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, h := range l.holders[name] {
+		if h.exclusive {
+			return true
+		}
+	}
+	return false
+}
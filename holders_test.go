@@ -0,0 +1,44 @@
+package confort
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLockHolders(t *testing.T) {
+	t.Parallel()
+
+	l := newLockHolders()
+	if s := l.describe("container"); s != "no holders in this process" {
+		t.Fatalf("unexpected description: %s", s)
+	}
+
+	removeA := l.add("container", "TestA", false)
+	if l.exclusive("container") {
+		t.Fatal("exclusive lock is not held")
+	}
+	removeB := l.add("container", "", true)
+	if !l.exclusive("container") {
+		t.Fatal("exclusive lock is held")
+	}
+	s := l.describe("container")
+	if !strings.HasPrefix(s, "held by ") ||
+		!strings.Contains(s, "TestA[shared, ") ||
+		!strings.Contains(s, "(unknown test)[exclusive, ") {
+		t.Fatalf("unexpected description: %s", s)
+	}
+
+	removeA()
+	removeA() // no-op
+	s = l.describe("container")
+	if strings.Contains(s, "TestA") || !strings.Contains(s, "(unknown test)") {
+		t.Fatalf("unexpected description: %s", s)
+	}
+	removeB()
+	if l.exclusive("container") {
+		t.Fatal("exclusive lock is released")
+	}
+	if s := l.describe("container"); s != "no holders in this process" {
+		t.Fatalf("unexpected description: %s", s)
+	}
+}
@@ -7,4 +7,6 @@ const (
 	NamespaceEnv      = "CFT_NAMESPACE"
 	ResourcePolicyEnv = "CFT_RESOURCE_POLICY"
 	LogLevelEnv       = "CFT_LOG_LEVEL"
+	CoverDirEnv       = "CFT_COVERDIR"
+	GoCommandEnv      = "CFT_GO_COMMAND"
 )
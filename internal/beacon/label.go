@@ -8,6 +8,14 @@ import (
 
 const (
 	LabelIdentifier = "daichitakahashi.confort.beacon.identifier"
+	// LabelCoverage is attached to the container which writes the coverage data.
+	// The container should be stopped gracefully before removal.
+	LabelCoverage = "daichitakahashi.confort.coverage"
+	// LabelSnapshot is attached to the image committed as the snapshot of the container.
+	LabelSnapshot = "daichitakahashi.confort.snapshot"
+	// LabelSnapshotDir is attached to the snapshot image, and holds the directory
+	// of the archives of the volumes, which should be removed with the image.
+	LabelSnapshotDir = "daichitakahashi.confort.snapshot.dir"
 )
 
 func Identifier(s string) string {
@@ -26,6 +26,8 @@ type BeaconServiceClient interface {
 	LockForNamespace(ctx context.Context, opts ...grpc.CallOption) (BeaconService_LockForNamespaceClient, error)
 	LockForBuild(ctx context.Context, opts ...grpc.CallOption) (BeaconService_LockForBuildClient, error)
 	LockForContainerSetup(ctx context.Context, opts ...grpc.CallOption) (BeaconService_LockForContainerSetupClient, error)
+	LockForPull(ctx context.Context, opts ...grpc.CallOption) (BeaconService_LockForPullClient, error)
+	LockForContainerRecreate(ctx context.Context, opts ...grpc.CallOption) (BeaconService_LockForContainerRecreateClient, error)
 	AcquireContainerLock(ctx context.Context, opts ...grpc.CallOption) (BeaconService_AcquireContainerLockClient, error)
 	Interrupt(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
 }
@@ -131,8 +133,70 @@ func (x *beaconServiceLockForContainerSetupClient) Recv() (*LockResponse, error)
 	return m, nil
 }
 
+func (c *beaconServiceClient) LockForPull(ctx context.Context, opts ...grpc.CallOption) (BeaconService_LockForPullClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BeaconService_ServiceDesc.Streams[3], "/proto.BeaconService/LockForPull", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &beaconServiceLockForPullClient{stream}
+	return x, nil
+}
+
+type BeaconService_LockForPullClient interface {
+	Send(*KeyedLockRequest) error
+	Recv() (*LockResponse, error)
+	grpc.ClientStream
+}
+
+type beaconServiceLockForPullClient struct {
+	grpc.ClientStream
+}
+
+func (x *beaconServiceLockForPullClient) Send(m *KeyedLockRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *beaconServiceLockForPullClient) Recv() (*LockResponse, error) {
+	m := new(LockResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *beaconServiceClient) LockForContainerRecreate(ctx context.Context, opts ...grpc.CallOption) (BeaconService_LockForContainerRecreateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BeaconService_ServiceDesc.Streams[4], "/proto.BeaconService/LockForContainerRecreate", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &beaconServiceLockForContainerRecreateClient{stream}
+	return x, nil
+}
+
+type BeaconService_LockForContainerRecreateClient interface {
+	Send(*KeyedLockRequest) error
+	Recv() (*LockResponse, error)
+	grpc.ClientStream
+}
+
+type beaconServiceLockForContainerRecreateClient struct {
+	grpc.ClientStream
+}
+
+func (x *beaconServiceLockForContainerRecreateClient) Send(m *KeyedLockRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *beaconServiceLockForContainerRecreateClient) Recv() (*LockResponse, error) {
+	m := new(LockResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *beaconServiceClient) AcquireContainerLock(ctx context.Context, opts ...grpc.CallOption) (BeaconService_AcquireContainerLockClient, error) {
-	stream, err := c.cc.NewStream(ctx, &BeaconService_ServiceDesc.Streams[3], "/proto.BeaconService/AcquireContainerLock", opts...)
+	stream, err := c.cc.NewStream(ctx, &BeaconService_ServiceDesc.Streams[5], "/proto.BeaconService/AcquireContainerLock", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -178,6 +242,8 @@ type BeaconServiceServer interface {
 	LockForNamespace(BeaconService_LockForNamespaceServer) error
 	LockForBuild(BeaconService_LockForBuildServer) error
 	LockForContainerSetup(BeaconService_LockForContainerSetupServer) error
+	LockForPull(BeaconService_LockForPullServer) error
+	LockForContainerRecreate(BeaconService_LockForContainerRecreateServer) error
 	AcquireContainerLock(BeaconService_AcquireContainerLockServer) error
 	Interrupt(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
 	mustEmbedUnimplementedBeaconServiceServer()
@@ -196,6 +262,12 @@ func (UnimplementedBeaconServiceServer) LockForBuild(BeaconService_LockForBuildS
 func (UnimplementedBeaconServiceServer) LockForContainerSetup(BeaconService_LockForContainerSetupServer) error {
 	return status.Errorf(codes.Unimplemented, "method LockForContainerSetup not implemented")
 }
+func (UnimplementedBeaconServiceServer) LockForPull(BeaconService_LockForPullServer) error {
+	return status.Errorf(codes.Unimplemented, "method LockForPull not implemented")
+}
+func (UnimplementedBeaconServiceServer) LockForContainerRecreate(BeaconService_LockForContainerRecreateServer) error {
+	return status.Errorf(codes.Unimplemented, "method LockForContainerRecreate not implemented")
+}
 func (UnimplementedBeaconServiceServer) AcquireContainerLock(BeaconService_AcquireContainerLockServer) error {
 	return status.Errorf(codes.Unimplemented, "method AcquireContainerLock not implemented")
 }
@@ -293,6 +365,58 @@ func (x *beaconServiceLockForContainerSetupServer) Recv() (*KeyedLockRequest, er
 	return m, nil
 }
 
+func _BeaconService_LockForPull_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BeaconServiceServer).LockForPull(&beaconServiceLockForPullServer{stream})
+}
+
+type BeaconService_LockForPullServer interface {
+	Send(*LockResponse) error
+	Recv() (*KeyedLockRequest, error)
+	grpc.ServerStream
+}
+
+type beaconServiceLockForPullServer struct {
+	grpc.ServerStream
+}
+
+func (x *beaconServiceLockForPullServer) Send(m *LockResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *beaconServiceLockForPullServer) Recv() (*KeyedLockRequest, error) {
+	m := new(KeyedLockRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _BeaconService_LockForContainerRecreate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BeaconServiceServer).LockForContainerRecreate(&beaconServiceLockForContainerRecreateServer{stream})
+}
+
+type BeaconService_LockForContainerRecreateServer interface {
+	Send(*LockResponse) error
+	Recv() (*KeyedLockRequest, error)
+	grpc.ServerStream
+}
+
+type beaconServiceLockForContainerRecreateServer struct {
+	grpc.ServerStream
+}
+
+func (x *beaconServiceLockForContainerRecreateServer) Send(m *LockResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *beaconServiceLockForContainerRecreateServer) Recv() (*KeyedLockRequest, error) {
+	m := new(KeyedLockRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _BeaconService_AcquireContainerLock_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(BeaconServiceServer).AcquireContainerLock(&beaconServiceAcquireContainerLockServer{stream})
 }
@@ -368,6 +492,18 @@ var BeaconService_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "LockForPull",
+			Handler:       _BeaconService_LockForPull_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "LockForContainerRecreate",
+			Handler:       _BeaconService_LockForContainerRecreate_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "AcquireContainerLock",
 			Handler:       _BeaconService_AcquireContainerLock_Handler,
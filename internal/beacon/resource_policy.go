@@ -5,11 +5,12 @@ const (
 	ResourcePolicyReuse    = "reuse"
 	ResourcePolicyReusable = "reusable"
 	ResourcePolicyTakeOver = "takeover"
+	ResourcePolicyRecreate = "recreate"
 )
 
 func ValidResourcePolicy(s string) bool {
 	switch s {
-	case ResourcePolicyError, ResourcePolicyReuse, ResourcePolicyReusable, ResourcePolicyTakeOver:
+	case ResourcePolicyError, ResourcePolicyReuse, ResourcePolicyReusable, ResourcePolicyTakeOver, ResourcePolicyRecreate:
 		return true
 	default:
 		return false
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"io"
 
 	"github.com/daichitakahashi/confort/internal/beacon/proto"
@@ -174,6 +175,119 @@ func (b *beaconServer) LockForContainerSetup(stream proto.BeaconService_LockForC
 	}
 }
 
+func (b *beaconServer) LockForPull(stream proto.BeaconService_LockForPullServer) error {
+	ctx := stream.Context()
+	var key string
+	var unlock func()
+	defer func() {
+		if unlock != nil {
+			unlock()
+		}
+	}()
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k := req.GetKey()
+		if k == "" {
+			return status.Error(codes.InvalidArgument, "empty key")
+		}
+
+		switch req.GetOperation() {
+		case proto.LockOp_LOCK_OP_LOCK:
+			if unlock != nil {
+				return status.Error(codes.InvalidArgument, "trying second lock")
+			}
+			key = k
+			unlock, err = b.l.LockForPull(ctx, key)
+			if err != nil {
+				return err
+			}
+			err = stream.Send(&proto.LockResponse{
+				State: proto.LockState_LOCK_STATE_LOCKED,
+			})
+			if err != nil {
+				return err
+			}
+		case proto.LockOp_LOCK_OP_UNLOCK:
+			if unlock == nil || k != key {
+				return status.Error(codes.InvalidArgument, "unlock on unlocked key")
+			}
+			unlock()
+			key = ""
+			unlock = nil
+			err = stream.Send(&proto.LockResponse{
+				State: proto.LockState_LOCK_STATE_UNLOCKED,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (b *beaconServer) LockForContainerRecreate(stream proto.BeaconService_LockForContainerRecreateServer) error {
+	var key string
+	var unlock func()
+	defer func() {
+		if unlock != nil {
+			unlock()
+		}
+	}()
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k := req.GetKey()
+		if k == "" {
+			return status.Error(codes.InvalidArgument, "empty key")
+		}
+
+		switch req.GetOperation() {
+		case proto.LockOp_LOCK_OP_LOCK:
+			if unlock != nil {
+				return status.Error(codes.InvalidArgument, "trying second lock")
+			}
+			key = k
+			unlock, err = b.l.LockForContainerRecreate(key)
+			if errors.Is(err, exclusion.ErrContainerInUse) {
+				return status.Error(codes.FailedPrecondition, err.Error())
+			} else if err != nil {
+				return err
+			}
+			err = stream.Send(&proto.LockResponse{
+				State: proto.LockState_LOCK_STATE_LOCKED,
+			})
+			if err != nil {
+				return err
+			}
+		case proto.LockOp_LOCK_OP_UNLOCK:
+			if unlock == nil || k != key {
+				return status.Error(codes.InvalidArgument, "unlock on unlocked key")
+			}
+			unlock()
+			key = ""
+			unlock = nil
+			err = stream.Send(&proto.LockResponse{
+				State: proto.LockState_LOCK_STATE_UNLOCKED,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
 func (b *beaconServer) AcquireContainerLock(stream proto.BeaconService_AcquireContainerLockServer) error {
 	ctx := stream.Context()
 
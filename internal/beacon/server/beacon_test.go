@@ -236,6 +236,140 @@ func TestBeaconServer_LockForContainerSetup_Error(t *testing.T) {
 	})
 }
 
+func TestBeaconServer_LockForPull_Error(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	connect := startServer(t, nil)
+	cli := proto.NewBeaconServiceClient(connect(t))
+
+	t.Run("empty key", func(t *testing.T) {
+		t.Parallel()
+
+		stream, err := cli.LockForPull(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = stream.CloseSend()
+		})
+
+		resp, err := keyedLock(t, stream, "", proto.LockOp_LOCK_OP_LOCK)
+		if err == nil {
+			t.Fatal("error expected but succeeded:", resp)
+		}
+	})
+	t.Run("trying second lock", func(t *testing.T) {
+		t.Parallel()
+
+		stream, err := cli.LockForPull(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = stream.CloseSend()
+		})
+
+		image := uniq.Must(t)
+		resp, err := keyedLock(t, stream, image, proto.LockOp_LOCK_OP_LOCK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetState() != proto.LockState_LOCK_STATE_LOCKED {
+			t.Fatalf("not locked: %s", resp.GetState())
+		}
+
+		resp, err = keyedLock(t, stream, image, proto.LockOp_LOCK_OP_LOCK)
+		if err == nil {
+			t.Fatal("error expected but succeeded:", resp)
+		}
+	})
+	t.Run("unlock of unlocked", func(t *testing.T) {
+		t.Parallel()
+
+		stream, err := cli.LockForPull(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = stream.CloseSend()
+		})
+
+		image := uniq.Must(t)
+		resp, err := keyedLock(t, stream, image, proto.LockOp_LOCK_OP_UNLOCK)
+		if err == nil {
+			t.Fatal("error expected but succeeded:", resp)
+		}
+	})
+}
+
+func TestBeaconServer_LockForContainerRecreate_Error(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	connect := startServer(t, nil)
+	cli := proto.NewBeaconServiceClient(connect(t))
+
+	t.Run("empty key", func(t *testing.T) {
+		t.Parallel()
+
+		stream, err := cli.LockForContainerRecreate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = stream.CloseSend()
+		})
+
+		resp, err := keyedLock(t, stream, "", proto.LockOp_LOCK_OP_LOCK)
+		if err == nil {
+			t.Fatal("error expected but succeeded:", resp)
+		}
+	})
+	t.Run("trying second lock", func(t *testing.T) {
+		t.Parallel()
+
+		stream, err := cli.LockForContainerRecreate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = stream.CloseSend()
+		})
+
+		name := uniq.Must(t)
+		resp, err := keyedLock(t, stream, name, proto.LockOp_LOCK_OP_LOCK)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetState() != proto.LockState_LOCK_STATE_LOCKED {
+			t.Fatalf("not locked: %s", resp.GetState())
+		}
+
+		resp, err = keyedLock(t, stream, name, proto.LockOp_LOCK_OP_LOCK)
+		if err == nil {
+			t.Fatal("error expected but succeeded:", resp)
+		}
+	})
+	t.Run("unlock of unlocked", func(t *testing.T) {
+		t.Parallel()
+
+		stream, err := cli.LockForContainerRecreate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = stream.CloseSend()
+		})
+
+		name := uniq.Must(t)
+		resp, err := keyedLock(t, stream, name, proto.LockOp_LOCK_OP_UNLOCK)
+		if err == nil {
+			t.Fatal("error expected but succeeded:", resp)
+		}
+	})
+}
+
 func TestBeaconServer_AcquireContainerLock(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
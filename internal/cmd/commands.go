@@ -5,14 +5,17 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"github.com/daichitakahashi/confort/internal/beacon"
+	"github.com/daichitakahashi/confort/internal/gocommand"
 	"github.com/daichitakahashi/gocmd"
 	"github.com/google/subcommands"
 )
@@ -31,6 +34,9 @@ func NewCommands(set *flag.FlagSet, op Operation) *subcommands.Commander {
 	cmd.Register(&TestCommand{
 		Operation: op,
 	}, "")
+	cmd.Register(&ExportCommand{
+		Operation: op,
+	}, "")
 	return cmd
 }
 
@@ -193,6 +199,7 @@ type TestCommand struct {
 	policy    resourcePolicy
 	goVer     string
 	goMode    goMode
+	coverDir  string
 }
 
 func (t *TestCommand) Name() string {
@@ -204,7 +211,7 @@ func (t *TestCommand) Synopsis() string {
 }
 
 func (t *TestCommand) Usage() string {
-	return `$ confort test (-namespace <namespace> -policy <resource policy> -go <go version> -go-mode <mode>) (-- -p=4 -shuffle=on)
+	return `$ confort test (-namespace <namespace> -policy <resource policy> -go <go version> -go-mode <mode> -coverdir <dir>) (-- -p=4 -shuffle=on)
 
 Start the beacon server and execute tests.
 After the tests are finished, the beacon server will be stopped automatically.
@@ -220,13 +227,16 @@ func (t *TestCommand) SetFlags(f *flag.FlagSet) {
   * With "error", the existing same resource(network and container) makes test failed
   * With "reuse", tests reuse resources if already exist
   * "reusable" is similar to "reuse", but created resources with this policy will not be removed after the tests finished
-  * "takeover" is also similar to "reuse", but reused resources with this policy will be removed after the tests`)
+  * "takeover" is also similar to "reuse", but reused resources with this policy will be removed after the tests
+  * "recreate" is also similar to "reuse", but the existing container is recreated if its configurations are changed`)
 	f.StringVar(&t.goVer, "go", "", `specify go version. "-go=mod" enables to use go version written in your go.mod`)
 	t.goMode = goMode(gocmd.ModeFallback)
 	f.Var(&t.goMode, "go-mode", `use with -go option
   * "exact" finds go command that has the exact same version as given in "-go"
   * "latest" finds go command that has the same major version as given in "-go"
   * "fallback" behaves like "latest", but if no command was found, fallbacks to "go" command`)
+	f.StringVar(&t.coverDir, "coverdir", "", `directory to collect the coverage data of the containers created with "confort.WithCoverage"
+  The data is merged into the directory after the tests finished`)
 }
 
 func (t *TestCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
@@ -266,6 +276,16 @@ func (t *TestCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interfa
 	}
 	env = append(env, fmt.Sprintf("%s=%s", beacon.ResourcePolicyEnv, t.policy))
 	env = append(env, fmt.Sprintf("%s=%s", beacon.IdentifierEnv, identifier))
+	env = append(env, fmt.Sprintf("%s=%s", beacon.GoCommandEnv, goCmd))
+	var coverDir string
+	if t.coverDir != "" {
+		coverDir, err = filepath.Abs(t.coverDir)
+		if err != nil {
+			log.Println(err)
+			return subcommands.ExitFailure
+		}
+		env = append(env, fmt.Sprintf("%s=%s", beacon.CoverDirEnv, coverDir))
+	}
 
 	// trap signal for graceful shutdown
 	signal.Notify(
@@ -295,26 +315,116 @@ func (t *TestCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interfa
 		}
 	}
 
+	if coverDir != "" {
+		// merge the coverage data written by the stopped containers
+		err = t.Operation.MergeCoverage(ctx, goCmd, coverDir)
+		if err != nil {
+			log.Println("failed to merge coverage data:", err)
+			return subcommands.ExitFailure
+		}
+	}
+
 	return status
 }
 
 func (t *TestCommand) determineGoCommand() (string, string, error) {
-	switch t.goVer {
-	case "":
-		goVer, err := gocmd.CurrentVersion()
-		return "go", goVer, err
-	case "mod":
-		modVer, err := gocmd.ModuleGoVersion()
+	return gocommand.Determine(t.goVer, gocmd.Mode(t.goMode))
+}
+
+var _ subcommands.Command = (*TestCommand)(nil)
+
+type ExportCommand struct {
+	Operation Operation
+
+	// flags
+	namespace  string
+	identifier string
+	output     string
+}
+
+func (e *ExportCommand) Name() string {
+	return "export"
+}
+
+func (e *ExportCommand) Synopsis() string {
+	return `Export containers in the namespace as a compose file.`
+}
+
+func (e *ExportCommand) Usage() string {
+	return `$ confort export -namespace <namespace> (-identifier <identifier> -o <filename>)
+
+Inspect the network and the containers belonging to the namespace, and output an equivalent compose file.
+The result is written to stdout by default. Use "-o" option to write it into the file.
+
+By using "-identifier" option, only the containers created with the identifier are exported.
+The identifier is the value of the label "` + beacon.LabelIdentifier + `".
+
+`
+}
+
+func (e *ExportCommand) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&e.namespace, "namespace", os.Getenv(beacon.NamespaceEnv), "namespace")
+	f.StringVar(&e.identifier, "identifier", os.Getenv(beacon.IdentifierEnv), "identifier of the containers to export")
+	f.StringVar(&e.output, "o", "", "filename to write the compose file into")
+}
+
+func (e *ExportCommand) Execute(ctx context.Context, _ *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if e.namespace == "" {
+		log.Println("namespace is required")
+		return subcommands.ExitUsageError
+	}
+
+	var label string
+	if e.identifier != "" {
+		label = beacon.LabelIdentifier
+	}
+
+	export := func(w io.Writer) error {
+		return e.Operation.ExportCompose(ctx, e.namespace, label, e.identifier, w)
+	}
+	var err error
+	if e.output != "" {
+		err = writeFileAtomic(e.output, export)
+	} else {
+		err = export(os.Stdout)
+	}
+	if err != nil {
+		log.Println("failed to export namespace:", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// writeFileAtomic writes the file via the temporary file in the same directory,
+// so that the partial file is not left at filename when write fails.
+func writeFileAtomic(filename string, write func(w io.Writer) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
 		if err != nil {
-			return "", "", fmt.Errorf("failed to read go.mod: %w", err)
+			_ = f.Close()
+			_ = os.Remove(f.Name())
 		}
-		return gocmd.Determine(modVer, gocmd.Mode(t.goMode))
-	default:
-		return gocmd.Determine(t.goVer, gocmd.Mode(t.goMode))
+	}()
+
+	err = write(f)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(0644)
+	if err != nil {
+		return err
 	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
 }
 
-var _ subcommands.Command = (*TestCommand)(nil)
+var _ subcommands.Command = (*ExportCommand)(nil)
 
 type resourcePolicy string
 
@@ -3,7 +3,10 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
+	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -11,6 +14,7 @@ import (
 
 	"github.com/daichitakahashi/confort/internal/beacon"
 	"github.com/daichitakahashi/gocmd"
+	"github.com/google/subcommands"
 )
 
 func assert(t *testing.T, typ, want, got string) {
@@ -42,6 +46,7 @@ func TestNewCommands_Help(t *testing.T) {
 		{"help", "start"},
 		{"help", "stop"},
 		{"help", "test"},
+		{"help", "export"},
 	}
 
 	for _, args := range arguments {
@@ -149,6 +154,7 @@ func TestResourcePolicy(t *testing.T) {
 		beacon.ResourcePolicyError,
 		beacon.ResourcePolicyReuse,
 		beacon.ResourcePolicyTakeOver,
+		beacon.ResourcePolicyRecreate,
 	}
 
 	for _, p := range policies {
@@ -206,3 +212,64 @@ func TestGoMode(t *testing.T) {
 		})
 	}
 }
+
+type exportOperation struct {
+	Operation
+	err error
+}
+
+func (o *exportOperation) ExportCompose(_ context.Context, namespace, _, _ string, w io.Writer) error {
+	_, _ = io.WriteString(w, "name: "+namespace+"\n")
+	return o.err
+}
+
+func TestExportCommand_output(t *testing.T) {
+	t.Parallel()
+
+	execute := func(t *testing.T, op Operation, output string) subcommands.ExitStatus {
+		t.Helper()
+		f := flag.NewFlagSet("confort", flag.ContinueOnError)
+		cmd := NewCommands(f, op)
+		err := f.Parse([]string{"export", "-namespace", "ns", "-o", output})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmd.Execute(context.Background())
+	}
+	dir := t.TempDir()
+	output := filepath.Join(dir, "compose.yaml")
+
+	code := execute(t, &exportOperation{}, output)
+	if code != subcommands.ExitSuccess {
+		t.Fatalf("unexpected exit code: %d", code)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, "output", "name: ns\n", string(data))
+
+	// the existing file is kept on failure
+	code = execute(t, &exportOperation{err: errors.New("failed")}, output)
+	if code != subcommands.ExitFailure {
+		t.Fatalf("unexpected exit code: %d", code)
+	}
+	data, err = os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, "output", "name: ns\n", string(data))
+
+	// no partial file is left
+	code = execute(t, &exportOperation{err: errors.New("failed")}, filepath.Join(dir, "failed.yaml"))
+	if code != subcommands.ExitFailure {
+		t.Fatalf("unexpected exit code: %d", code)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("unexpected files are left: %v", entries)
+	}
+}
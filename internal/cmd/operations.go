@@ -3,17 +3,24 @@ package cmd
 import (
 	"context"
 	"errors"
+	"io"
 	"log"
 	"net"
 	"os"
 	"os/exec"
 	"time"
 
+	"github.com/daichitakahashi/confort/internal/beacon"
 	"github.com/daichitakahashi/confort/internal/beacon/proto"
 	"github.com/daichitakahashi/confort/internal/beacon/server"
+	"github.com/daichitakahashi/confort/internal/compose"
+	"github.com/daichitakahashi/confort/internal/coverage"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/lestrrat-go/backoff/v2"
 	"go.uber.org/multierr"
 	"google.golang.org/grpc"
@@ -27,6 +34,8 @@ type Operation interface {
 	StopBeaconServer(ctx context.Context, addr string) error
 	CleanupResources(ctx context.Context, label, value string) error
 	ExecuteTest(ctx context.Context, goCmd string, args []string, environments []string) error
+	MergeCoverage(ctx context.Context, goCmd, dir string) error
+	ExportCompose(ctx context.Context, namespace, label, value string, w io.Writer) error
 }
 
 type operation struct {
@@ -143,6 +152,13 @@ func (o *operation) CleanupResources(ctx context.Context, label, value string) e
 		errs = append(errs, err)
 	}
 	for _, c := range containers {
+		if _, ok := c.Labels[beacon.LabelCoverage]; ok {
+			// stop gracefully to make the process write the coverage data
+			err := cli.ContainerStop(ctx, c.ID, container.StopOptions{})
+			if err != nil && !errdefs.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+		}
 		err := cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{
 			RemoveVolumes: true,
 			Force:         true,
@@ -152,6 +168,32 @@ func (o *operation) CleanupResources(ctx context.Context, label, value string) e
 		}
 	}
 
+	// remove snapshot images and the archives of their volumes
+	images, err := cli.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", label+"="+value),
+			filters.Arg("label", beacon.LabelSnapshot),
+		),
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, img := range images {
+		if dir := img.Labels[beacon.LabelSnapshotDir]; dir != "" {
+			err := os.RemoveAll(dir)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		_, err := cli.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{
+			Force:         true,
+			PruneChildren: true,
+		})
+		if err != nil && !errdefs.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+
 	// remove network
 	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{
 		Filters: f,
@@ -166,6 +208,18 @@ func (o *operation) CleanupResources(ctx context.Context, label, value string) e
 		}
 	}
 
+	// remove volume
+	volumes, err := cli.VolumeList(ctx, volume.ListOptions{Filters: f})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, v := range volumes.Volumes {
+		err := cli.VolumeRemove(ctx, v.Name, true)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	return multierr.Combine(errs...)
 }
 
@@ -177,4 +231,22 @@ func (o *operation) ExecuteTest(ctx context.Context, goCmd string, args, environ
 	return cmd.Run()
 }
 
+func (o *operation) MergeCoverage(ctx context.Context, goCmd, dir string) error {
+	return coverage.Merge(ctx, goCmd, dir)
+}
+
+func (o *operation) ExportCompose(ctx context.Context, namespace, label, value string, w io.Writer) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	cli.NegotiateAPIVersion(ctx)
+
+	p, err := compose.Export(ctx, cli, namespace, label, value)
+	if err != nil {
+		return err
+	}
+	return p.Write(w)
+}
+
 var _ Operation = (*operation)(nil)
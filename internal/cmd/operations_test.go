@@ -10,6 +10,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
@@ -111,6 +112,13 @@ func TestOperation_CleanupResources(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// create volume
+	cmd = exec.Command("docker", "volume", "create", "--label", "confort=hoge", uuid.NewString())
+	err = cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// do cleanup
 	err = op.CleanupResources(ctx, "confort", "hoge")
 	if err != nil {
@@ -155,6 +163,14 @@ func TestOperation_CleanupResources(t *testing.T) {
 	if len(networks) > 0 {
 		t.Error("network is not removed")
 	}
+
+	volumes, err := cli.VolumeList(ctx, volume.ListOptions{Filters: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes.Volumes) > 0 {
+		t.Error("volume is not removed")
+	}
 }
 
 func TestExecuteProcess(t *testing.T) {
@@ -0,0 +1,552 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Project is the subset of the compose file specification which confort can handle.
+type Project struct {
+	Name     string              `yaml:"name,omitempty"`
+	Version  string              `yaml:"version,omitempty"`
+	Services map[string]*Service `yaml:"services"`
+	Volumes  map[string]*Volume  `yaml:"volumes,omitempty"`
+	Networks map[string]*Network `yaml:"networks,omitempty"`
+}
+
+type Service struct {
+	Image           string       `yaml:"image,omitempty"`
+	Command         ShellCommand `yaml:"command,omitempty"`
+	Entrypoint      ShellCommand `yaml:"entrypoint,omitempty"`
+	WorkingDir      string       `yaml:"working_dir,omitempty"`
+	User            string       `yaml:"user,omitempty"`
+	Hostname        string       `yaml:"hostname,omitempty"`
+	Environment     Environment  `yaml:"environment,omitempty"`
+	Labels          Labels       `yaml:"labels,omitempty"`
+	Ports           []Port       `yaml:"ports,omitempty"`
+	Expose          []string     `yaml:"expose,omitempty"`
+	Healthcheck     *Healthcheck `yaml:"healthcheck,omitempty"`
+	Volumes         []Mount      `yaml:"volumes,omitempty"`
+	Tmpfs           StringList   `yaml:"tmpfs,omitempty"`
+	DependsOn       DependsOn    `yaml:"depends_on,omitempty"`
+	StopGracePeriod *Duration    `yaml:"stop_grace_period,omitempty"`
+	StopSignal      string       `yaml:"stop_signal,omitempty"`
+	Privileged      bool         `yaml:"privileged,omitempty"`
+	CapAdd          []string     `yaml:"cap_add,omitempty"`
+	CapDrop         []string     `yaml:"cap_drop,omitempty"`
+	ExtraHosts      ExtraHosts   `yaml:"extra_hosts,omitempty"`
+	Networks        Networks     `yaml:"networks,omitempty"`
+}
+
+type Volume struct {
+	Name     string `yaml:"name,omitempty"`
+	External bool   `yaml:"external,omitempty"`
+}
+
+type Network struct {
+	Name     string `yaml:"name,omitempty"`
+	External bool   `yaml:"external,omitempty"`
+}
+
+// Networks is a set of networks which the service joins, written as a list of
+// network names or a mapping.
+type Networks map[string]*ServiceNetwork
+
+type ServiceNetwork struct {
+	Aliases []string `yaml:"aliases,omitempty"`
+}
+
+func (n *Networks) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		networks := make(Networks, len(list))
+		for _, name := range list {
+			networks[name] = nil
+		}
+		*n = networks
+		return nil
+	}
+	var m map[string]*ServiceNetwork
+	if err := node.Decode(&m); err != nil {
+		return err
+	}
+	*n = m
+	return nil
+}
+
+// ShellCommand is a command line that is written as a string or a list of strings.
+// The string form is split into arguments like shell.
+type ShellCommand []string
+
+func (c *ShellCommand) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		args, err := splitCommand(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		*c = args
+		return nil
+	}
+	var args []string
+	if err := node.Decode(&args); err != nil {
+		return err
+	}
+	*c = args
+	return nil
+}
+
+// Environment is a set of environment variables that is written as a mapping
+// or a list of "KEY=VALUE". A nil value means that the variable is taken from
+// the current environment.
+type Environment map[string]*string
+
+func (e *Environment) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		env := make(Environment, len(list))
+		for _, kv := range list {
+			k, v, ok := strings.Cut(kv, "=")
+			if ok {
+				v := v
+				env[k] = &v
+			} else {
+				env[k] = nil
+			}
+		}
+		*e = env
+		return nil
+	}
+	var m map[string]*string
+	if err := node.Decode(&m); err != nil {
+		return err
+	}
+	*e = m
+	return nil
+}
+
+// Labels is a set of labels that is written as a mapping or a list of "KEY=VALUE".
+type Labels map[string]string
+
+func (l *Labels) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		labels := make(Labels, len(list))
+		for _, kv := range list {
+			k, v, _ := strings.Cut(kv, "=")
+			labels[k] = v
+		}
+		*l = labels
+		return nil
+	}
+	var m map[string]string
+	if err := node.Decode(&m); err != nil {
+		return err
+	}
+	*l = m
+	return nil
+}
+
+// StringList is a list of strings that can be written as a single string.
+type StringList []string
+
+func (s *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = []string{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// ExtraHosts is a list of "HOST:IP" that can be written as a mapping.
+type ExtraHosts []string
+
+func (h *ExtraHosts) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var m map[string]string
+		if err := node.Decode(&m); err != nil {
+			return err
+		}
+		hosts := make(ExtraHosts, 0, len(m))
+		for host, ip := range m {
+			hosts = append(hosts, host+":"+ip)
+		}
+		sort.Strings(hosts)
+		*h = hosts
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*h = list
+	return nil
+}
+
+// Duration is a duration written as a string like "1m30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+// Port is a port mapping written in the short syntax("[HOST:]CONTAINER[/PROTOCOL]")
+// or the long syntax.
+type Port struct {
+	Target    string `yaml:"target"`
+	Published string `yaml:"published,omitempty"`
+	HostIP    string `yaml:"host_ip,omitempty"`
+	Protocol  string `yaml:"protocol,omitempty"`
+
+	short string
+}
+
+func (p *Port) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = Port{short: node.Value}
+		return nil
+	}
+	type port Port
+	var v port
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*p = Port(v)
+	return nil
+}
+
+func (p Port) MarshalYAML() (any, error) {
+	if p.short != "" {
+		return p.short, nil
+	}
+	type port Port
+	return port(p), nil
+}
+
+// Spec returns the port specification in the format of "[IP:][HOST:]CONTAINER[/PROTOCOL]".
+func (p Port) Spec() string {
+	if p.short != "" {
+		return p.short
+	}
+	spec := p.Target
+	if p.Published != "" {
+		spec = p.Published + ":" + spec
+		if p.HostIP != "" {
+			spec = p.HostIP + ":" + spec
+		}
+	} else if p.HostIP != "" {
+		spec = p.HostIP + "::" + spec
+	}
+	if p.Protocol != "" {
+		spec += "/" + p.Protocol
+	}
+	return spec
+}
+
+// Mount is a volume mount of the service written in the short syntax
+// ("[SOURCE:]TARGET[:MODE]") or the long syntax.
+type Mount struct {
+	Type     string         `yaml:"type"`
+	Source   string         `yaml:"source,omitempty"`
+	Target   string         `yaml:"target"`
+	ReadOnly bool           `yaml:"read_only,omitempty"`
+	Bind     *BindOptions   `yaml:"bind,omitempty"`
+	Volume   *VolumeOptions `yaml:"volume,omitempty"`
+	Tmpfs    *TmpfsOptions  `yaml:"tmpfs,omitempty"`
+}
+
+type BindOptions struct {
+	Propagation string `yaml:"propagation,omitempty"`
+}
+
+type VolumeOptions struct {
+	NoCopy bool `yaml:"nocopy,omitempty"`
+}
+
+type TmpfsOptions struct {
+	Size int64 `yaml:"size,omitempty"`
+}
+
+const (
+	MountTypeBind   = "bind"
+	MountTypeVolume = "volume"
+	MountTypeTmpfs  = "tmpfs"
+)
+
+func (m *Mount) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		v, err := parseShortMount(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		*m = v
+		return nil
+	}
+	type mount Mount
+	var v mount
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*m = Mount(v)
+	return nil
+}
+
+func parseShortMount(s string) (m Mount, err error) {
+	parts := strings.Split(s, ":")
+	switch len(parts) {
+	case 1:
+		m.Target = parts[0]
+	case 2:
+		m.Source, m.Target = parts[0], parts[1]
+	case 3:
+		m.Source, m.Target = parts[0], parts[1]
+		for _, mode := range strings.Split(parts[2], ",") {
+			switch mode {
+			case "ro":
+				m.ReadOnly = true
+			case "rw":
+			case "nocopy":
+				m.Volume = &VolumeOptions{NoCopy: true}
+			default:
+				return m, fmt.Errorf("unsupported volume mode %q: %s", mode, s)
+			}
+		}
+	default:
+		return m, fmt.Errorf("invalid volume specification: %s", s)
+	}
+	switch {
+	case m.Source == "":
+		m.Type = MountTypeVolume
+	case strings.HasPrefix(m.Source, ".") || strings.HasPrefix(m.Source, "/") || strings.HasPrefix(m.Source, "~"):
+		m.Type = MountTypeBind
+	default:
+		m.Type = MountTypeVolume
+	}
+	return m, nil
+}
+
+// Healthcheck is the configuration of the health check of the service.
+type Healthcheck struct {
+	Test        ShellCommand `yaml:"test,omitempty"`
+	Interval    *Duration    `yaml:"interval,omitempty"`
+	Timeout     *Duration    `yaml:"timeout,omitempty"`
+	Retries     *int         `yaml:"retries,omitempty"`
+	StartPeriod *Duration    `yaml:"start_period,omitempty"`
+	Disable     bool         `yaml:"disable,omitempty"`
+
+	shell bool
+}
+
+func (h *Healthcheck) UnmarshalYAML(node *yaml.Node) error {
+	type healthcheck Healthcheck
+	var v healthcheck
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	// string form of "test" is executed by shell
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "test" && node.Content[i+1].Kind == yaml.ScalarNode {
+			v.Test = ShellCommand{node.Content[i+1].Value}
+			v.shell = true
+		}
+	}
+	*h = Healthcheck(v)
+	return nil
+}
+
+// TestCommand returns the test command in the format of container.HealthConfig.
+func (h *Healthcheck) TestCommand() []string {
+	if h.Disable {
+		return []string{"NONE"}
+	}
+	if h.shell {
+		return append([]string{"CMD-SHELL"}, h.Test...)
+	}
+	return h.Test
+}
+
+const (
+	ConditionServiceStarted               = "service_started"
+	ConditionServiceHealthy               = "service_healthy"
+	ConditionServiceCompletedSuccessfully = "service_completed_successfully"
+)
+
+// DependsOn is a set of dependencies of the service, written as a list of
+// service names or a mapping.
+type DependsOn map[string]Dependency
+
+type Dependency struct {
+	Condition string `yaml:"condition,omitempty"`
+}
+
+func (d *DependsOn) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		deps := make(DependsOn, len(list))
+		for _, name := range list {
+			deps[name] = Dependency{
+				Condition: ConditionServiceStarted,
+			}
+		}
+		*d = deps
+		return nil
+	}
+	var m map[string]Dependency
+	if err := node.Decode(&m); err != nil {
+		return err
+	}
+	for name, dep := range m {
+		if dep.Condition == "" {
+			dep.Condition = ConditionServiceStarted
+			m[name] = dep
+		}
+	}
+	*d = m
+	return nil
+}
+
+// UnsupportedKeysError reports keys of the compose file that are not supported.
+type UnsupportedKeysError struct {
+	Keys []string
+}
+
+func (e *UnsupportedKeysError) Error() string {
+	return "unsupported keys: " + strings.Join(e.Keys, ", ")
+}
+
+// Load reads and parses the compose file.
+func Load(filename string) (*Project, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, os.LookupEnv)
+}
+
+// Parse parses the content of the compose file. The variables in the values are
+// interpolated with lookupEnv.
+// If the content contains the keys that Project cannot represent, Parse returns
+// *UnsupportedKeysError that contains all of them.
+func Parse(data []byte, lookupEnv func(string) (string, bool)) (*Project, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, errors.New("empty compose file")
+	}
+	doc := root.Content[0]
+
+	if keys := unsupportedKeys(doc, reflect.TypeOf(Project{}), ""); len(keys) > 0 {
+		return nil, &UnsupportedKeysError{Keys: keys}
+	}
+	if err := interpolateNode(doc, lookupEnv); err != nil {
+		return nil, err
+	}
+
+	var p Project
+	if err := doc.Decode(&p); err != nil {
+		return nil, err
+	}
+	for name, svc := range p.Services {
+		if svc == nil {
+			p.Services[name] = &Service{}
+		}
+	}
+	return &p, nil
+}
+
+// unsupportedKeys walks the node along typ and collects the keys of the mappings
+// which have no corresponding field. Extension fields("x-*") are always allowed.
+func unsupportedKeys(node *yaml.Node, typ reflect.Type, path string) []string {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		return unsupportedKeys(node.Alias, typ, path)
+	}
+	var keys []string
+	switch {
+	case node.Kind == yaml.MappingNode && typ.Kind() == reflect.Struct:
+		fields := map[string]reflect.Type{}
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name != "" && name != "-" {
+				fields[name] = f.Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if strings.HasPrefix(key, "x-") {
+				continue
+			}
+			if key == "<<" { // merge key
+				merged := node.Content[i+1]
+				if merged.Kind == yaml.SequenceNode {
+					for _, n := range merged.Content {
+						keys = append(keys, unsupportedKeys(n, typ, path)...)
+					}
+				} else {
+					keys = append(keys, unsupportedKeys(merged, typ, path)...)
+				}
+				continue
+			}
+			fieldType, ok := fields[key]
+			if !ok {
+				keys = append(keys, joinPath(path, key))
+				continue
+			}
+			keys = append(keys, unsupportedKeys(node.Content[i+1], fieldType, joinPath(path, key))...)
+		}
+	case node.Kind == yaml.MappingNode && typ.Kind() == reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keys = append(keys, unsupportedKeys(node.Content[i+1], typ.Elem(), joinPath(path, node.Content[i].Value))...)
+		}
+	case node.Kind == yaml.SequenceNode && typ.Kind() == reflect.Slice:
+		for i, n := range node.Content {
+			keys = append(keys, unsupportedKeys(n, typ.Elem(), path+"["+strconv.Itoa(i)+"]")...)
+		}
+	}
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,221 @@
+package compose
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func lookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	const data = `
+version: "3.9"
+x-common: &common
+  image: postgres:${PG_VERSION:-14}
+services:
+  db:
+    <<: *common
+    environment:
+      POSTGRES_USER: ${DB_USER}
+      POSTGRES_PASSWORD: "$${NOT_INTERPOLATED}"
+      EMPTY: ${UNSET_VARIABLE}
+    ports:
+      - "5432"
+      - target: 8080
+        published: ${PUBLISHED_PORT}
+        host_ip: 127.0.0.1
+    healthcheck:
+      test: pg_isready
+      interval: 5s
+      retries: 3
+    volumes:
+      - ./data:/var/lib/data:ro
+      - cache:/cache
+      - /anonymous
+      - type: tmpfs
+        target: /tmp
+    stop_grace_period: 1m30s
+  api:
+    image: api:latest
+    command: serve --addr ":80" 'with space'
+    entrypoint: ["/bin/api"]
+    environment:
+      - DEBUG=true
+      - FROM_SHELL
+    expose: [80]
+    privileged: ${PRIVILEGED}
+    depends_on:
+      db:
+        condition: service_healthy
+  worker:
+    image: worker:latest
+    depends_on: [db, api]
+volumes:
+  cache:
+`
+	p, err := Parse([]byte(data), lookupEnv(map[string]string{
+		"DB_USER":        "user",
+		"PUBLISHED_PORT": "18080",
+		"PRIVILEGED":     "true",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	str := func(s string) *string { return &s }
+	dur := func(d time.Duration) *Duration { v := Duration(d); return &v }
+	retries := 3
+	expected := &Project{
+		Version: "3.9",
+		Services: map[string]*Service{
+			"db": {
+				Image: "postgres:14",
+				Environment: Environment{
+					"POSTGRES_USER":     str("user"),
+					"POSTGRES_PASSWORD": str("${NOT_INTERPOLATED}"),
+					"EMPTY":             str(""),
+				},
+				Ports: []Port{
+					{short: "5432"},
+					{Target: "8080", Published: "18080", HostIP: "127.0.0.1"},
+				},
+				Healthcheck: &Healthcheck{
+					Test:     ShellCommand{"pg_isready"},
+					Interval: dur(5 * time.Second),
+					Retries:  &retries,
+					shell:    true,
+				},
+				Volumes: []Mount{
+					{Type: MountTypeBind, Source: "./data", Target: "/var/lib/data", ReadOnly: true},
+					{Type: MountTypeVolume, Source: "cache", Target: "/cache"},
+					{Type: MountTypeVolume, Target: "/anonymous"},
+					{Type: MountTypeTmpfs, Target: "/tmp"},
+				},
+				StopGracePeriod: dur(90 * time.Second),
+			},
+			"api": {
+				Image:      "api:latest",
+				Command:    ShellCommand{"serve", "--addr", ":80", "with space"},
+				Entrypoint: ShellCommand{"/bin/api"},
+				Environment: Environment{
+					"DEBUG":      str("true"),
+					"FROM_SHELL": nil,
+				},
+				Expose:     []string{"80"},
+				Privileged: true,
+				DependsOn: DependsOn{
+					"db": {Condition: ConditionServiceHealthy},
+				},
+			},
+			"worker": {
+				Image: "worker:latest",
+				DependsOn: DependsOn{
+					"db":  {Condition: ConditionServiceStarted},
+					"api": {Condition: ConditionServiceStarted},
+				},
+			},
+		},
+		Volumes: map[string]*Volume{
+			"cache": nil,
+		},
+	}
+	diff := cmp.Diff(expected, p, cmp.AllowUnexported(Port{}, Healthcheck{}))
+	if diff != "" {
+		t.Fatal(diff)
+	}
+
+	if cmd := p.Services["db"].Healthcheck.TestCommand(); !cmp.Equal(cmd, []string{"CMD-SHELL", "pg_isready"}) {
+		t.Fatalf("unexpected test command: %v", cmd)
+	}
+	if spec := p.Services["db"].Ports[1].Spec(); spec != "127.0.0.1:18080:8080" {
+		t.Fatalf("unexpected port spec: %s", spec)
+	}
+}
+
+func TestParse_UnsupportedKeys(t *testing.T) {
+	t.Parallel()
+
+	const data = `
+services:
+  app:
+    build: .
+    image: app
+    networks: [front]
+    healthcheck:
+      test: ["CMD", "true"]
+      unknown: value
+    volumes:
+      - type: bind
+        source: .
+        target: /src
+        consistency: cached
+    x-extension: allowed
+networks:
+  front:
+    driver: bridge
+`
+	_, err := Parse([]byte(data), lookupEnv(nil))
+	var e *UnsupportedKeysError
+	if !errors.As(err, &e) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"services.app.build",
+		"services.app.healthcheck.unknown",
+		"services.app.volumes[0].consistency",
+		"networks.front.driver",
+	}
+	if diff := cmp.Diff(expected, e.Keys); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Parallel()
+
+	env := lookupEnv(map[string]string{
+		"FOO":   "foo",
+		"EMPTY": "",
+	})
+	testCases := []struct {
+		in       string
+		expected string
+		failed   bool
+	}{
+		{in: "$FOO/${FOO}", expected: "foo/foo"},
+		{in: "$$FOO", expected: "$FOO"},
+		{in: "${UNSET:-default}", expected: "default"},
+		{in: "${EMPTY:-default}", expected: "default"},
+		{in: "${EMPTY-default}", expected: ""},
+		{in: "${UNSET-default}", expected: "default"},
+		{in: "${EMPTY?required}", expected: ""},
+		{in: "${EMPTY:?required}", failed: true},
+		{in: "${UNSET?required}", failed: true},
+		{in: "${FOO", failed: true},
+		{in: "cost: 5$", expected: "cost: 5$"},
+	}
+	for _, tc := range testCases {
+		v, err := interpolate(tc.in, env)
+		if tc.failed {
+			if err == nil {
+				t.Errorf("%q: expected error, but got %q", tc.in, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tc.in, err)
+		} else if v != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.in, tc.expected, v)
+		}
+	}
+}
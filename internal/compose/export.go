@@ -0,0 +1,304 @@
+package compose
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"gopkg.in/yaml.v3"
+)
+
+// labelPrefix is the prefix of the labels which confort attaches to its resources.
+// Exported services don't inherit these labels, so that the reproduced containers
+// are not regarded as the resources of confort.
+const labelPrefix = "daichitakahashi.confort."
+
+// Export inspects the network named namespace and the containers whose names have
+// the prefix "<namespace>-", and converts them into Project.
+// If label is not empty, only the containers labeled with label=value are exported.
+//
+// Each container becomes a service named after its container name without the
+// prefix. Values which are the same as the image's default are omitted.
+// Port bindings are exported as configured, so the port which was assigned
+// randomly remains unpublished.
+func Export(ctx context.Context, cli client.APIClient, namespace, label, value string) (*Project, error) {
+	nw, err := cli.NetworkInspect(ctx, namespace, types.NetworkInspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	f := filters.NewArgs(
+		filters.Arg("network", nw.ID),
+	)
+	if label != "" {
+		f.Add("label", label+"="+value)
+	}
+	list, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: f,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Project{
+		Name:     nw.Name,
+		Services: map[string]*Service{},
+		Networks: map[string]*Network{
+			nw.Name: {Name: nw.Name},
+		},
+	}
+	prefix := namespace + "-"
+	for _, c := range list {
+		info, err := cli.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(info.Name, "/")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		name = strings.TrimPrefix(name, prefix)
+
+		img, _, err := cli.ImageInspectWithRaw(ctx, info.Image)
+		if err != nil {
+			return nil, err
+		}
+		imageConfig := img.Config
+		if imageConfig == nil {
+			imageConfig = &container.Config{}
+		}
+
+		svc, volumes := exportService(name, info, imageConfig, nw.Name)
+		for _, v := range volumes {
+			if p.Volumes == nil {
+				p.Volumes = map[string]*Volume{}
+			}
+			p.Volumes[v] = &Volume{Name: v}
+		}
+		p.Services[name] = svc
+	}
+	return p, nil
+}
+
+func exportService(name string, info types.ContainerJSON, imageConfig *container.Config, networkName string) (*Service, []string) {
+	c, hc := info.Config, info.HostConfig
+	svc := &Service{
+		Image:      c.Image,
+		Privileged: hc.Privileged,
+		CapAdd:     hc.CapAdd,
+		CapDrop:    hc.CapDrop,
+		ExtraHosts: hc.ExtraHosts,
+	}
+	if !reflect.DeepEqual([]string(c.Entrypoint), []string(imageConfig.Entrypoint)) {
+		svc.Entrypoint = ShellCommand(c.Entrypoint)
+		svc.Command = ShellCommand(c.Cmd)
+	} else if !reflect.DeepEqual([]string(c.Cmd), []string(imageConfig.Cmd)) {
+		svc.Command = ShellCommand(c.Cmd)
+	}
+	if c.WorkingDir != imageConfig.WorkingDir {
+		svc.WorkingDir = c.WorkingDir
+	}
+	if c.User != imageConfig.User {
+		svc.User = c.User
+	}
+	if c.StopSignal != imageConfig.StopSignal {
+		svc.StopSignal = c.StopSignal
+	}
+	if c.StopTimeout != nil {
+		d := Duration(time.Duration(*c.StopTimeout) * time.Second)
+		svc.StopGracePeriod = &d
+	}
+
+	// environment variables
+	imageEnv := map[string]bool{}
+	for _, e := range imageConfig.Env {
+		imageEnv[e] = true
+	}
+	for _, e := range c.Env {
+		if imageEnv[e] {
+			continue
+		}
+		if svc.Environment == nil {
+			svc.Environment = Environment{}
+		}
+		k, v, _ := strings.Cut(e, "=")
+		svc.Environment[k] = &v
+	}
+
+	// labels
+	for k, v := range c.Labels {
+		if strings.HasPrefix(k, labelPrefix) {
+			continue
+		}
+		if iv, ok := imageConfig.Labels[k]; ok && iv == v {
+			continue
+		}
+		if svc.Labels == nil {
+			svc.Labels = Labels{}
+		}
+		svc.Labels[k] = v
+	}
+
+	// ports
+	for port, bindings := range hc.PortBindings {
+		protocol := port.Proto()
+		if protocol == "tcp" {
+			protocol = ""
+		}
+		if len(bindings) == 0 {
+			svc.Ports = append(svc.Ports, Port{
+				Target:   port.Port(),
+				Protocol: protocol,
+			})
+		}
+		for _, b := range bindings {
+			svc.Ports = append(svc.Ports, Port{
+				Target:    port.Port(),
+				Published: b.HostPort,
+				HostIP:    b.HostIP,
+				Protocol:  protocol,
+			})
+		}
+	}
+	sort.Slice(svc.Ports, func(i, j int) bool {
+		pi, _ := strconv.Atoi(svc.Ports[i].Target)
+		pj, _ := strconv.Atoi(svc.Ports[j].Target)
+		if pi != pj {
+			return pi < pj
+		}
+		return svc.Ports[i].Spec() < svc.Ports[j].Spec()
+	})
+	for port := range c.ExposedPorts {
+		if _, ok := hc.PortBindings[port]; ok {
+			continue
+		}
+		if _, ok := imageConfig.ExposedPorts[port]; ok {
+			continue
+		}
+		svc.Expose = append(svc.Expose, string(port))
+	}
+	sort.Strings(svc.Expose)
+
+	// healthcheck
+	if h := c.Healthcheck; h != nil && !reflect.DeepEqual(h, imageConfig.Healthcheck) {
+		svc.Healthcheck = exportHealthcheck(h)
+	}
+
+	// mounts
+	named := map[string]bool{}
+	for _, m := range hc.Mounts {
+		if m.Type == mount.TypeVolume && m.Source != "" {
+			named[m.Source] = true
+		}
+	}
+	for _, b := range hc.Binds {
+		if src, _, ok := strings.Cut(b, ":"); ok && !strings.HasPrefix(src, "/") {
+			named[src] = true
+		}
+	}
+	var volumes []string
+	for _, m := range info.Mounts {
+		v := Mount{
+			Type:     string(m.Type),
+			Target:   m.Destination,
+			ReadOnly: !m.RW,
+		}
+		switch m.Type {
+		case mount.TypeBind:
+			v.Source = m.Source
+			if m.Propagation != "" && m.Propagation != mount.PropagationRPrivate {
+				v.Bind = &BindOptions{
+					Propagation: string(m.Propagation),
+				}
+			}
+		case mount.TypeVolume:
+			if named[m.Name] {
+				v.Source = m.Name
+				volumes = append(volumes, m.Name)
+			}
+		case mount.TypeTmpfs:
+			if _, ok := hc.Tmpfs[m.Destination]; ok {
+				continue // exported as tmpfs
+			}
+		default:
+			continue
+		}
+		svc.Volumes = append(svc.Volumes, v)
+	}
+	sort.Slice(svc.Volumes, func(i, j int) bool {
+		return svc.Volumes[i].Target < svc.Volumes[j].Target
+	})
+	for path, opts := range hc.Tmpfs {
+		if opts != "" {
+			path += ":" + opts
+		}
+		svc.Tmpfs = append(svc.Tmpfs, path)
+	}
+	sort.Strings(svc.Tmpfs)
+
+	// network aliases
+	if es, ok := info.NetworkSettings.Networks[networkName]; ok {
+		var aliases []string
+		seen := map[string]bool{name: true}
+		for _, alias := range es.Aliases {
+			if seen[alias] || strings.HasPrefix(info.ID, alias) {
+				continue // service name and container id are added by compose
+			}
+			seen[alias] = true
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		var sn *ServiceNetwork
+		if len(aliases) > 0 {
+			sn = &ServiceNetwork{Aliases: aliases}
+		}
+		svc.Networks = Networks{
+			networkName: sn,
+		}
+	}
+	return svc, volumes
+}
+
+func exportHealthcheck(h *container.HealthConfig) *Healthcheck {
+	hc := &Healthcheck{
+		Test: ShellCommand(h.Test),
+	}
+	if len(h.Test) > 0 && h.Test[0] == "NONE" {
+		return &Healthcheck{Disable: true}
+	}
+	duration := func(d time.Duration) *Duration {
+		if d == 0 {
+			return nil
+		}
+		v := Duration(d)
+		return &v
+	}
+	hc.Interval = duration(h.Interval)
+	hc.Timeout = duration(h.Timeout)
+	hc.StartPeriod = duration(h.StartPeriod)
+	if h.Retries != 0 {
+		retries := h.Retries
+		hc.Retries = &retries
+	}
+	return hc
+}
+
+// Write writes the project in YAML format.
+func (p *Project) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(p); err != nil {
+		return err
+	}
+	return enc.Close()
+}
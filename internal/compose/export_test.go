@@ -0,0 +1,177 @@
+package compose
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExportService(t *testing.T) {
+	t.Parallel()
+
+	const id = "0123456789abcdef"
+	stopTimeout := 30
+	info := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   id,
+			Name: "/ns-app",
+			HostConfig: &container.HostConfig{
+				PortBindings: nat.PortMap{
+					"80/tcp": {{HostIP: "127.0.0.1", HostPort: ""}},
+					"53/udp": {{HostPort: "5353"}},
+				},
+				Mounts: []mount.Mount{
+					{Type: mount.TypeVolume, Source: "data", Target: "/data"},
+				},
+				Tmpfs: map[string]string{
+					"/run": "size=64m",
+				},
+				CapAdd: []string{"NET_ADMIN"},
+			},
+		},
+		Config: &container.Config{
+			Image: "app:latest",
+			Cmd:   []string{"serve"},
+			Env: []string{
+				"PATH=/usr/bin",
+				"DEBUG=true",
+			},
+			Labels: map[string]string{
+				"daichitakahashi.confort.beacon.identifier": "xxx",
+				"maintainer": "image",
+				"app":        "test",
+			},
+			ExposedPorts: nat.PortSet{
+				"80/tcp":   {},
+				"53/udp":   {},
+				"8080/tcp": {},
+				"9000/tcp": {},
+			},
+			Healthcheck: &container.HealthConfig{
+				Test:     []string{"CMD-SHELL", "curl -f localhost"},
+				Interval: time.Second,
+			},
+			StopTimeout: &stopTimeout,
+		},
+		Mounts: []types.MountPoint{
+			{Type: mount.TypeVolume, Name: "data", Destination: "/data", RW: true},
+			{Type: mount.TypeVolume, Name: "f0e1d2c3", Destination: "/cache", RW: true},
+			{Type: mount.TypeBind, Source: "/host/src", Destination: "/src", Propagation: mount.PropagationRPrivate},
+			{Type: mount.TypeTmpfs, Destination: "/run", RW: true},
+		},
+		NetworkSettings: &types.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{
+				"ns": {Aliases: []string{"app", "api", id[:12]}},
+			},
+		},
+	}
+	imageConfig := &container.Config{
+		Cmd: []string{"serve"},
+		Env: []string{"PATH=/usr/bin"},
+		Labels: map[string]string{
+			"maintainer": "image",
+		},
+		ExposedPorts: nat.PortSet{
+			"9000/tcp": {},
+		},
+	}
+
+	svc, volumes := exportService("app", info, imageConfig, "ns")
+
+	str := func(s string) *string { return &s }
+	dur := func(d time.Duration) *Duration { v := Duration(d); return &v }
+	expected := &Service{
+		Image: "app:latest",
+		Environment: Environment{
+			"DEBUG": str("true"),
+		},
+		Labels: Labels{
+			"app": "test",
+		},
+		Ports: []Port{
+			{Target: "53", Published: "5353", Protocol: "udp"},
+			{Target: "80", HostIP: "127.0.0.1"},
+		},
+		Expose: []string{"8080/tcp"},
+		Healthcheck: &Healthcheck{
+			Test:     ShellCommand{"CMD-SHELL", "curl -f localhost"},
+			Interval: dur(time.Second),
+		},
+		Volumes: []Mount{
+			{Type: MountTypeVolume, Target: "/cache"},
+			{Type: MountTypeVolume, Source: "data", Target: "/data"},
+			{Type: MountTypeBind, Source: "/host/src", Target: "/src", ReadOnly: true},
+		},
+		Tmpfs:           StringList{"/run:size=64m"},
+		StopGracePeriod: dur(30 * time.Second),
+		CapAdd:          []string{"NET_ADMIN"},
+		Networks: Networks{
+			"ns": {Aliases: []string{"api"}},
+		},
+	}
+	if diff := cmp.Diff(expected, svc, cmp.AllowUnexported(Port{}, Healthcheck{})); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff([]string{"data"}, volumes); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestProject_Write(t *testing.T) {
+	t.Parallel()
+
+	retries := 3
+	grace := Duration(10 * time.Second)
+	value := "value"
+	p := &Project{
+		Name: "ns",
+		Services: map[string]*Service{
+			"app": {
+				Image:   "app:latest",
+				Command: ShellCommand{"serve", "--addr", ":80"},
+				Environment: Environment{
+					"KEY": &value,
+				},
+				Ports: []Port{
+					{Target: "80", Published: "8080", HostIP: "127.0.0.1"},
+				},
+				Healthcheck: &Healthcheck{
+					Test:    ShellCommand{"CMD", "true"},
+					Retries: &retries,
+				},
+				Volumes: []Mount{
+					{Type: MountTypeVolume, Source: "data", Target: "/data"},
+				},
+				StopGracePeriod: &grace,
+				Networks: Networks{
+					"ns": {Aliases: []string{"api"}},
+				},
+			},
+		},
+		Volumes: map[string]*Volume{
+			"data": {Name: "data"},
+		},
+		Networks: map[string]*Network{
+			"ns": {Name: "ns"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(buf.Bytes(), lookupEnv(nil))
+	if err != nil {
+		t.Fatalf("%s\n%s", err, buf.String())
+	}
+	if diff := cmp.Diff(p, parsed, cmp.AllowUnexported(Port{}, Healthcheck{})); diff != "" {
+		t.Fatalf("%s\n%s", diff, buf.String())
+	}
+}
@@ -0,0 +1,190 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolateNode substitutes variables in all scalar values under node.
+func interpolateNode(node *yaml.Node, lookupEnv func(string) (string, bool)) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "$") {
+			return nil
+		}
+		v, err := interpolate(node.Value, lookupEnv)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = v
+		if node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) == 0 {
+			// resolve the type of the plain value again, but empty value is not a null
+			node.Tag = ""
+			if v == "" {
+				node.Tag = "!!str"
+			}
+		}
+	case yaml.MappingNode:
+		// skip keys
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateNode(node.Content[i], lookupEnv); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, n := range node.Content {
+			if err := interpolateNode(n, lookupEnv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate substitutes variables in s in the same manner as compose.
+// Supported forms are "$VAR", "${VAR}", "${VAR:-default}", "${VAR-default}",
+// "${VAR:?error}", "${VAR?error}" and "$$"(escaped "$").
+func interpolate(s string, lookupEnv func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch next := s[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("invalid interpolation format: %q", s)
+			}
+			v, err := substitute(s[i+2:i+2+end], lookupEnv)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i += end + 2
+		case isNameChar(next, true):
+			j := i + 2
+			for j < len(s) && isNameChar(s[j], false) {
+				j++
+			}
+			v, _ := lookupEnv(s[i+1 : j])
+			b.WriteString(v)
+			i = j - 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func substitute(expr string, lookupEnv func(string) (string, bool)) (string, error) {
+	j := 0
+	for j < len(expr) && isNameChar(expr[j], j == 0) {
+		j++
+	}
+	name, modifier := expr[:j], expr[j:]
+	if name == "" {
+		return "", fmt.Errorf("invalid interpolation format: ${%s}", expr)
+	}
+	v, ok := lookupEnv(name)
+
+	switch {
+	case modifier == "":
+		return v, nil
+	case strings.HasPrefix(modifier, ":-"):
+		if v == "" {
+			return modifier[2:], nil
+		}
+		return v, nil
+	case strings.HasPrefix(modifier, "-"):
+		if !ok {
+			return modifier[1:], nil
+		}
+		return v, nil
+	case strings.HasPrefix(modifier, ":?"):
+		if v == "" {
+			return "", requiredError(name, modifier[2:])
+		}
+		return v, nil
+	case strings.HasPrefix(modifier, "?"):
+		if !ok {
+			return "", requiredError(name, modifier[1:])
+		}
+		return v, nil
+	default:
+		return "", fmt.Errorf("invalid interpolation format: ${%s}", expr)
+	}
+}
+
+func requiredError(name, msg string) error {
+	if msg == "" {
+		msg = "required variable " + name + " is missing a value"
+	}
+	return errors.New(msg)
+}
+
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		return true
+	case '0' <= c && c <= '9':
+		return !first
+	default:
+		return false
+	}
+}
+
+// splitCommand splits the command line into arguments, respecting quotes and
+// backslash escapes.
+func splitCommand(s string) ([]string, error) {
+	var (
+		args    []string
+		b       strings.Builder
+		inArg   bool
+		quote   byte
+		escaped bool
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			b.WriteByte(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				b.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		default:
+			b.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape: %s", s)
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,109 @@
+// Package coverage handles the coverage data written by the binaries built with
+// "-cover" flag and running in the containers.
+package coverage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// RawDir returns the directory in dir, which is mounted into the containers as GOCOVERDIR.
+func RawDir(dir string) string {
+	return filepath.Join(dir, ".confort")
+}
+
+// Prepare creates the directory returned by RawDir. The directory is writable by
+// any user, because the processes in the containers run as the users of the images,
+// whose UIDs differ from the user running the tests. Like /tmp, the sticky bit is set
+// so that the users cannot remove the files of others.
+func Prepare(dir string) (string, error) {
+	raw := RawDir(dir)
+	err := os.MkdirAll(raw, 0755)
+	if err != nil {
+		return "", err
+	}
+	// ignore umask
+	err = os.Chmod(raw, os.ModeSticky|0777)
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// GoCommand returns the go command to merge the coverage data. It prefers the one
+// specified by goCmd, e.g. via the environment variable set by "confort test", and
+// then the one in GOROOT the test binary is built with.
+func GoCommand(goCmd string) string {
+	if goCmd != "" {
+		return goCmd
+	}
+	if root := runtime.GOROOT(); root != "" {
+		path := filepath.Join(root, "bin", "go")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return "go"
+}
+
+// Merge merges the coverage data in the directory returned by RawDir into dir with
+// "go tool covdata merge", and removes the merged counter data from the directory.
+// The counter data written while merging is kept for the next merge.
+func Merge(ctx context.Context, goCmd, dir string) error {
+	raw := RawDir(dir)
+	entries, err := os.ReadDir(raw)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Move the counter data files to the staging directory. The meta-data files are
+	// copied instead, because the running processes don't write them again.
+	staging, err := os.MkdirTemp(dir, ".confort-merge-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(staging)
+	}()
+	var counters int
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		src, dst := filepath.Join(raw, e.Name()), filepath.Join(staging, e.Name())
+		switch {
+		case strings.HasPrefix(e.Name(), "covmeta."):
+			err = copyFile(dst, src)
+		case strings.HasPrefix(e.Name(), "covcounters."):
+			err = os.Rename(src, dst)
+			counters++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if counters == 0 {
+		return nil
+	}
+
+	out, err := exec.CommandContext(ctx, goCmd, "tool", "covdata", "merge", "-i="+staging, "-o="+dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("go tool covdata merge: %w: %s", err, out)
+	}
+	return nil
+}
+
+func copyFile(dst, src string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
@@ -0,0 +1,134 @@
+package coverage
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func buildCoverBinary(t *testing.T) string {
+	t.Helper()
+
+	src := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/cover\n\ngo 1.20\n",
+		"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n",
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	bin := filepath.Join(t.TempDir(), "app")
+	cmd := exec.Command("go", "build", "-cover", "-o", bin, ".")
+	cmd.Dir = src
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	return bin
+}
+
+func countFiles(t *testing.T, dir, prefix string) int {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	bin := buildCoverBinary(t)
+	dir := t.TempDir()
+
+	// no data
+	if err := Merge(ctx, "go", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := Prepare(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0777 || info.Mode()&os.ModeSticky == 0 {
+		t.Fatalf("unexpected permission: %s", info.Mode())
+	}
+
+	run := func() {
+		t.Helper()
+		cmd := exec.Command(bin)
+		cmd.Env = append(os.Environ(), "GOCOVERDIR="+raw)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	run()
+	run()
+
+	if err := Merge(ctx, "go", dir); err != nil {
+		t.Fatal(err)
+	}
+	if n := countFiles(t, dir, "covmeta."); n != 1 {
+		t.Fatalf("expected merged meta-data file, got %d", n)
+	}
+	if n := countFiles(t, dir, "covcounters."); n != 1 {
+		t.Fatalf("expected merged counter data file, got %d", n)
+	}
+	// the meta-data file is kept and the counter data files are removed
+	if n := countFiles(t, raw, "covmeta."); n != 1 {
+		t.Fatalf("expected meta-data file to be kept, got %d", n)
+	}
+	if n := countFiles(t, raw, "covcounters."); n != 0 {
+		t.Fatalf("expected counter data files to be removed, got %d", n)
+	}
+	if n := countFiles(t, dir, ".confort-merge-"); n != 0 {
+		t.Fatal("staging directory is not removed")
+	}
+
+	// merge again
+	run()
+	if err := Merge(ctx, "go", dir); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("go", "tool", "covdata", "percent", "-i="+dir).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if !strings.Contains(string(out), "example.com/cover") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
+
+func TestGoCommand(t *testing.T) {
+	t.Parallel()
+
+	if goCmd := GoCommand("/path/to/go"); goCmd != "/path/to/go" {
+		t.Fatalf("unexpected go command: %s", goCmd)
+	}
+	out, err := exec.Command(GoCommand(""), "env", "GOROOT").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root := strings.TrimSpace(string(out)); root != runtime.GOROOT() {
+		t.Fatalf("unexpected GOROOT: %s", root)
+	}
+}
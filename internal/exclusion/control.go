@@ -6,6 +6,8 @@ import (
 
 	"github.com/daichitakahashi/confort/internal/beacon/proto"
 	"go.uber.org/multierr"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -13,6 +15,8 @@ type Control interface {
 	LockForNamespace(ctx context.Context) (func(), error)
 	LockForBuild(ctx context.Context, image string) (func(), error)
 	LockForContainerSetup(ctx context.Context, name string) (func(), error)
+	LockForPull(ctx context.Context, image string) (func(), error)
+	LockForContainerRecreate(ctx context.Context, name string) (func(), error)
 	LockForContainerUse(ctx context.Context, params map[string]ContainerUseParam) (unlock func(), err error)
 }
 
@@ -39,6 +43,14 @@ func (c *control) LockForContainerSetup(ctx context.Context, name string) (func(
 	return c.l.LockForContainerSetup(ctx, name)
 }
 
+func (c *control) LockForPull(ctx context.Context, image string) (func(), error) {
+	return c.l.LockForPull(ctx, image)
+}
+
+func (c *control) LockForContainerRecreate(_ context.Context, name string) (func(), error) {
+	return c.l.LockForContainerRecreate(name)
+}
+
 type ContainerUseParam struct {
 	Exclusive bool
 	Init      func(ctx context.Context) error
@@ -176,6 +188,64 @@ func (b *beaconControl) LockForContainerSetup(ctx context.Context, name string)
 	}, nil
 }
 
+func (b *beaconControl) LockForPull(ctx context.Context, image string) (func(), error) {
+	stream, err := b.cli.LockForPull(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.Send(&proto.KeyedLockRequest{
+		Key:       image,
+		Operation: proto.LockOp_LOCK_OP_LOCK,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		err := stream.Send(&proto.KeyedLockRequest{
+			Key:       image,
+			Operation: proto.LockOp_LOCK_OP_UNLOCK,
+		})
+		_ = err // TODO: error handling
+		_ = stream.CloseSend()
+	}, nil
+}
+
+func (b *beaconControl) LockForContainerRecreate(ctx context.Context, name string) (func(), error) {
+	stream, err := b.cli.LockForContainerRecreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.Send(&proto.KeyedLockRequest{
+		Key:       name,
+		Operation: proto.LockOp_LOCK_OP_LOCK,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = stream.Recv()
+	if status.Code(err) == codes.FailedPrecondition {
+		return nil, ErrContainerInUse
+	} else if err != nil {
+		return nil, err
+	}
+	return func() {
+		err := stream.Send(&proto.KeyedLockRequest{
+			Key:       name,
+			Operation: proto.LockOp_LOCK_OP_UNLOCK,
+		})
+		_ = err // TODO: error handling
+		_ = stream.CloseSend()
+	}, nil
+}
+
 func (b *beaconControl) LockForContainerUse(ctx context.Context, params map[string]ContainerUseParam) (unlock func(), err error) {
 	targets := map[string]*proto.AcquireLockParam{}
 	for name, param := range params {
@@ -193,6 +193,34 @@ func TestControl_LockForContainerSetup(t *testing.T) {
 	}
 }
 
+func testLockForPull(t *testing.T, c exclusion.Control) {
+	var eg errgroup.Group
+	for i := 0; i < 10; i++ {
+		eg.Go(func() error {
+			image := uuid.NewString()
+			return testLock(func(ctx context.Context) (func(), error) {
+				return c.LockForPull(ctx, image)
+			})
+		})
+	}
+	err := eg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControl_LockForPull(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range controls(t) {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			testLockForPull(t, c.control)
+		})
+	}
+}
+
 func lockForContainerUse(c exclusion.Control, name string) error {
 	ctx := context.Background()
 
@@ -444,3 +472,66 @@ func TestControl_LockForContainerUse_Downgrade(t *testing.T) {
 		})
 	}
 }
+
+func testLockForContainerRecreate(t *testing.T, c exclusion.Control) {
+	ctx := context.Background()
+
+	name := uuid.NewString()
+	var count int
+	use := func() func() {
+		t.Helper()
+		unlock, err := c.LockForContainerUse(ctx, map[string]exclusion.ContainerUseParam{
+			name: {
+				Exclusive: false,
+				Init: func(context.Context) error {
+					count++
+					return nil
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return unlock
+	}
+
+	// fails while the container is used
+	unlock := use()
+	_, err := c.LockForContainerRecreate(ctx, name)
+	if !errors.Is(err, exclusion.ErrContainerInUse) {
+		t.Fatalf("expected ErrContainerInUse, but got %v", err)
+	}
+	unlock()
+
+	// the release of beaconControl is asynchronous
+	var unlockRecreate func()
+	for i := 0; i < 100; i++ {
+		unlockRecreate, err = c.LockForContainerRecreate(ctx, name)
+		if !errors.Is(err, exclusion.ErrContainerInUse) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockRecreate()
+
+	// init runs again after recreation
+	use()()
+	if count != 2 {
+		t.Fatalf("unexpected number of call initFunc: %d", count)
+	}
+}
+
+func TestControl_LockForContainerRecreate(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range controls(t) {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			testLockForContainerRecreate(t, c.control)
+		})
+	}
+}
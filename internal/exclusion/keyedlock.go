@@ -24,6 +24,11 @@ func (k *KeyedLock) Lock(ctx context.Context, key string) error {
 	return v.(*semaphore.Weighted).Acquire(ctx, max)
 }
 
+func (k *KeyedLock) TryLock(key string) bool {
+	v, _ := k.m.LoadOrStore(key, semaphore.NewWeighted(max))
+	return v.(*semaphore.Weighted).TryAcquire(max)
+}
+
 func (k *KeyedLock) Unlock(key string) {
 	v, ok := k.m.Load(key)
 	if !ok {
@@ -2,6 +2,7 @@ package exclusion
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"sync/atomic"
 
@@ -12,6 +13,7 @@ type Locker struct {
 	namespace      sync.Mutex
 	build          *KeyedLock
 	containerSetup *KeyedLock
+	pull           *KeyedLock
 	containerUse   *KeyedLock
 	acquirer       *Acquirer
 	once           *oncewait.Factory
@@ -21,6 +23,7 @@ func NewLocker() *Locker {
 	return &Locker{
 		build:          NewKeyedLock(),
 		containerSetup: NewKeyedLock(),
+		pull:           NewKeyedLock(),
 		containerUse:   NewKeyedLock(),
 		acquirer:       NewAcquirer(),
 		once:           &oncewait.Factory{},
@@ -52,6 +55,34 @@ func (l *Locker) LockForContainerSetup(ctx context.Context, name string) (func()
 	}, nil
 }
 
+func (l *Locker) LockForPull(ctx context.Context, image string) (func(), error) {
+	err := l.pull.Lock(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		l.pull.Unlock(image)
+	}, nil
+}
+
+// ErrContainerInUse is returned by LockForContainerRecreate when the container is used.
+var ErrContainerInUse = errors.New("container is in use")
+
+// LockForContainerRecreate acquires the exclusive lock for using the container without
+// waiting, and resets the state of the initialization so that the container recreated
+// while holding the lock is initialized again. It fails with ErrContainerInUse when
+// the container is used, instead of waiting for the users, which may hold the lock
+// for the whole lifetime of their process.
+func (l *Locker) LockForContainerRecreate(name string) (func(), error) {
+	if !l.containerUse.TryLock(name) {
+		return nil, ErrContainerInUse
+	}
+	l.once.Refresh(name)
+	return func() {
+		l.containerUse.Unlock(name)
+	}, nil
+}
+
 type ContainerLock struct {
 	l          *KeyedLock
 	once       *oncewait.Factory
@@ -0,0 +1,28 @@
+// Package gocommand determines the go command used by "confort test" and Confort.BuildGo.
+package gocommand
+
+import (
+	"fmt"
+
+	"github.com/daichitakahashi/gocmd"
+)
+
+// Determine returns the path and the version of the go command.
+// If version is empty, "go" command is used. If version is "mod", it finds the command
+// of the version written in go.mod. Otherwise, it finds the command of the given version.
+// See gocmd.Mode for the behavior of mode.
+func Determine(version string, mode gocmd.Mode) (string, string, error) {
+	switch version {
+	case "":
+		goVer, err := gocmd.CurrentVersion()
+		return "go", goVer, err
+	case "mod":
+		modVer, err := gocmd.ModuleGoVersion()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read go.mod: %w", err)
+		}
+		return gocmd.Determine(modVer, mode)
+	default:
+		return gocmd.Determine(version, mode)
+	}
+}
@@ -0,0 +1,124 @@
+package confort
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/daichitakahashi/confort/internal/logging"
+	"github.com/lestrrat-go/option"
+)
+
+// The methods below control the lifecycle of the container, e.g. to test the
+// reconnection and failover logic of the application. They require the exclusive
+// lock of the container acquired by Container.Use, Acquirer.Do or their variants in
+// this process, to prevent other tests from being affected. InitFunc is also called
+// with the exclusive lock.
+//
+// Stop and Kill require the container created with WithoutAutoRemove, because the
+// stopped container is removed immediately. Restart works regardless of it.
+// After the container is started again, the host ports may change. Ports returned
+// by Start and Restart, and the subsequent uses of the container reflect the change,
+// including the uses in other processes sharing the beacon server.
+
+type identOptionWithoutAutoRemove struct{}
+
+// WithoutAutoRemove creates the container without AutoRemove, which is enabled by
+// default. The container is kept after it stops, so that it can be stopped by
+// Container.Stop or Container.Kill, and started again by Container.Start.
+func WithoutAutoRemove() RunOption {
+	return runOption{
+		Interface: option.New(identOptionWithoutAutoRemove{}, true),
+	}.run()
+}
+
+// Stop stops the running container.
+func (c *Container) Stop(ctx context.Context) error {
+	return c.control(ctx, "stop", func(ctx context.Context) error {
+		return c.cft.namespace.StopContainer(ctx, c.name)
+	})
+}
+
+// Start starts the container stopped by Stop or Kill, and waits for it to be
+// ready with its Waiter. It returns the new endpoints of the container.
+func (c *Container) Start(ctx context.Context) (Ports, error) {
+	var ports Ports
+	err := c.control(ctx, "start", func(ctx context.Context) (err error) {
+		ports, err = c.cft.namespace.StartContainer(ctx, c.name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ports, nil
+}
+
+// Restart stops the container and starts it again, and waits for it to be ready
+// with its Waiter. It returns the new endpoints of the container.
+func (c *Container) Restart(ctx context.Context) (Ports, error) {
+	var ports Ports
+	err := c.control(ctx, "restart", func(ctx context.Context) (err error) {
+		ports, err = c.cft.namespace.RestartContainer(ctx, c.name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ports, nil
+}
+
+// Kill kills the container with SIGKILL and waits for it to exit.
+// To start the container again, use Start.
+func (c *Container) Kill(ctx context.Context) error {
+	return c.control(ctx, "kill", func(ctx context.Context) error {
+		return c.cft.namespace.KillContainer(ctx, c.name)
+	})
+}
+
+// Pause suspends all processes in the container. The host ports are kept.
+func (c *Container) Pause(ctx context.Context) error {
+	return c.control(ctx, "pause", func(ctx context.Context) error {
+		return c.cft.namespace.PauseContainer(ctx, c.name)
+	})
+}
+
+// Unpause resumes all processes in the container paused by Pause.
+func (c *Container) Unpause(ctx context.Context) error {
+	return c.control(ctx, "unpause", func(ctx context.Context) error {
+		return c.cft.namespace.UnpauseContainer(ctx, c.name)
+	})
+}
+
+func (c *Container) control(ctx context.Context, op string, f func(ctx context.Context) error) error {
+	if !c.cft.holders.exclusive(c.name) {
+		return fmt.Errorf("confort: %s: exclusive lock of container %q is required (%s)", op, c.alias, c.cft.holders.describe(c.name))
+	}
+
+	ctx, cancel := applyTimeout(ctx, c.cft.defaultTimeout)
+	defer cancel()
+
+	logging.Debugf("acquire LockForContainerSetup: %s", c.name)
+	unlock, err := c.cft.ex.LockForContainerSetup(ctx, c.name)
+	if err != nil {
+		return fmt.Errorf("confort: %s: %w", op, err)
+	}
+	defer func() {
+		logging.Debugf("release LockForContainerSetup: %s", c.name)
+		unlock()
+	}()
+
+	logging.Debugf("%s container: %s", op, c.name)
+	if err := f(ctx); err != nil {
+		return fmt.Errorf("confort: %s: %w", op, err)
+	}
+	return nil
+}
+
+// latestPorts returns the current endpoints of the container, which may be
+// changed by Start, Restart and Restore.
+func (c *Container) latestPorts() Ports {
+	ports, err := c.cft.namespace.ContainerPorts(c.name)
+	if err != nil {
+		return c.ports
+	}
+	return ports
+}
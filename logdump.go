@@ -0,0 +1,137 @@
+package confort
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/lestrrat-go/option"
+)
+
+type (
+	// LogDumpOption is available as both RunOption and UseOption.
+	LogDumpOption interface {
+		RunOption
+		UseOption
+	}
+	identOptionLogDump struct{}
+	logDumpOption      struct {
+		option.Interface
+	}
+)
+
+func (o logDumpOption) run() RunOption { return o }
+func (o logDumpOption) use() UseOption { return o }
+
+// WithLogDump enables to dump the logs of the container when the test fails.
+// If the test has failed at its cleanup, the logs of the container output since
+// the acquisition of the lock are written via testing.TB's Log. If dir is not
+// empty, the logs are written into the file "<dir>/<test name>/<container name>.log"
+// instead.
+//
+// It works with Container.UseExclusiveT, Container.UseSharedT and Acquirer.DoT.
+// With Confort.Run, the option is applied to all uses of the container.
+// With Container.UseExclusiveT and others, the option is applied to the use and
+// takes precedence over the one given to Confort.Run.
+func WithLogDump(dir string) LogDumpOption {
+	return logDumpOption{
+		Interface: option.New(identOptionLogDump{}, dir),
+	}
+}
+
+// logDumpDir returns the directory given by WithLogDump in opts.
+func logDumpDir[T option.Interface](opts []T) (dir *string) {
+	for _, opt := range opts {
+		switch opt.Ident() {
+		case identOptionLogDump{}:
+			d := opt.Value().(string)
+			dir = &d
+		}
+	}
+	return dir
+}
+
+// registerLogDump registers the function to dump the logs of the container to
+// the cleanup of t. dir overrides the setting of the container.
+func (c *Container) registerLogDump(t testing.TB, dir *string) {
+	if dir == nil {
+		dir = c.logDump
+	}
+	if dir == nil {
+		return
+	}
+	since := time.Now()
+	t.Cleanup(func() {
+		if t.Failed() {
+			c.dumpLog(t, *dir, since)
+		}
+	})
+}
+
+var testNameReplacer = strings.NewReplacer(
+	"/", "_", `\`, "_", ":", "_", "*", "_", "?", "_",
+	`"`, "_", "<", "_", ">", "_", "|", "_", " ", "_",
+)
+
+func (c *Container) dumpLog(t testing.TB, dir string, since time.Time) {
+	ctx, cancel := applyTimeout(context.Background(), c.cft.defaultTimeout)
+	defer cancel()
+
+	logs, err := c.readLog(ctx, since)
+	if err != nil {
+		t.Logf("failed to dump logs of container %q: %s", c.alias, err)
+		return
+	}
+	if dir == "" {
+		t.Logf("logs of container %q since %s:\n%s", c.alias, since.Format(time.RFC3339Nano), logs)
+		return
+	}
+
+	dst := filepath.Join(dir, testNameReplacer.Replace(t.Name()), c.alias+".log")
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err == nil {
+		err = os.WriteFile(dst, logs, 0644)
+	}
+	if err != nil {
+		t.Logf("failed to dump logs of container %q: %s", c.alias, err)
+		return
+	}
+	t.Logf("logs of container %q are written into %s", c.alias, dst)
+}
+
+func (c *Container) readLog(ctx context.Context, since time.Time) ([]byte, error) {
+	info, err := c.cft.cli.ContainerInspect(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+	f := &fetcher{
+		cli:         c.cft.cli,
+		containerID: c.name,
+		since:       since,
+	}
+	rc, err := f.Log(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	buf := bytes.NewBuffer(nil)
+	if info.Config != nil && info.Config.Tty {
+		_, err = io.Copy(buf, rc)
+	} else {
+		_, err = stdcopy.StdCopy(buf, buf, rc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logs: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,71 @@
+package confort
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/daichitakahashi/confort/internal/logging"
+	"github.com/docker/docker/api/types"
+	"github.com/lestrrat-go/option"
+)
+
+// CreateNetwork creates the additional network named "<namespace>-<name>" if not exists,
+// and returns its representation. Like the network created in New, the network is
+// labelled, and removed by Close according to ResourcePolicy.
+//
+// Containers are connected to the network by WithNetwork.
+func (cft *Confort) CreateNetwork(ctx context.Context, name string) (*types.NetworkResource, error) {
+	ctx, cancel := applyTimeout(ctx, cft.defaultTimeout)
+	defer cancel()
+
+	logging.Debug("acquire LockForNamespace")
+	unlock, err := cft.ex.LockForNamespace(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("confort: %w", err)
+	}
+	defer func() {
+		logging.Debug("release LockForNamespace")
+		unlock()
+	}()
+
+	logging.Debugf("create network %q", name)
+	nw, err := cft.namespace.CreateNetwork(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("confort: %w", err)
+	}
+	return nw, nil
+}
+
+type (
+	identOptionNetwork struct{}
+	networkOption      struct {
+		name    string
+		aliases []string
+	}
+)
+
+// WithNetwork connects the container to the additional network created by
+// Confort.CreateNetwork with the given name, after the creation of the container.
+// In the network, the container is reachable by its alias and the given aliases.
+// This option can be specified multiple times to connect several networks.
+// The connection is removed by Confort.Close unless the container is removed.
+func WithNetwork(name string, aliases ...string) RunOption {
+	return runOption{
+		Interface: option.New(identOptionNetwork{}, networkOption{
+			name:    name,
+			aliases: aliases,
+		}),
+	}.run()
+}
+
+// networks returns the networks given by WithNetwork in opts.
+func networks(opts []RunOption) []networkOption {
+	var n []networkOption
+	for _, opt := range opts {
+		switch opt.Ident() {
+		case identOptionNetwork{}:
+			n = append(n, opt.Value().(networkOption))
+		}
+	}
+	return n
+}
@@ -0,0 +1,161 @@
+package confort
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/daichitakahashi/confort/proxy"
+	"github.com/docker/go-connections/nat"
+	"github.com/lestrrat-go/option"
+	"go.uber.org/multierr"
+)
+
+type identOptionProxy struct{}
+
+// WithProxy makes the Ports returned by Container.Use and Acquirer.Do point at the
+// proxies in front of the given ports of the container, instead of the container itself.
+// If no ports are given, all bound ports are proxied. The proxies are obtained by
+// Container.Proxy, and their toxics are reset when the last user of the proxies in
+// this process releases the lock.
+func WithProxy(ports ...nat.Port) UseOption {
+	return useOption{
+		Interface: option.New(identOptionProxy{}, ports),
+	}.use()
+}
+
+// proxyPorts returns the ports given by WithProxy in opts.
+func proxyPorts(opts []UseOption) (ports []nat.Port, ok bool) {
+	for _, opt := range opts {
+		switch opt.Ident() {
+		case identOptionProxy{}:
+			ports = opt.Value().([]nat.Port)
+			ok = true
+		}
+	}
+	return ports, ok
+}
+
+// Proxy returns the proxy in front of the port of the container, which listens on
+// the loopback address. The proxy is created at the first call and shared with all
+// Container values of the same container in the Confort, until Confort.Close.
+// See proxy.Proxy for available toxics.
+//
+// Like the lifecycle methods such as Container.Stop, the toxics should be changed
+// only with the exclusive lock, because they affect all users of the proxy.
+func (c *Container) Proxy(port nat.Port) (*proxy.Proxy, error) {
+	binding := c.latestPorts().Binding(port)
+	if binding.HostPort == "" {
+		return nil, fmt.Errorf("confort: proxy: port %s of container %q is not bound", port, c.alias)
+	}
+	p, err := c.cft.proxies.get(c.name, port, net.JoinHostPort(binding.HostIP, binding.HostPort))
+	if err != nil {
+		return nil, fmt.Errorf("confort: proxy: %w", err)
+	}
+	return p, nil
+}
+
+// proxiedPorts returns the copy of the ports whose bindings of the specified ports are
+// replaced with the proxies, and the function to release them. The toxics of the
+// proxies are reset when their last user releases them.
+func (c *Container) proxiedPorts(targets []nat.Port) (Ports, func(), error) {
+	ports := c.latestPorts()
+	if len(targets) == 0 {
+		for port, bindings := range ports {
+			if len(bindings) > 0 {
+				targets = append(targets, port)
+			}
+		}
+		sort.Slice(targets, func(i, j int) bool {
+			return targets[i] < targets[j]
+		})
+	}
+
+	proxied := make(Ports, len(ports))
+	for port, bindings := range ports {
+		proxied[port] = bindings
+	}
+	proxies := make([]*proxy.Proxy, 0, len(targets))
+	for _, port := range targets {
+		p, err := c.Proxy(port)
+		if err != nil {
+			return nil, nil, err
+		}
+		host, hostPort, err := net.SplitHostPort(p.Addr())
+		if err != nil {
+			return nil, nil, fmt.Errorf("confort: proxy: %w", err)
+		}
+		proxied[port] = []nat.PortBinding{
+			{HostIP: host, HostPort: hostPort},
+		}
+		proxies = append(proxies, p)
+	}
+	return proxied, c.cft.proxies.use(proxies), nil
+}
+
+// proxies holds the proxies created in Confort.
+type proxies struct {
+	m       sync.Mutex
+	proxies map[string]*proxy.Proxy // key is "<container name>/<port>"
+	users   map[*proxy.Proxy]int    // number of users acquiring the proxy with WithProxy
+}
+
+func newProxies() *proxies {
+	return &proxies{
+		proxies: map[string]*proxy.Proxy{},
+		users:   map[*proxy.Proxy]int{},
+	}
+}
+
+// use increments the number of users of the proxies, and returns the function to
+// decrement it. The toxics of the proxy are reset when its last user releases it,
+// so that a shared user doesn't clear the toxics while others are still using it.
+func (p *proxies) use(pxs []*proxy.Proxy) func() {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for _, px := range pxs {
+		p.users[px]++
+	}
+	return func() {
+		p.m.Lock()
+		defer p.m.Unlock()
+		for _, px := range pxs {
+			p.users[px]--
+			if p.users[px] > 0 {
+				continue
+			}
+			delete(p.users, px)
+			px.ResetToxics()
+		}
+	}
+}
+
+// get returns the proxy of the port of the container. If the proxy already exists,
+// its upstream is updated, because the host port can be changed by restart.
+func (p *proxies) get(name string, port nat.Port, upstream string) (*proxy.Proxy, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	key := name + "/" + string(port)
+	if px, ok := p.proxies[key]; ok {
+		px.SetUpstream(upstream)
+		return px, nil
+	}
+	px, err := proxy.New(upstream)
+	if err != nil {
+		return nil, err
+	}
+	p.proxies[key] = px
+	return px, nil
+}
+
+func (p *proxies) close() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	var err error
+	for key, px := range p.proxies {
+		err = multierr.Append(err, px.Close())
+		delete(p.proxies, key)
+	}
+	return err
+}
@@ -0,0 +1,344 @@
+// Package proxy provides the TCP proxy to inject the faults between the test and
+// the container, without any privileges.
+package proxy
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Toxics is the set of the faults injected by Proxy.
+// The zero value means no faults.
+type Toxics struct {
+	// Latency is the delay added to each chunk of the data in both directions.
+	Latency time.Duration
+	// Jitter is the variation of Latency. The actual delay is chosen randomly
+	// from Latency-Jitter to Latency+Jitter.
+	Jitter time.Duration
+	// Bandwidth is the limit of the transfer rate of each direction in bytes per second.
+	Bandwidth int64
+	// LimitData closes the connection after LimitData bytes are transferred in
+	// either direction.
+	LimitData int64
+	// Blackhole discards all data silently without closing the connection.
+	Blackhole bool
+	// Reset resets the new and existing connections with TCP RST.
+	Reset bool
+}
+
+// Proxy is a TCP proxy which forwards the connections to the upstream address.
+// The toxics can be changed at runtime, and apply to the existing connections
+// from the next chunk of the data.
+type Proxy struct {
+	listener net.Listener
+
+	m        sync.RWMutex
+	upstream string
+	toxics   Toxics
+	conns    map[*link]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// New starts the proxy listening on the loopback address with a random port,
+// which forwards the connections to upstream.
+func New(upstream string) (*Proxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		listener: ln,
+		upstream: upstream,
+		conns:    map[*link]struct{}{},
+	}
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the "host:port" style address of the proxy.
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Upstream returns the address the proxy forwards to.
+func (p *Proxy) Upstream() string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.upstream
+}
+
+// SetUpstream changes the address the proxy forwards to.
+// The existing connections are not affected.
+func (p *Proxy) SetUpstream(upstream string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.upstream = upstream
+}
+
+// Toxics returns the current toxics.
+func (p *Proxy) Toxics() Toxics {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.toxics
+}
+
+// SetToxics replaces the current toxics.
+func (p *Proxy) SetToxics(t Toxics) {
+	p.m.Lock()
+	p.toxics = t
+	var reset []*link
+	if t.Reset {
+		for l := range p.conns {
+			reset = append(reset, l)
+		}
+	}
+	p.m.Unlock()
+
+	for _, l := range reset {
+		l.reset()
+	}
+}
+
+// Update modifies the current toxics with f.
+func (p *Proxy) Update(f func(t *Toxics)) {
+	t := p.Toxics()
+	f(&t)
+	p.SetToxics(t)
+}
+
+// ResetToxics removes all toxics.
+func (p *Proxy) ResetToxics() {
+	p.SetToxics(Toxics{})
+}
+
+// ResetConnections resets all existing connections with TCP RST.
+// The new connections are accepted unless Toxics.Reset is set.
+func (p *Proxy) ResetConnections() {
+	p.m.RLock()
+	conns := make([]*link, 0, len(p.conns))
+	for l := range p.conns {
+		conns = append(conns, l)
+	}
+	p.m.RUnlock()
+
+	for _, l := range conns {
+		l.reset()
+	}
+}
+
+// Close stops the proxy and closes all existing connections.
+func (p *Proxy) Close() error {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil
+	}
+	p.closed = true
+	conns := make([]*link, 0, len(p.conns))
+	for l := range p.conns {
+		conns = append(conns, l)
+	}
+	p.m.Unlock()
+
+	err := p.listener.Close()
+	for _, l := range conns {
+		l.close()
+	}
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) serve() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		p.m.Lock()
+		if p.closed {
+			p.m.Unlock()
+			_ = conn.Close()
+			return
+		}
+		upstream, toxics := p.upstream, p.toxics
+		l := &link{
+			downstream: conn,
+			done:       make(chan struct{}),
+		}
+		p.conns[l] = struct{}{}
+		p.wg.Add(1)
+		p.m.Unlock()
+
+		go func() {
+			defer p.wg.Done()
+			defer func() {
+				p.m.Lock()
+				delete(p.conns, l)
+				p.m.Unlock()
+			}()
+			if toxics.Reset {
+				l.reset()
+				return
+			}
+			l.run(p, upstream)
+		}()
+	}
+}
+
+// link is a pair of the downstream and upstream connections.
+type link struct {
+	downstream net.Conn
+	upstream   net.Conn
+
+	m    sync.Mutex
+	once sync.Once
+	done chan struct{}
+}
+
+func (l *link) run(p *Proxy, upstream string) {
+	conn, err := net.Dial("tcp", upstream)
+	if err != nil {
+		l.reset()
+		return
+	}
+	l.m.Lock()
+	l.upstream = conn
+	l.m.Unlock()
+	select {
+	case <-l.done: // closed while dialing
+		_ = conn.Close()
+		return
+	default:
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		l.pipe(p, conn, l.downstream)
+	}()
+	go func() {
+		defer wg.Done()
+		l.pipe(p, l.downstream, conn)
+	}()
+	wg.Wait()
+	l.close()
+}
+
+func (l *link) pipe(p *Proxy, dst, src net.Conn) {
+	buf := make([]byte, 32*1024)
+	var transferred int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			t := p.Toxics()
+			if t.Reset {
+				l.reset()
+				return
+			}
+			if t.LimitData > 0 && transferred >= t.LimitData {
+				// LimitData may be lowered below the transferred bytes at runtime
+				l.close()
+				return
+			}
+			data := buf[:n]
+			if t.LimitData > 0 && transferred+int64(len(data)) > t.LimitData {
+				data = data[:t.LimitData-transferred]
+			}
+			if !t.Blackhole {
+				if !l.sleep(delay(t, len(data))) {
+					return
+				}
+				if _, err := dst.Write(data); err != nil {
+					l.close()
+					return
+				}
+			}
+			transferred += int64(len(data))
+			if t.LimitData > 0 && transferred >= t.LimitData {
+				l.close()
+				return
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			// propagate half-close
+			if c, ok := dst.(*net.TCPConn); ok {
+				_ = c.CloseWrite()
+				return
+			}
+			l.close()
+			return
+		} else if err != nil {
+			l.close()
+			return
+		}
+	}
+}
+
+// delay calculates the delay to transfer the data of size n with the toxics.
+func delay(t Toxics, n int) time.Duration {
+	d := t.Latency
+	if t.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*t.Jitter+1))) - t.Jitter
+	}
+	if t.Bandwidth > 0 {
+		d += time.Duration(int64(n) * int64(time.Second) / t.Bandwidth)
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// sleep returns false if the link is closed while sleeping.
+func (l *link) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// reset closes the connections with TCP RST.
+func (l *link) reset() {
+	for _, conn := range []net.Conn{l.downstream, l.upstreamConn()} {
+		if c, ok := conn.(*net.TCPConn); ok {
+			_ = c.SetLinger(0)
+		}
+	}
+	l.close()
+}
+
+func (l *link) close() {
+	l.once.Do(func() {
+		close(l.done)
+		_ = l.downstream.Close()
+		if conn := l.upstreamConn(); conn != nil {
+			_ = conn.Close()
+		}
+	})
+}
+
+func (l *link) upstreamConn() net.Conn {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.upstream
+}
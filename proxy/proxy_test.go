@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startEchoServer starts the server that echoes each line.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func startProxy(t *testing.T) *Proxy {
+	t.Helper()
+
+	p, err := New(startEchoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+	return p
+}
+
+func dial(t *testing.T, p *Proxy) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+// echo sends msg and returns the response.
+func echo(conn net.Conn, msg string) (string, error) {
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	_, err := conn.Write([]byte(msg + "\n"))
+	if err != nil {
+		return "", err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return line, err
+	}
+	return line[:len(line)-1], nil
+}
+
+func TestProxy(t *testing.T) {
+	t.Parallel()
+
+	p := startProxy(t)
+	conn := dial(t, p)
+	got, err := echo(conn, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hello" {
+		t.Fatalf("unexpected response: %q", got)
+	}
+}
+
+func TestProxy_Latency(t *testing.T) {
+	t.Parallel()
+
+	p := startProxy(t)
+	conn := dial(t, p)
+	p.SetToxics(Toxics{
+		Latency: 100 * time.Millisecond,
+		Jitter:  10 * time.Millisecond,
+	})
+	start := time.Now()
+	if _, err := echo(conn, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	// the latency is applied to both directions
+	if d := time.Since(start); d < 180*time.Millisecond {
+		t.Fatalf("latency is not applied: %s", d)
+	}
+
+	p.ResetToxics()
+	start = time.Now()
+	if _, err := echo(conn, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d >= 100*time.Millisecond {
+		t.Fatalf("latency is not removed: %s", d)
+	}
+}
+
+func TestProxy_Bandwidth(t *testing.T) {
+	t.Parallel()
+
+	p := startProxy(t)
+	conn := dial(t, p)
+	p.SetToxics(Toxics{
+		Bandwidth: 100, // bytes per second
+	})
+	start := time.Now()
+	if _, err := echo(conn, "0123456789"); err != nil { // 11 bytes
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("bandwidth is not limited: %s", d)
+	}
+}
+
+func TestProxy_LimitData(t *testing.T) {
+	t.Parallel()
+
+	p := startProxy(t)
+	p.Update(func(t *Toxics) {
+		t.LimitData = 20
+	})
+	conn := dial(t, p)
+	if _, err := echo(conn, "0123456789"); err != nil { // 11 bytes
+		t.Fatal(err)
+	}
+	if _, err := echo(conn, "0123456789"); err == nil {
+		t.Fatal("connection is not closed")
+	}
+}
+
+func TestProxy_LimitData_lowered(t *testing.T) {
+	t.Parallel()
+
+	p := startProxy(t)
+	conn := dial(t, p)
+	if _, err := echo(conn, "0123456789"); err != nil { // 11 bytes
+		t.Fatal(err)
+	}
+	// lower the limit below the transferred bytes
+	p.Update(func(t *Toxics) {
+		t.LimitData = 5
+	})
+	if _, err := echo(conn, "0123456789"); err == nil {
+		t.Fatal("connection is not closed")
+	}
+
+	p.ResetToxics()
+	if _, err := echo(dial(t, p), "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProxy_Blackhole(t *testing.T) {
+	t.Parallel()
+
+	p := startProxy(t)
+	conn := dial(t, p)
+	p.SetToxics(Toxics{
+		Blackhole: true,
+	})
+	_ = conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := conn.Read(make([]byte, 1))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("timeout expected: %v", err)
+	}
+
+	p.ResetToxics()
+	got, err := echo(conn, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hello" {
+		t.Fatalf("unexpected response: %q", got)
+	}
+}
+
+func TestProxy_Reset(t *testing.T) {
+	t.Parallel()
+
+	p := startProxy(t)
+	conn := dial(t, p)
+	if _, err := echo(conn, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("ResetConnections", func(t *testing.T) {
+		p.ResetConnections()
+		_, err := echo(conn, "hello")
+		if !errors.Is(err, syscall.ECONNRESET) && !errors.Is(err, io.EOF) && !errors.Is(err, syscall.EPIPE) {
+			t.Fatalf("connection is not reset: %v", err)
+		}
+
+		// new connection is accepted
+		if _, err := echo(dial(t, p), "hello"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Toxics.Reset", func(t *testing.T) {
+		p.SetToxics(Toxics{
+			Reset: true,
+		})
+		if _, err := echo(dial(t, p), "hello"); err == nil {
+			t.Fatal("connection is not reset")
+		}
+
+		p.ResetToxics()
+		if _, err := echo(dial(t, p), "hello"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestProxy_Close(t *testing.T) {
+	t.Parallel()
+
+	p, err := New(startEchoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, p)
+	if _, err := echo(conn, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := echo(conn, "hello"); err == nil {
+		t.Fatal("connection is not closed")
+	}
+	if _, err := net.Dial("tcp", p.Addr()); err == nil {
+		t.Fatal("proxy is not closed")
+	}
+}
@@ -0,0 +1,35 @@
+package confort
+
+import (
+	"testing"
+	"time"
+
+	"github.com/daichitakahashi/confort/proxy"
+)
+
+func TestProxies_use(t *testing.T) {
+	t.Parallel()
+
+	p := newProxies()
+	t.Cleanup(func() {
+		_ = p.close()
+	})
+	px, err := p.get("container", "80/tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release1 := p.use([]*proxy.Proxy{px})
+	release2 := p.use([]*proxy.Proxy{px})
+	px.SetToxics(proxy.Toxics{
+		Latency: time.Second,
+	})
+
+	// the toxics are kept while other user is using the proxy
+	release1()
+	assertEqual(t, time.Second, px.Toxics().Latency)
+
+	// reset by the last user
+	release2()
+	assertEqual(t, time.Duration(0), px.Toxics().Latency)
+}
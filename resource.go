@@ -0,0 +1,161 @@
+package confort
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// Resource pairs the container with the client created from its host ports, such as
+// the connection pool of the database. The client is shared by the users of the
+// container in this process, and closed when the last user releases it.
+//
+// Create a Resource once per container, e.g. in TestMain, and share it among tests.
+type Resource[T any] struct {
+	c      *Container
+	create func(ctx context.Context, ports Ports) (T, error)
+	close  func(client T) error
+
+	m      sync.Mutex
+	client T
+	ports  Ports // used to create the client
+	refs   int
+}
+
+// NewResource creates Resource of the container c. create is called with the ports
+// returned by Container.Use when there are no users of the client, and close is called
+// when the last user releases it. If close is nil, the client is not closed.
+func NewResource[T any](c *Container, create func(ctx context.Context, ports Ports) (T, error), close func(client T) error) *Resource[T] {
+	return &Resource[T]{
+		c:      c,
+		create: create,
+		close:  close,
+	}
+}
+
+// Container returns the container of the resource.
+func (r *Resource[T]) Container() *Container {
+	return r.c
+}
+
+// Use acquires a lock for using the container like Container.Use, and returns the
+// client. If exclusive is true, it requires to use the container exclusively.
+//
+// The cached client is returned while other users of this process are using it.
+// When the ports differ from the ones used to create the cached client, e.g. because
+// of WithProxy, a dedicated client is created and closed at release.
+func (r *Resource[T]) Use(ctx context.Context, exclusive bool, opts ...UseOption) (T, ReleaseFunc, error) {
+	return r.use(ctx, "", exclusive, logReleaseError, opts...)
+}
+
+func (r *Resource[T]) use(ctx context.Context, test string, exclusive bool, reportErr func(error), opts ...UseOption) (client T, _ ReleaseFunc, err error) {
+	endpoints, releaseContainer, err := r.c.use(ctx, test, exclusive, reportErr, opts...)
+	if err != nil {
+		return client, nil, err
+	}
+	client, releaseClient, err := r.acquire(ctx, endpoints.Ports)
+	if err != nil {
+		releaseContainer()
+		return client, nil, err
+	}
+	return client, func() {
+		if err := releaseClient(); err != nil {
+			reportErr(err)
+		}
+		releaseContainer()
+	}, nil
+}
+
+// acquire returns the client for the ports and the function to release it.
+func (r *Resource[T]) acquire(ctx context.Context, ports Ports) (client T, _ func() error, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.refs > 0 && !reflect.DeepEqual(r.ports, ports) {
+		// dedicated client
+		client, err = r.create(ctx, ports)
+		if err != nil {
+			return client, nil, err
+		}
+		return client, func() error {
+			return r.closeClient(client)
+		}, nil
+	}
+
+	if r.refs == 0 {
+		client, err = r.create(ctx, ports)
+		if err != nil {
+			return client, nil, err
+		}
+		r.client = client
+		r.ports = ports
+	}
+	r.refs++
+	return r.client, r.release, nil
+}
+
+// release decrements the number of users of the cached client, and closes it when
+// the last user releases.
+func (r *Resource[T]) release() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.refs--
+	if r.refs > 0 {
+		return nil
+	}
+	client := r.client
+	var zero T
+	r.client = zero
+	r.ports = nil
+	return r.closeClient(client)
+}
+
+func (r *Resource[T]) closeClient(client T) error {
+	if r.close == nil {
+		return nil
+	}
+	return r.close(client)
+}
+
+func (r *Resource[T]) useT(t testing.TB, ctx context.Context, exclusive bool, opts ...UseOption) T {
+	t.Helper()
+	client, release, err := r.use(ctx, t.Name(), exclusive, func(err error) {
+		t.Error(err)
+	}, opts...)
+	if err != nil {
+		mode := "shared"
+		if exclusive {
+			mode = "exclusive"
+		}
+		t.Fatalf("failed to use %s resource of container %q: %s (%s)", mode, r.c.alias, err, r.c.cft.holders.describe(r.c.name))
+	}
+	t.Cleanup(release)
+	r.c.registerLogDump(t, logDumpDir(opts))
+	return client
+}
+
+// UseExclusive acquires an exclusive lock for using the container explicitly and returns the client.
+func (r *Resource[T]) UseExclusive(ctx context.Context, opts ...UseOption) (T, ReleaseFunc, error) {
+	return r.Use(ctx, true, opts...)
+}
+
+// UseShared acquires a shared lock for using the container explicitly and returns the client.
+func (r *Resource[T]) UseShared(ctx context.Context, opts ...UseOption) (T, ReleaseFunc, error) {
+	return r.Use(ctx, false, opts...)
+}
+
+// UseExclusiveT is similar to UseExclusive, but the lock is released and the client
+// is returned automatically at the end of the test.
+func (r *Resource[T]) UseExclusiveT(t testing.TB, ctx context.Context, opts ...UseOption) T {
+	t.Helper()
+	return r.useT(t, ctx, true, opts...)
+}
+
+// UseSharedT is similar to UseShared, but the lock is released and the client is
+// returned automatically at the end of the test.
+func (r *Resource[T]) UseSharedT(t testing.TB, ctx context.Context, opts ...UseOption) T {
+	t.Helper()
+	return r.useT(t, ctx, false, opts...)
+}
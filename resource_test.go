@@ -0,0 +1,96 @@
+package confort
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+type testClient struct {
+	addr   string
+	closed bool
+}
+
+func TestResource_acquire(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var created []*testClient
+	r := NewResource(nil, func(ctx context.Context, ports Ports) (*testClient, error) {
+		c := &testClient{addr: ports.HostPort("80/tcp")}
+		created = append(created, c)
+		return c, nil
+	}, func(c *testClient) error {
+		c.closed = true
+		return nil
+	})
+	ports := Ports{
+		"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "49153"}},
+	}
+	proxied := Ports{
+		"80/tcp": []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "49154"}},
+	}
+
+	c1, release1, err := r.acquire(ctx, ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, release2, err := r.acquire(ctx, ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Fatal("expected to share the client")
+	}
+
+	// dedicated client for the different ports
+	c3, release3, err := r.acquire(ctx, proxied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "127.0.0.1:49154", c3.addr)
+	if err := release3(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, true, c3.closed)
+
+	if err := release1(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, false, c1.closed)
+	if err := release2(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, true, c1.closed)
+
+	// new client after the last user released
+	c4, release4, err := r.acquire(ctx, proxied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c4 == c1 {
+		t.Fatal("expected to create new client")
+	}
+	if err := release4(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 3, len(created))
+}
+
+func TestResource_acquireError(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	createErr := errors.New("failed to create")
+	r := NewResource(nil, func(ctx context.Context, ports Ports) (*testClient, error) {
+		return nil, createErr
+	}, nil)
+
+	_, _, err := r.acquire(ctx, Ports{})
+	if !errors.Is(err, createErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, 0, r.refs)
+}
@@ -0,0 +1,66 @@
+package confort
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lestrrat-go/option"
+)
+
+type identOptionRestoreOnRelease struct{}
+
+// WithRestoreOnRelease restores the container from the snapshot created by
+// Container.Snapshot, when the exclusive lock is released. The next user of the
+// container proceeds after the restoration, so it can use the container in the same
+// state as the snapshot.
+//
+// This option requires the exclusive lock. If the restoration fails, the error is
+// reported via testing.TB with Container.UseExclusiveT and Acquirer.DoT, otherwise
+// written to the standard logger.
+func WithRestoreOnRelease(snapshot string) UseOption {
+	return useOption{
+		Interface: option.New(identOptionRestoreOnRelease{}, snapshot),
+	}.use()
+}
+
+// Snapshot records the current state of the container as the snapshot named name.
+// The filesystem of the container is committed as an image, and the contents of
+// the volumes mounted on the container are archived. The container is paused while
+// taking the snapshot.
+//
+// Snapshot requires the exclusive lock of the container acquired in this process,
+// e.g. in InitFunc, to take a consistent snapshot.
+// The snapshot is recorded as the labels of the committed image, so other processes
+// sharing the namespace with the beacon server can restore the container from it.
+// The snapshot is removed with the container, by Confort.Close or after all tests
+// with the beacon server.
+func (c *Container) Snapshot(ctx context.Context, name string) error {
+	return c.control(ctx, "snapshot", func(ctx context.Context) error {
+		return c.cft.namespace.SnapshotContainer(ctx, c.name, name)
+	})
+}
+
+// Restore restores the state of the container from the snapshot named name.
+// The container is recreated from the committed image with the same name, network
+// aliases and host ports, and the contents of the volumes are replaced with the
+// archived ones. As a result, the container ID changes, and Container.ID returns the
+// new one. Named volumes are also recreated, so they must not be used by other
+// containers.
+//
+// If the container was running, it is started and waited by its Waiter again.
+// Restore requires the exclusive lock of the container acquired in this process,
+// because it destroys the container used by others.
+func (c *Container) Restore(ctx context.Context, name string) error {
+	return c.control(ctx, "restore", func(ctx context.Context) error {
+		_, err := c.cft.namespace.RestoreContainer(ctx, c.name, name)
+		return err
+	})
+}
+
+func (c *Container) restoreOnRelease(snapshot string) error {
+	err := c.Restore(context.Background(), snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to restore container %q from snapshot %q: %w", c.alias, snapshot, err)
+	}
+	return nil
+}
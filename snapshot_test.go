@@ -0,0 +1,186 @@
+package confort
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestContainer_Snapshot(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	namespace := t.Name()
+	cft, err := New(ctx,
+		WithNamespace(namespace, true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	c, err := cft.Run(ctx, &ContainerParams{
+		Name:       "snapshot",
+		Image:      "alpine:3.16.2",
+		Entrypoint: []string{"sleep", "infinity"},
+		Mounts: []mount.Mount{
+			{Type: mount.TypeVolume, Target: "/data"},
+		},
+	}, WithPullOptions(&types.ImagePullOptions{}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh := func(t *testing.T, script string) string {
+		t.Helper()
+		ce, err := c.CreateExec(ctx, []string{"sh", "-c", script})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ce.Output(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	const state = "cat /etc/state /data/state 2>/dev/null; ls /data"
+
+	// take snapshot in InitFunc
+	_, release, err := c.UseShared(ctx, WithInitFunc(func(ctx context.Context, _ Ports) error {
+		sh(t, "echo -n fs > /etc/state && echo -n volume > /data/state")
+		return c.Snapshot(ctx, "initial")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	initialState := sh(t, state)
+	if err := c.Snapshot(ctx, "initial"); err == nil {
+		t.Fatal("snapshot without exclusive lock must be an error")
+	}
+	release()
+
+	t.Run("duplicated snapshot", func(t *testing.T) {
+		_, release, err := c.UseExclusive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		if err := c.Snapshot(ctx, "initial"); err == nil {
+			t.Fatal("duplicated snapshot must be an error")
+		}
+	})
+
+	t.Run("without exclusive lock", func(t *testing.T) {
+		if err := c.Restore(ctx, "initial"); err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+		_, release, err := c.UseShared(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		if err := c.Restore(ctx, "initial"); err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		_, release, err := c.UseExclusive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+
+		oldID := c.ID()
+		sh(t, "echo -n dirty > /etc/state && rm /data/state && touch /data/dirty")
+		if s := sh(t, state); s == initialState {
+			t.Fatalf("state is not changed: %q", s)
+		}
+
+		err = c.Restore(ctx, "initial")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ID() == oldID {
+			t.Fatal("container is not recreated")
+		}
+		if s := sh(t, state); s != initialState {
+			t.Fatalf("unexpected state: want %q, got %q", initialState, s)
+		}
+	})
+
+	t.Run("WithRestoreOnRelease", func(t *testing.T) {
+		_, release, err := c.UseExclusive(ctx, WithRestoreOnRelease("initial"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sh(t, "echo -n dirty > /data/state")
+		release()
+
+		_, release, err = c.UseShared(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		if s := sh(t, state); s != initialState {
+			t.Fatalf("unexpected state: want %q, got %q", initialState, s)
+		}
+	})
+
+	t.Run("shared lock", func(t *testing.T) {
+		_, _, err := c.UseShared(ctx, WithRestoreOnRelease("initial"))
+		if err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+
+	t.Run("unknown snapshot", func(t *testing.T) {
+		_, release, err := c.UseExclusive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		err = c.Restore(ctx, "unknown")
+		if err == nil {
+			t.Fatal("error expected but succeeded")
+		}
+	})
+
+	t.Run("other process", func(t *testing.T) {
+		// the snapshot is found by the other Confort sharing the namespace
+		other, err := New(ctx,
+			WithNamespace(namespace, true),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = other.Close()
+		}()
+		oc, err := other.Run(ctx, &ContainerParams{
+			Name:       "snapshot",
+			Image:      "alpine:3.16.2",
+			Entrypoint: []string{"sleep", "infinity"},
+			Mounts: []mount.Mount{
+				{Type: mount.TypeVolume, Target: "/data"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, release, err := oc.UseExclusive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		err = oc.Restore(ctx, "initial")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
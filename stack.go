@@ -0,0 +1,254 @@
+package confort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/daichitakahashi/confort/internal/logging"
+	"github.com/daichitakahashi/confort/wait"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"golang.org/x/sync/errgroup"
+)
+
+// DependencyCondition is the condition of the prerequisite container that
+// the dependent container waits for before it starts.
+type DependencyCondition string
+
+const (
+	// DependencyStarted waits until the prerequisite container is started and
+	// its Waiter succeeds. This is the default condition.
+	DependencyStarted DependencyCondition = "started"
+	// DependencyHealthy waits until the health status of the prerequisite container
+	// becomes healthy, in addition to DependencyStarted.
+	DependencyHealthy DependencyCondition = "healthy"
+	// DependencyCompleted waits until the prerequisite container exits with status 0.
+	// It is suitable for one-shot containers such as migrators.
+	// The container that dependents wait for its completion is created without AutoRemove,
+	// to check its exit status after exit. Once it has completed, it is not started
+	// again by other processes sharing the beacon server, regardless of
+	// WithStoppedContainerPolicy.
+	DependencyCompleted DependencyCondition = "completed"
+)
+
+// labelOneShot is attached to the container that dependents wait for its completion.
+const labelOneShot = "daichitakahashi.confort.oneshot"
+
+// Dependency declares that the container depends on the container named Name.
+type Dependency struct {
+	Name      string
+	Condition DependencyCondition
+}
+
+// StackEntry is a container definition in Stack.
+type StackEntry struct {
+	ContainerParams
+	// DependsOn is the list of the containers that must satisfy each condition
+	// before this container starts.
+	DependsOn []Dependency
+	// Options are passed to Confort.Run.
+	Options []RunOption
+	// Volumes are the names of the volumes created by Confort.Volume before the
+	// container starts. The mounts in ContainerParams.Mounts whose Source is one of
+	// them use the created volume instead.
+	Volumes []string
+}
+
+// Stack is a set of containers that can depend on each other.
+// The name of each container is specified by ContainerParams.Name, and it must
+// be unique in the Stack.
+type Stack []*StackEntry
+
+func (s Stack) resolve() (map[string]*StackEntry, error) {
+	entries := make(map[string]*StackEntry, len(s))
+	for _, e := range s {
+		if e == nil {
+			return nil, errors.New("stack: nil entry")
+		}
+		if e.Name == "" {
+			return nil, errors.New("stack: empty container name")
+		}
+		if _, ok := entries[e.Name]; ok {
+			return nil, fmt.Errorf("stack: duplicate container name %q", e.Name)
+		}
+		entries[e.Name] = e
+	}
+
+	for _, e := range s {
+		for _, dep := range e.DependsOn {
+			if _, ok := entries[dep.Name]; !ok {
+				return nil, fmt.Errorf("stack: %q depends on unknown container %q", e.Name, dep.Name)
+			}
+			switch dep.Condition {
+			case "", DependencyStarted, DependencyHealthy, DependencyCompleted:
+			default:
+				return nil, fmt.Errorf("stack: unknown dependency condition %q: %s -> %s", dep.Condition, e.Name, dep.Name)
+			}
+		}
+	}
+
+	// detect circular dependency
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("stack: circular dependency found: %v", append(path, name))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range entries[name].DependsOn {
+			if err := visit(dep.Name, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, e := range s {
+		if err := visit(e.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// RunStack starts all containers in the Stack and returns them keyed by its name.
+// Containers without dependencies start in parallel, and each dependent container
+// starts after all of its prerequisites satisfy the conditions declared in DependsOn.
+//
+// Each container is started by Confort.Run, so parallelized tests that share the
+// beacon server start each container only once.
+func (cft *Confort) RunStack(ctx context.Context, s Stack) (map[string]*Container, error) {
+	entries, err := s.resolve()
+	if err != nil {
+		return nil, fmt.Errorf("confort: %w", err)
+	}
+
+	// one-shot containers must remain after exit to check its status
+	completed := map[string]bool{}
+	for _, e := range s {
+		for _, dep := range e.DependsOn {
+			if dep.Condition == DependencyCompleted {
+				completed[dep.Name] = true
+			}
+		}
+	}
+
+	var (
+		m          sync.Mutex
+		containers = make(map[string]*Container, len(s))
+		started    = make(map[string]chan struct{}, len(s))
+	)
+	for name := range entries {
+		started[name] = make(chan struct{})
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, e := range s {
+		e := e
+		eg.Go(func() error {
+			for _, dep := range e.DependsOn {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-started[dep.Name]:
+				}
+				m.Lock()
+				c := containers[dep.Name]
+				m.Unlock()
+
+				logging.Debugf("wait for dependency of %s: %s(%s)", e.Name, dep.Name, dep.Condition)
+				err := c.waitCondition(ctx, dep.Condition)
+				if err != nil {
+					return fmt.Errorf("confort: dependency %q of %q: %w", dep.Name, e.Name, err)
+				}
+			}
+
+			opts := e.Options
+			if completed[e.Name] {
+				opts = append(opts[:len(opts):len(opts)], oneShot())
+			}
+			params, err := cft.stackParams(ctx, e)
+			if err != nil {
+				return err
+			}
+			c, err := cft.Run(ctx, params, opts...)
+			if err != nil {
+				return err
+			}
+			m.Lock()
+			containers[e.Name] = c
+			m.Unlock()
+			close(started[e.Name])
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// stackParams returns ContainerParams of the entry, whose mounts of Volumes are
+// replaced with the volumes created in the namespace.
+func (cft *Confort) stackParams(ctx context.Context, e *StackEntry) (*ContainerParams, error) {
+	if len(e.Volumes) == 0 {
+		return &e.ContainerParams, nil
+	}
+	volumes := make(map[string]string, len(e.Volumes))
+	for _, name := range e.Volumes {
+		m, err := cft.Volume(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		volumes[name] = m.Source
+	}
+	params := e.ContainerParams
+	params.Mounts = make([]mount.Mount, len(e.Mounts))
+	for i, m := range e.Mounts {
+		if source, ok := volumes[m.Source]; ok && m.Type == mount.TypeVolume {
+			m.Source = source
+		}
+		params.Mounts[i] = m
+	}
+	return &params, nil
+}
+
+func (c *Container) waitCondition(ctx context.Context, cond DependencyCondition) error {
+	switch cond {
+	case DependencyHealthy:
+		return wait.Healthy().Wait(ctx, &fetcher{
+			cli:         c.cft.cli,
+			containerID: c.name, // the id can be changed by Container.Restore
+			ports:       nat.PortMap(c.ports),
+		})
+	case DependencyCompleted:
+		ctx, cancel := applyTimeout(ctx, c.cft.defaultTimeout)
+		defer cancel()
+
+		respC, errC := c.cft.cli.ContainerWait(ctx, c.name, container.WaitConditionNotRunning)
+		select {
+		case resp := <-respC:
+			if resp.Error != nil {
+				return errors.New(resp.Error.Message)
+			}
+			if resp.StatusCode != 0 {
+				return fmt.Errorf("container %q exited with status %d", c.name, resp.StatusCode)
+			}
+			return nil
+		case err := <-errC:
+			return err
+		}
+	default:
+		return nil
+	}
+}
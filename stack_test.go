@@ -0,0 +1,204 @@
+package confort_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/daichitakahashi/confort"
+	"github.com/daichitakahashi/confort/wait"
+	"github.com/docker/docker/client"
+)
+
+func TestConfort_RunStack(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	containers, err := cft.RunStack(ctx, confort.Stack{
+		{
+			ContainerParams: confort.ContainerParams{
+				Name:  "two",
+				Image: imageCommunicator,
+				Env: map[string]string{
+					"CM_TARGET": "one",
+				},
+				ExposedPorts: []string{"80/tcp"},
+				Waiter:       wait.LogContains("communicator is ready", 1),
+			},
+			DependsOn: []confort.Dependency{
+				{Name: "one", Condition: confort.DependencyHealthy},
+			},
+		}, {
+			ContainerParams: confort.ContainerParams{
+				Name:  "one",
+				Image: imageCommunicator,
+				Env: map[string]string{
+					"CM_TARGET": "two",
+				},
+				ExposedPorts: []string{"80/tcp"},
+				Waiter:       wait.LogContains("communicator is ready", 1),
+			},
+			DependsOn: []confort.Dependency{
+				{Name: "migrate", Condition: confort.DependencyCompleted},
+			},
+		}, {
+			ContainerParams: confort.ContainerParams{
+				Name:       "migrate",
+				Image:      "alpine:3.16.2",
+				Entrypoint: []string{"true"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 3 {
+		t.Fatalf("unexpected number of containers: %d", len(containers))
+	}
+
+	ports, release, err := confort.Acquire().
+		UseExclusive(containers["one"]).
+		UseExclusive(containers["two"]).
+		Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(release)
+	hostOne := ports[containers["one"]].HostPort("80/tcp")
+	hostTwo := ports[containers["two"]].HostPort("80/tcp")
+
+	communicate(t, hostOne, "set", "one")
+	communicate(t, hostTwo, "set", "two")
+	communicate(t, hostOne, "exchange", "")
+	if s := communicate(t, hostOne, "get", ""); s != "two" {
+		t.Fatalf("one: expected status is %q, but actual %q", "two", s)
+	}
+}
+
+func TestConfort_RunStack_completedInOtherProcess(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	namespace := uniqueName.Must(t)
+	stack := confort.Stack{
+		{
+			ContainerParams: confort.ContainerParams{
+				Name:  "echo",
+				Image: imageEcho,
+			},
+			DependsOn: []confort.Dependency{
+				{Name: "migrate", Condition: confort.DependencyCompleted},
+			},
+		}, {
+			ContainerParams: confort.ContainerParams{
+				Name:       "migrate",
+				Image:      "alpine:3.16.2",
+				Entrypoint: []string{"true"},
+			},
+		},
+	}
+	runStack := func(t *testing.T) map[string]*confort.Container {
+		t.Helper()
+		cft, err := confort.New(ctx,
+			confort.WithNamespace(namespace, true),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = cft.Close()
+		})
+		containers, err := cft.RunStack(ctx, stack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return containers
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	startedAt := func(t *testing.T, c *confort.Container) string {
+		t.Helper()
+		info, err := cli.ContainerInspect(ctx, c.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.State.StartedAt
+	}
+
+	// the second one reuses the completed container without starting it again
+	first := runStack(t)
+	second := runStack(t)
+	if first["migrate"].ID() != second["migrate"].ID() {
+		t.Fatal("expected to reuse the completed container")
+	}
+	if startedAt(t, first["migrate"]) != startedAt(t, second["migrate"]) {
+		t.Fatal("expected not to start the completed container again")
+	}
+}
+
+func TestConfort_RunStack_Invalid(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	cft, err := confort.New(ctx,
+		confort.WithNamespace(uniqueName.Must(t), true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cft.Close()
+	})
+
+	entry := func(name string, deps ...string) *confort.StackEntry {
+		e := &confort.StackEntry{
+			ContainerParams: confort.ContainerParams{
+				Name:  name,
+				Image: imageEcho,
+			},
+		}
+		for _, dep := range deps {
+			e.DependsOn = append(e.DependsOn, confort.Dependency{Name: dep})
+		}
+		return e
+	}
+
+	testCases := map[string]confort.Stack{
+		"unknown dependency": {
+			entry("a", "b"),
+		},
+		"duplicate name": {
+			entry("a"),
+			entry("a"),
+		},
+		"circular dependency": {
+			entry("a", "c"),
+			entry("b", "a"),
+			entry("c", "b"),
+		},
+	}
+	for desc, s := range testCases {
+		s := s
+		t.Run(desc, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := cft.RunStack(ctx, s)
+			if err == nil {
+				t.Fatal("unexpected success")
+			}
+			t.Log(err)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package confort
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/daichitakahashi/confort/internal/logging"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Volume creates the named volume "<namespace>-<name>" if not exists, and returns
+// the mount.Mount of the volume. Set its Target and use it in ContainerParams.Mounts:
+//
+//	m, err := cft.Volume(ctx, "data")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	m.Target = "/var/lib/postgresql/data"
+//	db, err := cft.Run(ctx, &confort.ContainerParams{
+//		Name:   "db",
+//		Image:  "postgres:14.5",
+//		Mounts: []mount.Mount{m},
+//	})
+//
+// Like the network created in New, the volume is labelled, and removed by Close
+// according to ResourcePolicy. The "confort" command also removes it on cleanup.
+func (cft *Confort) Volume(ctx context.Context, name string) (mount.Mount, error) {
+	ctx, cancel := applyTimeout(ctx, cft.defaultTimeout)
+	defer cancel()
+
+	logging.Debug("acquire LockForNamespace")
+	unlock, err := cft.ex.LockForNamespace(ctx)
+	if err != nil {
+		return mount.Mount{}, fmt.Errorf("confort: %w", err)
+	}
+	defer func() {
+		logging.Debug("release LockForNamespace")
+		unlock()
+	}()
+
+	logging.Debugf("create volume %q", name)
+	volumeName, err := cft.namespace.CreateVolume(ctx, name)
+	if err != nil {
+		return mount.Mount{}, fmt.Errorf("confort: %w", err)
+	}
+	return mount.Mount{
+		Type:   mount.TypeVolume,
+		Source: volumeName,
+	}, nil
+}